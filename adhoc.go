@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultKeyFiles are tried, in order, for ad-hoc transfers without SSH_KEY_FILE
+var defaultKeyFiles = []string{"~/.ssh/id_ed25519", "~/.ssh/id_ecdsa", "~/.ssh/id_rsa"}
+
+// parseRemoteSpec splits an scp-style user@host:path argument. An empty path
+// means the remote home directory.
+func parseRemoteSpec(arg string) (user, host, folder string, ok bool) {
+	at := strings.Index(arg, "@")
+	if at <= 0 || strings.HasPrefix(arg, "-") {
+		return "", "", "", false
+	}
+	host, folder, found := strings.Cut(arg[at+1:], ":")
+	if !found || host == "" {
+		return "", "", "", false
+	}
+	if folder == "" {
+		folder = "~/"
+	}
+	return arg[:at], host, folder, true
+}
+
+// applyRemoteSpec handles "pooshit push ./dist user@host:/srv/app": when one
+// of the positional arguments is a remote spec, the run needs no config file
+// and the other argument, if any, is the local folder. Auth defaults to the
+// SSH agent and the usual key files, and without a DOCKER_IMAGE_NAME it is a
+// plain file transfer. Flags always win over these defaults. It reports
+// whether a remote spec was found.
+func applyRemoteSpec(positional []string, overrides map[string]string) (bool, error) {
+	var local []string
+	found := false
+	for _, arg := range positional {
+		user, host, folder, ok := parseRemoteSpec(arg)
+		if !ok {
+			local = append(local, arg)
+			continue
+		}
+		if found {
+			return false, fmt.Errorf("only one user@host:path argument is allowed, got another in %q", arg)
+		}
+		found = true
+		setDefault(overrides, "SSH_USERNAME", user)
+		setDefault(overrides, "REMOTE_SERVER", host)
+		setDefault(overrides, "REMOTE_FOLDER", folder)
+	}
+	if !found {
+		return false, nil
+	}
+
+	if len(local) > 1 {
+		return false, fmt.Errorf("expected a local folder and user@host:path, got %s", strings.Join(positional, " "))
+	}
+	if len(local) == 1 {
+		setDefault(overrides, "LOCAL_FOLDER", local[0])
+	}
+
+	setDefault(overrides, "AUTH_ORDER", "agent,key")
+	var keyFiles []string
+	for _, keyFile := range defaultKeyFiles {
+		if _, err := os.Stat(expandLocalHome(keyFile)); err == nil {
+			keyFiles = append(keyFiles, keyFile)
+		}
+	}
+	if len(keyFiles) > 0 {
+		setDefault(overrides, "SSH_KEY_FILE", strings.Join(keyFiles, ", "))
+	}
+	if _, ok := overrides["DOCKER_IMAGE_NAME"]; !ok {
+		setDefault(overrides, "SYNC_ONLY", "true")
+	}
+	return true, nil
+}
+
+// setDefault sets a config override unless a flag already set it
+func setDefault(overrides map[string]string, key, value string) {
+	if _, ok := overrides[key]; !ok {
+		overrides[key] = value
+	}
+}
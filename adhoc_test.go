@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRemoteSpec(t *testing.T) {
+	tests := []struct {
+		arg                string
+		user, host, folder string
+		ok                 bool
+	}{
+		{arg: "deploy@example.com:/srv/app", user: "deploy", host: "example.com", folder: "/srv/app", ok: true},
+		{arg: "deploy@example.com:", user: "deploy", host: "example.com", folder: "~/", ok: true},
+		{arg: "deploy@example.com:releases/v2", user: "deploy", host: "example.com", folder: "releases/v2", ok: true},
+		{arg: "deploy@10.0.0.5:/srv/a:b", user: "deploy", host: "10.0.0.5", folder: "/srv/a:b", ok: true},
+		{arg: "./dist"},
+		{arg: "example.com:/srv/app"},
+		{arg: "@example.com:/srv/app"},
+		{arg: "deploy@:/srv/app"},
+		{arg: "deploy@example.com"},
+		{arg: "-user@host:/x"},
+	}
+	for _, tt := range tests {
+		user, host, folder, ok := parseRemoteSpec(tt.arg)
+		if ok != tt.ok || user != tt.user || host != tt.host || folder != tt.folder {
+			t.Errorf("parseRemoteSpec(%q) = %q, %q, %q, %v, want %q, %q, %q, %v",
+				tt.arg, user, host, folder, ok, tt.user, tt.host, tt.folder, tt.ok)
+		}
+	}
+}
+
+func TestApplyRemoteSpec(t *testing.T) {
+	// No key files in the home directory, so only the agent is set up
+	t.Setenv("HOME", t.TempDir())
+	tests := []struct {
+		name       string
+		positional []string
+		overrides  map[string]string
+		found      bool
+		wantErr    bool
+		want       map[string]string
+	}{
+		{name: "no remote", positional: []string{"./dist"}, want: map[string]string{}},
+		{
+			name:       "local and remote",
+			positional: []string{"./dist", "deploy@example.com:/srv/app"},
+			found:      true,
+			want: map[string]string{
+				"SSH_USERNAME": "deploy", "REMOTE_SERVER": "example.com", "REMOTE_FOLDER": "/srv/app",
+				"LOCAL_FOLDER": "./dist", "AUTH_ORDER": "agent,key", "SYNC_ONLY": "true",
+			},
+		},
+		{
+			name:       "flags win",
+			positional: []string{"deploy@example.com:/srv/app"},
+			overrides:  map[string]string{"REMOTE_FOLDER": "/srv/other", "DOCKER_IMAGE_NAME": "app"},
+			found:      true,
+			want: map[string]string{
+				"SSH_USERNAME": "deploy", "REMOTE_SERVER": "example.com", "REMOTE_FOLDER": "/srv/other",
+				"AUTH_ORDER": "agent,key", "DOCKER_IMAGE_NAME": "app",
+			},
+		},
+		{name: "two remotes", positional: []string{"a@one:/x", "b@two:/y"}, wantErr: true},
+		{name: "two local folders", positional: []string{"./a", "./b", "deploy@example.com:/srv/app"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		overrides := map[string]string{}
+		for key, value := range tt.overrides {
+			overrides[key] = value
+		}
+		found, err := applyRemoteSpec(tt.positional, overrides)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if found != tt.found {
+			t.Errorf("%s: found = %v, want %v", tt.name, found, tt.found)
+		}
+		if !reflect.DeepEqual(overrides, tt.want) {
+			t.Errorf("%s: overrides = %v, want %v", tt.name, overrides, tt.want)
+		}
+	}
+}
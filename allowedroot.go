@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// allowedRoot returns ALLOWED_REMOTE_ROOT resolved like REMOTE_FOLDER, or ""
+// when it isn't set
+func (sm *SyncManager) allowedRoot() (string, error) {
+	if sm.config.AllowedRemoteRoot == "" {
+		return "", nil
+	}
+	root, err := sm.resolveRemotePath(sm.config.AllowedRemoteRoot)
+	if err != nil {
+		return "", err
+	}
+	return path.Clean(root), nil
+}
+
+// checkAllowedRoot refuses to write to a remote path outside
+// ALLOWED_REMOTE_ROOT, e.g. a REMOTE_FOLDER of "/" or "~/../../etc". The path
+// is cleaned first, so ".." can't climb out of the root.
+func (sm *SyncManager) checkAllowedRoot(remotePath string) error {
+	root, err := sm.allowedRoot()
+	if err != nil || root == "" {
+		return err
+	}
+	cleaned := path.Clean(remotePath)
+	if !path.IsAbs(cleaned) {
+		return fmt.Errorf("refusing to use %q: with ALLOWED_REMOTE_ROOT, remote paths must resolve to absolute paths", remotePath)
+	}
+	if cleaned != root && !strings.HasPrefix(cleaned, strings.TrimSuffix(root, "/")+"/") {
+		return fmt.Errorf("refusing to use %s: it is outside ALLOWED_REMOTE_ROOT %s", cleaned, root)
+	}
+	return nil
+}
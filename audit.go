@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// auditedSession checks and logs each command before it reaches the remote,
+// per AUDIT_COMMANDS and COMMAND_ALLOWLIST
+type auditedSession struct {
+	remoteSession
+	sm *SyncManager
+}
+
+func (s *auditedSession) Output(cmd string) ([]byte, error) {
+	if err := s.sm.auditCommand(cmd); err != nil {
+		return nil, err
+	}
+	return s.remoteSession.Output(cmd)
+}
+
+func (s *auditedSession) CombinedOutput(cmd string) ([]byte, error) {
+	if err := s.sm.auditCommand(cmd); err != nil {
+		return nil, err
+	}
+	return s.remoteSession.CombinedOutput(cmd)
+}
+
+func (s *auditedSession) Start(cmd string) error {
+	if err := s.sm.auditCommand(cmd); err != nil {
+		return err
+	}
+	return s.remoteSession.Start(cmd)
+}
+
+// Signal forwards a signal to the remote command, if the session can
+func (s *auditedSession) Signal(sig ssh.Signal) error {
+	if signaler, ok := s.remoteSession.(interface{ Signal(ssh.Signal) error }); ok {
+		return signaler.Signal(sig)
+	}
+	return fmt.Errorf("this session can't forward signals")
+}
+
+// stdinPiper is a session that can feed input to its command
+type stdinPiper interface {
+	StdinPipe() (io.WriteCloser, error)
+}
+
+// StdinPipe gives access to the remote command's input, if the session can
+func (s *auditedSession) StdinPipe() (io.WriteCloser, error) {
+	if piper, ok := s.remoteSession.(stdinPiper); ok {
+		return piper.StdinPipe()
+	}
+	return nil, fmt.Errorf("this session can't send input to the remote command")
+}
+
+// auditCommand logs a remote command with AUDIT_COMMANDS and, with
+// COMMAND_ALLOWLIST, refuses it unless every simple command in it starts
+// with one of the allowed prefixes
+func (sm *SyncManager) auditCommand(cmd string) error {
+	if len(sm.config.CommandAllowlist) > 0 {
+		if err := checkAllowlist(cmd, sm.config.CommandAllowlist); err != nil {
+			log.Printf("🛡️  Refused remote command: %s", cmd)
+			return fmt.Errorf("remote command not in COMMAND_ALLOWLIST: %s (%w)", cmd, err)
+		}
+	}
+	if sm.config.AuditCommands {
+		log.Printf("🛡️  Remote command: %s", cmd)
+	}
+	return nil
+}
+
+// checkAllowlist checks each simple command of a command line against the
+// allowed prefixes, so an allowed command can't smuggle in another one
+// after a ; or && or through a pipe
+func checkAllowlist(cmd string, allowlist []string) error {
+	commands, err := simpleCommands(cmd)
+	if err != nil {
+		return err
+	}
+	for _, command := range commands {
+		allowed := false
+		for _, prefix := range allowlist {
+			if hasCommandPrefix(command, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%q starts with none of its prefixes", command)
+		}
+	}
+	return nil
+}
+
+// hasCommandPrefix reports whether a command is prefix or starts with it
+// followed by whitespace, so "docker ps" doesn't also allow "docker psx"
+func hasCommandPrefix(command, prefix string) bool {
+	if !strings.HasPrefix(command, prefix) {
+		return false
+	}
+	rest := command[len(prefix):]
+	return rest == "" || strings.TrimLeft(rest, " \t") != rest
+}
+
+// compoundWords open or close a compound command; they are dropped from
+// the start of a simple command, so the command after them is checked
+var compoundWords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "fi": true,
+	"while": true, "until": true, "do": true, "done": true,
+	"{": true, "}": true, "!": true,
+}
+
+// simpleCommands splits a shell command line into its simple commands at
+// the operators outside quotes: ; & && | || newlines and parentheses.
+// Redirections such as 2>&1 stay part of their command. Command and process
+// substitution are refused, as the commands inside them can't be checked.
+func simpleCommands(line string) ([]string, error) {
+	var commands []string
+	var current strings.Builder
+	flush := func() {
+		words := strings.Fields(current.String())
+		for len(words) > 0 && compoundWords[words[0]] {
+			words = words[1:]
+		}
+		if len(words) > 0 {
+			commands = append(commands, strings.Join(words, " "))
+		}
+		current.Reset()
+	}
+
+	inSingle, inDouble := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		next := byte(0)
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+		switch {
+		case inSingle:
+			inSingle = c != '\''
+		case c == '\\':
+			// The escaped character is taken as it is
+			current.WriteByte(c)
+			if next != 0 {
+				i++
+				c = next
+			}
+		case c == '`' || c == '$' && next == '(':
+			return nil, fmt.Errorf("command substitution can't be checked")
+		case inDouble:
+			inDouble = c != '"'
+		case c == '\'':
+			inSingle = true
+		case c == '"':
+			inDouble = true
+		case (c == '<' || c == '>') && next == '(':
+			return nil, fmt.Errorf("process substitution can't be checked")
+		case c == '&' && (next == '>' || i > 0 && (line[i-1] == '>' || line[i-1] == '<')):
+			// &> and >&2 are redirections, not the & operator
+		case c == ';' || c == '&' || c == '|' || c == '\n' || c == '(' || c == ')':
+			flush()
+			continue
+		}
+		current.WriteByte(c)
+	}
+	if inSingle || inDouble {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	flush()
+	return commands, nil
+}
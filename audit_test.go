@@ -0,0 +1,78 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSimpleCommands(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "sudo docker ps -aq", want: []string{"sudo docker ps -aq"}},
+		{line: "printf x; rm -rf /", want: []string{"printf x", "rm -rf /"}},
+		{line: "cd /srv/app && sudo docker build -t app .", want: []string{"cd /srv/app", "sudo docker build -t app ."}},
+		{line: "a || b | c & d", want: []string{"a", "b", "c", "d"}},
+		{line: "printf x\nrm -rf /", want: []string{"printf x", "rm -rf /"}},
+		{line: "(rm -rf /)", want: []string{"rm -rf /"}},
+		{line: "{ docker ps -q; } | xargs -r docker stop", want: []string{"docker ps -q", "xargs -r docker stop"}},
+		{line: "if [ -e f ]; then echo 'File exists' >&2; exit 1; fi; mv -- a b", want: []string{"[ -e f ]", "echo 'File exists' >&2", "exit 1", "mv -- a b"}},
+		{line: "docker rmi -f app 2>/dev/null || true", want: []string{"docker rmi -f app 2>/dev/null", "true"}},
+		{line: "build &>/dev/null", want: []string{"build &>/dev/null"}},
+		{line: `printf '%s; rm -rf /' "a && b"`, want: []string{`printf '%s; rm -rf /' "a && b"`}},
+		{line: `printf a\;b`, want: []string{`printf a\;b`}},
+		{line: "printf $(rm -rf /)", wantErr: true},
+		{line: "printf `rm -rf /`", wantErr: true},
+		{line: `printf "$(rm -rf /)"`, wantErr: true},
+		{line: "diff <(ls) x", wantErr: true},
+		{line: "printf 'unterminated", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := simpleCommands(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("simpleCommands(%q) = %q, want an error", tt.line, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("simpleCommands(%q) = %q, %v; want %q", tt.line, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckAllowlist(t *testing.T) {
+	allowlist := []string{"printf", "cd /srv/app", "sudo docker build", "sudo docker ps", "xargs -r sudo docker stop"}
+	tests := []struct {
+		cmd     string
+		allowed bool
+	}{
+		{"printf '%s\\n' \"$HOME\"", true},
+		{"cd /srv/app && sudo docker build -t app .", true},
+		{"sudo docker ps -aq --filter ancestor=app | xargs -r sudo docker stop", true},
+		{"printf x; rm -rf /", false},
+		{"printf x && rm -rf /", false},
+		{"printf x | sh", false},
+		{"printf x\nrm -rf /", false},
+		{"printf $(rm -rf /)", false},
+		{"printf `rm -rf /`", false},
+		{"cd /srv/app && sudo docker run app", false},
+		// A prefix must end at a word boundary
+		{"printf", true},
+		{"sudo docker ps", true},
+		{"sudo docker ps\t-q", true},
+		{"sudo docker psx", false},
+		{"sudo docker buildx build -t app .", false},
+		{"printfx", false},
+		{"cd /srv/application && sudo docker build .", false},
+		{"cd /srv/app/sub && sudo docker build .", false},
+	}
+	for _, tt := range tests {
+		err := checkAllowlist(tt.cmd, allowlist)
+		if (err == nil) != tt.allowed {
+			t.Errorf("checkAllowlist(%q) = %v, want allowed %v", tt.cmd, err, tt.allowed)
+		}
+	}
+}
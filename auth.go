@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// defaultAuthOrder keeps the original behaviour: key file first, then password
+var defaultAuthOrder = []string{"key", "password"}
+
+// authMethodNames are the values AUTH_ORDER accepts
+var authMethodNames = map[string]bool{
+	"agent":                true,
+	"key":                  true,
+	"password":             true,
+	"keyboard-interactive": true,
+}
+
+// parseAuthOrder validates a comma-separated AUTH_ORDER value
+func parseAuthOrder(value string) ([]string, error) {
+	var order []string
+	for _, name := range parsePatternList(strings.ToLower(value)) {
+		if name == "publickey" {
+			name = "key"
+		}
+		if !authMethodNames[name] {
+			return nil, fmt.Errorf("unknown auth method %q, expected agent, key, password or keyboard-interactive", name)
+		}
+		order = append(order, name)
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("expected at least one auth method")
+	}
+	return order, nil
+}
+
+// authOrder returns AUTH_ORDER, or the default order if it isn't set
+func (c *Config) authOrder() []string {
+	if len(c.AuthOrder) == 0 {
+		return defaultAuthOrder
+	}
+	return c.AuthOrder
+}
+
+// usesAuth reports whether the configured AUTH_ORDER includes a method
+func (c *Config) usesAuth(name string) bool {
+	for _, n := range c.authOrder() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// authMethods builds the SSH auth methods in AUTH_ORDER, skipping those that
+// aren't configured. The SSH library tries each method type only once, so
+// agent and key signers are combined into one publickey method at the
+// position of whichever comes first. The returned cleanup closes the agent
+// connection once authentication is done.
+func (sm *SyncManager) authMethods() ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	var signerSources []string
+	var agentConn net.Conn
+	cleanup := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	var keySigners []ssh.Signer
+	if sm.config.usesAuth("key") {
+		for _, keyFile := range sm.config.keyFiles() {
+			keyData, err := os.ReadFile(expandLocalHome(keyFile))
+			if err != nil {
+				return nil, cleanup, fmt.Errorf("failed to read SSH key file: %w", err)
+			}
+			keySigner, err := ssh.ParsePrivateKey(keyData)
+			if err != nil {
+				return nil, cleanup, fmt.Errorf("failed to parse SSH key file %s: %w", keyFile, err)
+			}
+			keySigners = append(keySigners, sm.trackSigner(keySigner, "key "+keyFile))
+		}
+	}
+
+	var agentClient agent.ExtendedAgent
+	if sm.config.usesAuth("agent") {
+		if socket := os.Getenv("SSH_AUTH_SOCK"); socket == "" {
+			sm.verbosef("SSH agent skipped: SSH_AUTH_SOCK is not set")
+		} else if conn, err := net.Dial("unix", socket); err != nil {
+			log.Printf("⚠️  Could not reach the SSH agent at %s: %v", socket, err)
+		} else {
+			agentConn = conn
+			agentClient = agent.NewClient(conn)
+		}
+	}
+
+	for _, name := range sm.config.authOrder() {
+		switch name {
+		case "agent", "key":
+			if (name == "agent" && agentClient == nil) || (name == "key" && len(keySigners) == 0) {
+				continue
+			}
+			if len(signerSources) == 0 {
+				methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+					return sm.orderedSigners(signerSources, keySigners, agentClient), nil
+				}))
+			}
+			signerSources = append(signerSources, name)
+		case "password":
+			if sm.config.SSHPassword == "" {
+				continue
+			}
+			methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+				sm.authUsed = "password"
+				sm.verbosef("Trying password authentication")
+				return sm.config.SSHPassword, nil
+			}))
+		case "keyboard-interactive":
+			methods = append(methods, ssh.KeyboardInteractive(sm.keyboardInteractive))
+		}
+	}
+
+	return methods, cleanup, nil
+}
+
+// orderedSigners collects the public key signers in the configured order.
+// The server accepts or rejects each key in turn, like ssh with several
+// IdentityFile entries.
+func (sm *SyncManager) orderedSigners(sources []string, keySigners []ssh.Signer, agentClient agent.ExtendedAgent) []ssh.Signer {
+	var signers []ssh.Signer
+	for _, source := range sources {
+		if source == "key" {
+			sm.verbosef("Trying key(s) %s", strings.Join(sm.config.keyFiles(), ", "))
+			signers = append(signers, keySigners...)
+			continue
+		}
+		agentSigners, err := agentClient.Signers()
+		if err != nil {
+			log.Printf("⚠️  Could not list SSH agent keys: %v", err)
+			continue
+		}
+		sm.verbosef("Trying %d key(s) from the SSH agent", len(agentSigners))
+		for _, signer := range agentSigners {
+			signers = append(signers, sm.trackSigner(signer, "agent key "+ssh.FingerprintSHA256(signer.PublicKey())))
+		}
+	}
+	sm.authUsed = "public key (" + strings.Join(sources, ", ") + ")"
+	return signers
+}
+
+// keyFiles returns the private keys listed in SSH_KEY_FILE, in order
+func (c *Config) keyFiles() []string {
+	return parsePatternList(c.SSHKeyFile)
+}
+
+// trackedSigner notes which key signed the authentication request. The
+// server only asks for a signature once it has accepted the public key, so
+// the last key to sign is the one that authenticated.
+type trackedSigner struct {
+	ssh.AlgorithmSigner
+	sm   *SyncManager
+	name string
+}
+
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.sm.authUsed = "public key (" + s.name + ")"
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.sm.authUsed = "public key (" + s.name + ")"
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// trackedMultiSigner is a trackedSigner that keeps the signer's restricted
+// list of signature algorithms
+type trackedMultiSigner struct {
+	*trackedSigner
+	algorithms []string
+}
+
+func (s *trackedMultiSigner) Algorithms() []string {
+	return s.algorithms
+}
+
+// trackSigner wraps a signer so the key that authenticated can be reported.
+// The wrapper keeps the signer's choice of signature algorithms, since hiding
+// it would downgrade RSA keys to SHA-1 signatures; signers without one are
+// left as they are.
+func (sm *SyncManager) trackSigner(signer ssh.Signer, name string) ssh.Signer {
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return signer
+	}
+	tracked := &trackedSigner{AlgorithmSigner: algorithmSigner, sm: sm, name: name}
+	if multi, ok := signer.(ssh.MultiAlgorithmSigner); ok {
+		return &trackedMultiSigner{trackedSigner: tracked, algorithms: multi.Algorithms()}
+	}
+	return tracked
+}
+
+// keyboardInteractive answers the server's prompts: hidden prompts get
+// SSH_PASSWORD if it is set, anything else is asked on the terminal, without
+// echo for hidden prompts and with it for others (e.g. a 2FA code)
+func (sm *SyncManager) keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	sm.authUsed = "keyboard-interactive"
+	sm.verbosef("Trying keyboard-interactive authentication")
+	if instruction != "" {
+		fmt.Println(instruction)
+	}
+
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		if !echos[i] && sm.config.SSHPassword != "" {
+			answers[i] = sm.config.SSHPassword
+			continue
+		}
+		if sm.config.BatchMode {
+			return nil, fmt.Errorf("the server asked %q, but BATCH_MODE never prompts", strings.TrimSpace(question))
+		}
+		prompt := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(question), ":"))
+		if echos[i] {
+			answers[i] = promptInput(prompt, "")
+			continue
+		}
+		// A hidden prompt asks for a secret, which mustn't show on the screen
+		answer, err := promptPassword(prompt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the answer to %q: %w", prompt, err)
+		}
+		answers[i] = answer
+	}
+	return answers, nil
+}
+
+// verbosef logs a message only when VERBOSE is on
+func (sm *SyncManager) verbosef(format string, args ...interface{}) {
+	if sm.config.Verbose {
+		log.Printf("🔍 "+format, args...)
+	}
+}
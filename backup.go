@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultBackupKeep is how many backups of each file BACKUP_ON_OVERWRITE keeps
+const defaultBackupKeep = 3
+
+// backupStampFormat sorts in time order, so the newest backup sorts last
+const backupStampFormat = "20060102T150405Z"
+
+// backupName matches the backups pooshit writes, e.g. app.conf.bak-20240531T142501Z
+var backupName = regexp.MustCompile(`\.bak-\d{8}T\d{6}Z$`)
+
+// isBackupFile reports whether a path is one of pooshit's own backups, which
+// are never pushed or pulled
+func isBackupFile(relPath string) bool {
+	return backupName.MatchString(relPath)
+}
+
+// backupRemoteFile copies a remote file that is about to be overwritten to
+// <file>.bak-<UTC timestamp> beside it, keeping its mode, then removes all
+// but the BACKUP_KEEP newest backups of that file. The copy is made over
+// SFTP, so it works without shell access.
+func (sm *SyncManager) backupRemoteFile(remotePath string) error {
+	backupPath := remotePath + ".bak-" + time.Now().UTC().Format(backupStampFormat)
+
+	src, err := sm.sftpClient.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", remotePath, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", remotePath, err)
+	}
+
+	dst, err := sm.sftpClient.Create(backupPath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", backupPath, err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		sm.sftpClient.Remove(backupPath)
+		return fmt.Errorf("failed to copy %s: %w", remotePath, err)
+	}
+	dst.Chmod(info.Mode().Perm())
+	if err := dst.Close(); err != nil {
+		sm.sftpClient.Remove(backupPath)
+		return fmt.Errorf("failed to write %s: %w", backupPath, err)
+	}
+	sm.verbosef("Backed up %s to %s", remotePath, backupPath)
+
+	sm.pruneBackups(remotePath)
+	return nil
+}
+
+// pruneBackups removes the oldest backups of a file beyond BACKUP_KEEP. A
+// failure only leaves an extra backup behind, so it is logged and ignored.
+func (sm *SyncManager) pruneBackups(remotePath string) {
+	entries, err := sm.sftpClient.ReadDir(path.Dir(remotePath))
+	if err != nil {
+		sm.verbosef("Could not list backups of %s: %v", remotePath, err)
+		return
+	}
+	prefix := path.Base(remotePath) + ".bak-"
+	var backups []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && isBackupFile(entry.Name()) {
+			backups = append(backups, entry.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > sm.config.BackupKeep {
+		old := path.Join(path.Dir(remotePath), backups[0])
+		if err := sm.sftpClient.Remove(old); err != nil {
+			sm.verbosef("Could not remove old backup %s: %v", old, err)
+		}
+		backups = backups[1:]
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readDockerignore returns the patterns in the local folder's .dockerignore,
+// or nil if it has none. They are matched like IGNORE patterns.
+func readDockerignore(localFolder string) ([]string, error) {
+	f, err := os.Open(filepath.Join(localFolder, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open .dockerignore: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, strings.TrimPrefix(line, "/"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read .dockerignore: %w", err)
+	}
+	return patterns, nil
+}
+
+// uploadBuildContext packs the local folder into a gzipped tarball and
+// uploads it to a temporary file on the remote, which the docker build then
+// reads on stdin instead of the synced folder. IGNORE and .dockerignore
+// patterns both keep files out of the tarball. The tarball is removed when
+// the connection is closed.
+func (sm *SyncManager) uploadBuildContext() (*FolderResult, error) {
+	localFolder := sm.config.LocalFolder
+	log.Printf("Packing build context from '%s'...", localFolder)
+
+	result := &FolderResult{
+		LocalFolder:  localFolder,
+		RemoteFolder: sm.config.RemoteFolder,
+	}
+
+	localRoot, err := filepath.EvalSymlinks(localFolder)
+	if err == nil {
+		localRoot, err = filepath.Abs(localRoot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local folder '%s': %w", localFolder, err)
+	}
+
+	dockerignore, err := readDockerignore(localFolder)
+	if err != nil {
+		return nil, err
+	}
+	patterns := append(append([]string{}, sm.config.IgnorePatterns...), dockerignore...)
+
+	tarball, err := os.CreateTemp("", "pooshit-context-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local tarball: %w", err)
+	}
+	defer os.Remove(tarball.Name())
+	defer tarball.Close()
+
+	scanStart := time.Now()
+	packed, err := sm.writeContextTar(tarball, localFolder, localRoot, patterns, result)
+	sm.timePhase("scan", scanStart)
+	if err != nil {
+		return nil, err
+	}
+	if err := tarball.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write local tarball: %w", err)
+	}
+	info, err := os.Stat(tarball.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to write local tarball: %w", err)
+	}
+
+	result.Checked = packed
+	result.Bytes = info.Size()
+	remoteTarball := fmt.Sprintf("/tmp/pooshit-context-%d.tar.gz", time.Now().UnixNano())
+	if sm.config.DryRun {
+		log.Printf("🔎 Would upload a build context of %d files (%d bytes, %d ignored)", packed, info.Size(), result.Ignored)
+		sm.contextTarball = remoteTarball
+		return result, nil
+	}
+
+	log.Printf("Uploading build context: %d files in %d bytes (%d ignored)", packed, info.Size(), result.Ignored)
+	transferStart := time.Now()
+	err = sm.uploadFile(tarball.Name(), remoteTarball, 0600)
+	sm.timePhase("transfer", transferStart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload build context: %w", err)
+	}
+	sm.contextTarball = remoteTarball
+	result.Transferred = packed
+	log.Printf("✅ Build context uploaded to %s", remoteTarball)
+	return result, nil
+}
+
+// writeContextTar walks the local folder into a gzipped tar and returns how
+// many files it packed. Symlinks are packed as the file they point to, with
+// the same SYMLINK_ESCAPE rules as a push.
+func (sm *SyncManager) writeContextTar(w io.Writer, localFolder, localRoot string, patterns []string, result *FolderResult) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	packed := 0
+	modes, err := sm.permsSidecar(localFolder)
+	if err != nil {
+		return 0, err
+	}
+
+	err = filepath.Walk(localFolder, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(localFolder, localPath)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		if sm.shouldIgnore(relPath, info, patterns) {
+			result.Ignored++
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isPartialDownload(localPath, info) {
+			result.Ignored++
+			return nil
+		}
+		if sm.beyondMaxDepth(relPath, info) {
+			result.Ignored++
+			return filepath.SkipDir
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := sm.followSymlink(localRoot, localPath, relPath, result)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				return nil
+			}
+			info = target
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+			if sm.config.DefaultDirMode != 0 {
+				header.Mode = int64(sm.config.DefaultDirMode)
+			}
+			return tw.WriteHeader(header)
+		}
+		if mode, ok := modes[filepath.ToSlash(relPath)]; ok {
+			info = &sidecarInfo{FileInfo: info, mode: mode}
+		}
+		header.Mode = int64(sm.remoteFileMode(relPath, info))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(localPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+		packed++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to pack build context: %w", err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to pack build context: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("failed to pack build context: %w", err)
+	}
+	return packed, nil
+}
+
+// removeBuildContext deletes the uploaded tarball from the remote
+func (sm *SyncManager) removeBuildContext() {
+	if sm.contextTarball == "" || sm.config.DryRun {
+		return
+	}
+	if err := sm.sftpClient.Remove(sm.contextTarball); err != nil {
+		log.Printf("⚠️  Could not remove build context %s: %v", sm.contextTarball, err)
+	}
+	sm.contextTarball = ""
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// buildLogFinished starts the last line of a saved build, so build-log -f knows when to stop
+const buildLogFinished = "# pooshit: build finished"
+
+// buildLogDrainTimeout bounds the wait for the last build output to reach BUILD_LOG
+const buildLogDrainTimeout = 5 * time.Second
+
+// buildLogPollInterval is how often build-log -f checks for new output
+const buildLogPollInterval = 500 * time.Millisecond
+
+// buildLogPath returns where the build output for a target is saved
+func (c *Config) buildLogPath() string {
+	return strings.ReplaceAll(expandLocalHome(c.BuildLog), "{target}", c.RemoteServer)
+}
+
+// buildLogWriter serializes the build's stdout and stderr into one file
+type buildLogWriter struct {
+	mu   sync.Mutex
+	f    *os.File
+	last byte
+}
+
+func (w *buildLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(p) > 0 {
+		w.last = p[len(p)-1]
+	}
+	return w.f.Write(p)
+}
+
+// openBuildLog starts saving a build to BUILD_LOG, replacing the previous
+// build's output. It returns the writer to copy the output to and a function
+// that records how the build ended and closes the file. Without BUILD_LOG,
+// or if the file can't be created, the output is only shown.
+func (sm *SyncManager) openBuildLog(command string) (io.Writer, func(buildErr error)) {
+	if sm.config.BuildLog == "" {
+		return io.Discard, func(error) {}
+	}
+	logPath := sm.config.buildLogPath()
+	f, err := os.Create(logPath)
+	if err != nil {
+		log.Printf("⚠️  Could not save the build output to %s: %v", logPath, err)
+		return io.Discard, func(error) {}
+	}
+	fmt.Fprintf(f, "# pooshit: build of %s on %s, started %s\n# %s\n",
+		sm.config.DockerImageName, sm.config.RemoteServer, time.Now().Format(time.RFC3339), command)
+
+	w := &buildLogWriter{f: f, last: '\n'}
+	return w, func(buildErr error) {
+		outcome := "successfully"
+		if buildErr != nil {
+			outcome = "with an error: " + buildErr.Error()
+		}
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.last != '\n' {
+			fmt.Fprintln(f)
+		}
+		fmt.Fprintf(f, "%s %s at %s\n", buildLogFinished, outcome, time.Now().Format(time.RFC3339))
+		f.Close()
+		sm.result.BuildLog = logPath
+	}
+}
+
+// showBuildLog prints the output of the last build saved to BUILD_LOG for
+// each target. With follow, it keeps printing new output of a single target
+// until that build finishes.
+func showBuildLog(config *Config, follow bool) error {
+	if config.BuildLog == "" {
+		return fmt.Errorf("BUILD_LOG isn't set, so no build output is saved")
+	}
+	targets := config.Targets()
+	if follow && len(targets) > 1 {
+		return fmt.Errorf("build-log -f follows a single target, but REMOTE_SERVER lists %d", len(targets))
+	}
+	if follow {
+		return followBuildLog(config.forTarget(targets[0]).buildLogPath())
+	}
+
+	for _, target := range targets {
+		logPath := config.forTarget(target).buildLogPath()
+		if len(targets) > 1 {
+			fmt.Printf("==> %s <==\n", logPath)
+		}
+		data, err := os.ReadFile(logPath)
+		if os.IsNotExist(err) {
+			log.Printf("No build output saved at %s yet", logPath)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the build log: %w", err)
+		}
+		os.Stdout.Write(data)
+		if !bytes.Contains(data, []byte(buildLogFinished)) {
+			log.Printf("(the build is still running or was cut off; follow it with pooshit build-log -f)")
+		}
+	}
+	return nil
+}
+
+// followBuildLog prints a build log and whatever is added to it, starting
+// over when a new build replaces it, until a build finishes
+func followBuildLog(logPath string) error {
+	f, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no build output saved at %s yet", logPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the build log: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	line := ""
+	for {
+		chunk, err := reader.ReadString('\n')
+		fmt.Print(chunk)
+		offset += int64(len(chunk))
+		line += chunk
+		if strings.HasSuffix(line, "\n") {
+			if strings.HasPrefix(line, buildLogFinished) {
+				return nil
+			}
+			line = ""
+		}
+		if err == io.EOF {
+			time.Sleep(buildLogPollInterval)
+			if info, statErr := f.Stat(); statErr == nil && info.Size() < offset {
+				log.Println("--- a new build started ---")
+				f.Seek(0, io.SeekStart)
+				reader.Reset(f)
+				offset, line = 0, ""
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the build log: %w", err)
+		}
+	}
+}
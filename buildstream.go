@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultStallWarning is how long a streamed command may go quiet before pooshit warns
+const defaultStallWarning = 2 * time.Minute
+
+// activityWriter records when a streamed command last produced output
+type activityWriter struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func newActivityWriter() *activityWriter {
+	return &activityWriter{last: time.Now()}
+}
+
+// to returns a writer that passes output through to w and marks activity
+func (a *activityWriter) to(w io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		a.mu.Lock()
+		a.last = time.Now()
+		a.mu.Unlock()
+		return w.Write(p)
+	})
+}
+
+// quietFor returns how long it has been since the last output
+func (a *activityWriter) quietFor() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Since(a.last)
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// waitInterruptible waits for a streamed command such as the Docker build.
+// Ctrl-C interrupts the remote command and closes the session instead of
+// leaving it running, and a warning is logged each time the command has been
+// silent for STALL_WARNING, e.g. while a base image pull hangs.
+func (sm *SyncManager) waitInterruptible(session remoteSession, activity *activityWriter) error {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+
+	var stallCheck <-chan time.Time
+	if sm.config.StallWarning > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		stallCheck = ticker.C
+	}
+	warnedAt := time.Duration(0)
+
+	for {
+		select {
+		case err := <-done:
+			return err
+		case <-interrupt:
+			log.Println("\n🛑 Interrupted - stopping the remote command...")
+			if signaler, ok := session.(interface{ Signal(ssh.Signal) error }); ok {
+				signaler.Signal(ssh.SIGINT)
+			}
+			session.Close()
+			return fmt.Errorf("aborted by user")
+		case <-stallCheck:
+			quiet := activity.quietFor()
+			if quiet < warnedAt+sm.config.StallWarning {
+				if quiet < sm.config.StallWarning {
+					warnedAt = 0
+				}
+				continue
+			}
+			warnedAt = quiet.Truncate(time.Second)
+			log.Printf("⏳ No output for %s - the command may be stuck (e.g. pulling a base image). Press Ctrl-C to abort", warnedAt)
+		}
+	}
+}
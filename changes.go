@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// changeType groups a changed file by extension; files without one, such as
+// a Dockerfile or Makefile, are grouped by name
+func changeType(relPath string) string {
+	base := filepath.Base(relPath)
+	ext := strings.ToLower(filepath.Ext(base))
+	if ext == "" || ext == base {
+		return base
+	}
+	return ext
+}
+
+// recordChange counts a transferred file in the run's breakdown by type
+func (sm *SyncManager) recordChange(relPath string) {
+	if sm.result.ChangedByType == nil {
+		sm.result.ChangedByType = make(map[string]int)
+	}
+	sm.result.ChangedByType[changeType(relPath)]++
+}
+
+// sortedChangeTypes returns the types in a breakdown, most changed first
+func sortedChangeTypes(changes map[string]int) []string {
+	types := make([]string, 0, len(changes))
+	for t := range changes {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if changes[types[i]] != changes[types[j]] {
+			return changes[types[i]] > changes[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// printChanges prints the run's changed files by type as a small table
+func (sm *SyncManager) printChanges(verb string) {
+	changes := sm.result.ChangedByType
+	if len(changes) == 0 {
+		return
+	}
+	log.Printf("\n📝 Files %s by type:", verb)
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, t := range sortedChangeTypes(changes) {
+		fmt.Fprintf(w, "   %s\t%d\n", t, changes[t])
+	}
+	w.Flush()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+)
+
+// CleanRemote deletes everything inside the remote folder, keeping the folder
+// itself, after checking the path is safe to wipe and asking for confirmation
+func (sm *SyncManager) CleanRemote() (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	if err := sm.checkCleanPath(remotePath); err != nil {
+		return err
+	}
+	if err := sm.loadOwnedManifest(); err != nil {
+		return err
+	}
+
+	entries, err := sm.sftpClient.ReadDir(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to list remote directory %s: %w", remotePath, err)
+	}
+	if len(entries) == 0 {
+		log.Printf("Remote directory %s is already empty", remotePath)
+		return nil
+	}
+
+	if !confirmDestructive(fmt.Sprintf("This will permanently delete %d entries in %s:%s. Continue?", len(entries), sm.config.RemoteServer, remotePath)) {
+		return fmt.Errorf("clean cancelled")
+	}
+
+	// Paths listed in OWNED_MANIFEST, and the directories holding them, survive the clean
+	kept := 0
+	progressBar := sm.newProgressBar(len(entries))
+	for i, entry := range entries {
+		progressBar.Update(i+1, fmt.Sprintf("Removing: %s", entry.Name()))
+		n, err := sm.removeUnowned(remotePath, entry.Name())
+		if err != nil {
+			progressBar.Complete()
+			return fmt.Errorf("failed to remove %s: %w", entry.Name(), err)
+		}
+		kept += n
+	}
+	progressBar.Complete()
+
+	if kept > 0 {
+		log.Printf("🧹 Cleaned %s, keeping %d path(s) owned by another tool", remotePath, kept)
+		return nil
+	}
+	log.Printf("🧹 Removed %d entries from %s", len(entries), remotePath)
+	return nil
+}
+
+// checkCleanPath refuses to wipe paths where a typo would be catastrophic:
+// relative paths, the filesystem root or a top-level directory, and the home directory
+func (sm *SyncManager) checkCleanPath(remotePath string) error {
+	cleaned := path.Clean(remotePath)
+	if !path.IsAbs(cleaned) {
+		return fmt.Errorf("refusing to clean %q: REMOTE_FOLDER must resolve to an absolute path", remotePath)
+	}
+	if strings.Count(cleaned, "/") < 2 {
+		return fmt.Errorf("refusing to clean %q: it is the root or a top-level directory", cleaned)
+	}
+	// Cleaning may only empty a folder inside ALLOWED_REMOTE_ROOT, never the root itself
+	if err := sm.checkAllowedRoot(cleaned); err != nil {
+		return err
+	}
+	if root, _ := sm.allowedRoot(); cleaned == root {
+		return fmt.Errorf("refusing to clean %q: it is ALLOWED_REMOTE_ROOT itself", cleaned)
+	}
+
+	home, err := sm.getRemoteHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to determine remote home directory: %w", err)
+	}
+	if cleaned == path.Clean(home) {
+		return fmt.Errorf("refusing to clean %q: it is the remote user's home directory", cleaned)
+	}
+
+	info, err := sm.sftpClient.Stat(cleaned)
+	if err != nil {
+		return fmt.Errorf("remote directory does not exist: %s", cleaned)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("remote path %s is not a directory", cleaned)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// cleanupVars are the variables CLEANUP_CMD can use, e.g.
+// {{.Docker}} ps -aq --filter label=app={{.Image}}
+type cleanupVars struct {
+	// Image is DOCKER_IMAGE_NAME
+	Image string
+	// Docker is the docker command with sudo, DOCKER_SSH_USER and
+	// REMOTE_CMD_PREFIX applied, as pooshit runs it
+	Docker string
+}
+
+// expandCleanupCmd fills in a CLEANUP_CMD template
+func expandCleanupCmd(cmd string, vars cleanupVars) (string, error) {
+	tmpl, err := template.New("CLEANUP_CMD").Parse(cmd)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseCleanupCmd checks that a CLEANUP_CMD template expands, so a mistake
+// fails when the config is loaded rather than once the files are synced
+func parseCleanupCmd(value string) (string, error) {
+	if _, err := expandCleanupCmd(value, cleanupVars{Image: "app", Docker: "docker"}); err != nil {
+		return "", fmt.Errorf("expected a command with {{.Image}} and {{.Docker}} variables: %w", err)
+	}
+	return value, nil
+}
+
+// listContainersCommand returns the command that lists the IDs of the
+// containers a deploy replaces: CLEANUP_CMD, or all containers of the image
+func (sm *SyncManager) listContainersCommand() string {
+	if sm.config.CleanupCmd == "" {
+		return fmt.Sprintf("%s ps -aq --filter ancestor=%s", sm.dockerCmd(), sm.config.DockerImageName)
+	}
+	// Checked by parseCleanupCmd, so it expands
+	cmd, _ := expandCleanupCmd(sm.config.CleanupCmd, cleanupVars{Image: sm.config.DockerImageName, Docker: sm.dockerCmd()})
+	return cmd
+}
+
+// stopContainersCommand returns the command that stops and removes the
+// containers listContainersCommand finds
+func (sm *SyncManager) stopContainersCommand() string {
+	docker := sm.dockerCmd()
+	list := sm.listContainersCommand()
+	if sm.config.CleanupCmd != "" {
+		// All of its output goes to docker stop, even with ; or && in it
+		list = "{ " + list + "; }"
+	}
+	return fmt.Sprintf("%s | xargs -r %s stop | xargs -r %s rm", list, docker, docker)
+}
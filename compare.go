@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultCompare keeps the original change detection: same size and a
+// destination that is no older than the source
+const defaultCompare = "size+mtime"
+
+// parseCompare validates a COMPARE value
+func parseCompare(value string) (string, error) {
+	switch strategy := strings.ToLower(value); strategy {
+	case "size", "mtime", "size+mtime", "checksum":
+		return strategy, nil
+	case "":
+		return defaultCompare, nil
+	default:
+		return "", fmt.Errorf("expected size, mtime, size+mtime or checksum, got %q", value)
+	}
+}
+
+// defaultMtimeTolerance covers the coarsest common timestamp granularity,
+// FAT's 2 seconds, so files on a USB drive aren't re-uploaded every time
+const defaultMtimeTolerance = 2 * time.Second
+
+// metadataMatches reports whether dest looks like an up-to-date copy of source
+// from its size and modification time alone. The checksum strategy still
+// requires equal sizes, so only files that could match are hashed.
+func metadataMatches(strategy string, tolerance time.Duration, source, dest os.FileInfo) bool {
+	sameSize := source.Size() == dest.Size()
+	// Copies get their mtime when written, so anything not older than the
+	// source is current; within the tolerance counts as not older
+	fresh := !dest.ModTime().Before(source.ModTime().Add(-tolerance))
+	switch strategy {
+	case "size", "checksum":
+		return sameSize
+	case "mtime":
+		return fresh
+	default:
+		return sameSize && fresh
+	}
+}
+
+// localIsNewer reports whether a local file was modified after its remote
+// copy. SFTP keeps whole seconds, so the local time is cut to match.
+func localIsNewer(local, remote os.FileInfo) bool {
+	return local.ModTime().Truncate(time.Second).After(remote.ModTime())
+}
+
+// upToDate decides whether a file can be skipped under the COMPARE strategy,
+// in either direction: source is the side being copied from. With checksum,
+// both the local and the remote file are read in full; if either can't be
+// read the file is treated as changed.
+func (sm *SyncManager) upToDate(file syncFile, source, dest os.FileInfo) bool {
+	if !metadataMatches(sm.config.Compare, sm.config.MtimeTolerance, source, dest) {
+		return false
+	}
+	if sm.config.Compare != "checksum" {
+		return true
+	}
+
+	localSum, err := localChecksum(file.localPath)
+	if err != nil {
+		sm.verbosef("Treating %s as changed: %v", file.relPath, err)
+		return false
+	}
+	remoteSum, err := sm.remoteFileChecksum(file.remotePath)
+	if err != nil {
+		sm.verbosef("Treating %s as changed: %v", file.relPath, err)
+		return false
+	}
+	return localSum == remoteSum
+}
+
+// remoteFileChecksum returns the MD5 of a remote file, read over SFTP so the
+// remote needs no md5sum binary
+func (sm *SyncManager) remoteFileChecksum(remotePath string) (string, error) {
+	f, err := sm.sftpClient.Open(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer f.Close()
+
+	sum, err := md5Hex(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read remote file: %w", err)
+	}
+	return sum, nil
+}
+
+// localChecksum returns the MD5 of a local file as a hex string
+func localChecksum(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer f.Close()
+
+	sum, err := md5Hex(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read local file: %w", err)
+	}
+	return sum, nil
+}
+
+// md5Hex hashes everything read from r
+func md5Hex(r io.Reader) (string, error) {
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
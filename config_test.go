@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes the lines of a config file to a temporary directory
+func writeConfig(t *testing.T, lines ...string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "pooshit.yaml")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfigIgnoreLines(t *testing.T) {
+	base := []string{"TRANSPORT: local", "REMOTE_FOLDER: /tmp/dst", "DOCKER_IMAGE_NAME: app"}
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{name: "none", want: defaultIgnorePatterns},
+		{name: "one line", lines: []string{"IGNORE: node_modules, *.log"}, want: []string{"node_modules", "*.log"}},
+		{
+			name:  "several lines",
+			lines: []string{"IGNORE: node_modules, .git, dist", "IGNORE: *.log, *.tmp", "IGNORE: !keep.log"},
+			want:  []string{"node_modules", ".git", "dist", "*.log", "*.tmp", "!keep.log"},
+		},
+		{
+			name:  "lines apart",
+			lines: []string{"IGNORE: *.log", "LOCAL_FOLDER: .", "IGNORE: !keep.log"},
+			want:  []string{"*.log", "!keep.log"},
+		},
+		{name: "no defaults", lines: []string{"NO_DEFAULT_IGNORES: true"}, want: nil},
+	}
+	for _, tt := range tests {
+		config, err := LoadConfig(writeConfig(t, append(base, tt.lines...)...), nil)
+		if err != nil {
+			t.Errorf("%s: LoadConfig: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(config.IgnorePatterns, tt.want) {
+			t.Errorf("%s: IgnorePatterns = %q, want %q", tt.name, config.IgnorePatterns, tt.want)
+		}
+	}
+}
+
+func TestSetValueIdleTimeout(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "10m", want: 10 * time.Minute},
+		{value: "90s", want: 90 * time.Second},
+		{value: "off", want: 0},
+		{value: "0", want: 0},
+		{value: "-1m", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		config := &Config{IdleTimeout: time.Hour}
+		err := config.setValue("IDLE_TIMEOUT", tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("IDLE_TIMEOUT %q = %s, want an error", tt.value, config.IdleTimeout)
+			}
+			continue
+		}
+		if err != nil || config.IdleTimeout != tt.want {
+			t.Errorf("IDLE_TIMEOUT %q = %s, %v; want %s", tt.value, config.IdleTimeout, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigKeyFiles(t *testing.T) {
+	base := []string{"REMOTE_SERVER: example.com", "SSH_USERNAME: deploy", "REMOTE_FOLDER: /srv/app", "DOCKER_IMAGE_NAME: app"}
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{name: "one key", lines: []string{"SSH_KEY_FILE: ~/.ssh/id_ed25519"}, want: []string{"~/.ssh/id_ed25519"}},
+		{name: "a list", lines: []string{"SSH_KEY_FILES: ~/.ssh/a, ~/.ssh/b"}, want: []string{"~/.ssh/a", "~/.ssh/b"}},
+		{
+			name:  "both keys",
+			lines: []string{"SSH_KEY_FILES: ~/.ssh/a, ~/.ssh/b", "SSH_KEY_FILE: ~/.ssh/legacy"},
+			want:  []string{"~/.ssh/a", "~/.ssh/b", "~/.ssh/legacy"},
+		},
+		{
+			name:  "legacy key first",
+			lines: []string{"SSH_KEY_FILE: ~/.ssh/legacy", "SSH_KEY_FILES: ~/.ssh/a"},
+			want:  []string{"~/.ssh/legacy", "~/.ssh/a"},
+		},
+		{
+			name:  "repeated key",
+			lines: []string{"SSH_KEY_FILES: ~/.ssh/a, ~/.ssh/b", "SSH_KEY_FILE: ~/.ssh/a"},
+			want:  []string{"~/.ssh/a", "~/.ssh/b"},
+		},
+	}
+	for _, tt := range tests {
+		config, err := LoadConfig(writeConfig(t, append(base, tt.lines...)...), nil)
+		if err != nil {
+			t.Errorf("%s: LoadConfig: %v", tt.name, err)
+			continue
+		}
+		if got := config.keyFiles(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: key files = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigSSHCompression(t *testing.T) {
+	base := []string{"REMOTE_SERVER: example.com", "SSH_USERNAME: deploy", "SSH_KEY_FILE: ~/.ssh/id_ed25519", "REMOTE_FOLDER: /srv/app", "DOCKER_IMAGE_NAME: app"}
+	tests := []struct {
+		name    string
+		lines   []string
+		wantErr bool
+	}{
+		{name: "unset"},
+		{name: "off", lines: []string{"SSH_COMPRESSION: false"}},
+		{name: "on", lines: []string{"SSH_COMPRESSION: true"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := LoadConfig(writeConfig(t, append(base, tt.lines...)...), nil)
+		if tt.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "SSH_COMPRESSION") {
+				t.Errorf("%s: err = %v, want an SSH_COMPRESSION error", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: LoadConfig: %v", tt.name, err)
+		}
+	}
+}
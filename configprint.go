@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redacted replaces secrets in the printed configuration
+const redacted = "********"
+
+// effectiveValues lists every option as it took effect, after defaults,
+// environment expansion and flag overrides, in the config file's own format.
+// Secrets are redacted.
+func (c *Config) effectiveValues() [][2]string {
+	list := func(values []string) string { return strings.Join(values, ", ") }
+	secret := func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return redacted
+	}
+	since := ""
+	if !c.Since.IsZero() {
+		since = c.Since.Format(time.RFC3339)
+	}
+	var extras []string
+	for _, f := range c.ExtraFiles {
+		extras = append(extras, f.String())
+	}
+	extraFiles := list(extras)
+	octal := func(mode os.FileMode) string {
+		if mode == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%04o", mode)
+	}
+	itoa := strconv.Itoa
+	btoa := strconv.FormatBool
+
+	return [][2]string{
+		{"TRANSFER_PROTOCOL", c.TransferProtocol},
+		{"TRANSPORT", c.Transport},
+		{"REMOTE_SERVER", c.RemoteServer},
+		{"SSH_USERNAME", c.SSHUsername},
+		{"SSH_PASSWORD", secret(c.SSHPassword)},
+		{"SSH_KEY_FILE", c.SSHKeyFile},
+		{"AUTH_ORDER", list(c.authOrder())},
+		{"SSH_CONTROL_PATH", c.SSHControlPath},
+		{"PROXY", redactedProxy(c.Proxy)},
+		{"HOST_KEY_FINGERPRINT", list(c.HostKeyFingerprints)},
+		{"HOST_CA_KEY", c.HostCAKey},
+		{"SSH_COMPRESSION", btoa(c.SSHCompression)},
+		{"SSH_CIPHERS", list(c.SSHCiphers)},
+		{"SSH_KEX", list(c.SSHKeyExchanges)},
+		{"SSH_MACS", list(c.SSHMACs)},
+		{"SHOW_BANNER", btoa(c.ShowBanner)},
+		{"VERBOSE", btoa(c.Verbose)},
+		{"BATCH_MODE", btoa(c.BatchMode)},
+		{"REMOTE_FOLDER", c.RemoteFolder},
+		{"LOCAL_FOLDER", c.LocalFolder},
+		{"FROM_GIT_REF", c.FromGitRef},
+		{"REQUIRE_CLEAN_GIT", btoa(c.RequireCleanGit)},
+		{"FORCE", btoa(c.Force)},
+		{"REMOTE_ROOT", c.RemoteRoot},
+		{"ALLOWED_REMOTE_ROOT", c.AllowedRemoteRoot},
+		{"UPDATE_SYMLINK", c.UpdateSymlink},
+		{"IGNORE", list(c.IgnorePatterns)},
+		{"NO_DEFAULT_IGNORES", btoa(c.NoDefaultIgnores)},
+		{"IGNORE_HIDDEN", btoa(c.IgnoreHidden)},
+		{"ALLOWED_EXTENSIONS", list(c.AllowedExtensions)},
+		{"IGNORE_CASE", btoa(c.IgnoreCase)},
+		{"LOCAL_FOLDER_2", c.LocalFolder2},
+		{"REMOTE_FOLDER_2", c.RemoteFolder2},
+		{"IGNORE_2", list(c.IgnorePatterns2)},
+		{"SINCE", since},
+		{"MAX_DEPTH", itoa(c.MaxDepth)},
+		{"MAX_FILES", itoa(c.MaxFiles)},
+		{"CONFIRM_UPLOAD_SIZE", strconv.FormatInt(c.ConfirmUploadSize, 10)},
+		{"COMPARE", c.Compare},
+		{"MTIME_TOLERANCE", c.MtimeTolerance.String()},
+		{"UPLOAD_NEWER_ONLY", btoa(c.UploadNewerOnly)},
+		{"FRESH", btoa(c.Fresh)},
+		{"SKIP_LOCKED", btoa(c.SkipLocked)},
+		{"HASH_CACHE", btoa(c.HashCache)},
+		{"ALWAYS_UPLOAD", list(c.AlwaysUploadPatterns)},
+		{"EXTRA_FILES", extraFiles},
+		{"EXECUTABLE", list(c.ExecutablePatterns)},
+		{"PULL_EXECUTABLE", list(c.PullExecutablePatterns)},
+		{"PERMISSIONS_FILE", c.PermissionsFile},
+		{"PERMS_SIDECAR", btoa(c.PermsSidecar)},
+		{"DEFAULT_FILE_MODE", octal(c.DefaultFileMode)},
+		{"DEFAULT_DIR_MODE", octal(c.DefaultDirMode)},
+		{"LINE_ENDINGS", c.LineEndings},
+		{"LINE_ENDINGS_FILES", list(c.LineEndingsPatterns)},
+		{"BACKUP_ON_OVERWRITE", list(c.BackupPatterns)},
+		{"BACKUP_KEEP", itoa(c.BackupKeep)},
+		{"SYMLINK_ESCAPE", c.SymlinkEscape},
+		{"SYMLINK_STAT", c.SymlinkStat},
+		{"SHOW_DIFF", btoa(c.ShowDiff)},
+		{"PICK_FILES", btoa(c.PickFiles)},
+		{"SHOW_DIFF_MAX_SIZE", strconv.FormatInt(c.ShowDiffMaxSize, 10)},
+		{"VERIFY_AFTER", c.VerifyAfter},
+		{"DELTA", btoa(c.Delta)},
+		{"DELTA_MIN_SIZE", strconv.FormatInt(c.DeltaMinSize, 10)},
+		{"CONCURRENCY_PER_FILE", itoa(c.ConcurrencyPerFile)},
+		{"COPY_BUFFER_SIZE", strconv.FormatInt(c.CopyBufferSize, 10)},
+		{"MAX_SSH_SESSIONS", itoa(c.MaxSSHSessions)},
+		{"MAX_CONCURRENT_SESSIONS", itoa(c.MaxConcurrentSessions)},
+		{"MAX_PARALLEL_TARGETS", itoa(c.MaxParallelTargets)},
+		{"CONTINUE_ON_DOCKER_ERROR", btoa(c.ContinueOnDockerError)},
+		{"DEPLOY_STATE_FILE", c.DeployStateFile},
+		{"RESUME", btoa(c.Resume)},
+		{"RESTART", btoa(c.Restart)},
+		{"PARALLEL_DOWNLOADS", itoa(c.ParallelDownloads)},
+		{"OWNED_MANIFEST", c.OwnedManifest},
+		{"STAGING_DIR", c.StagingDir},
+		{"BUILD_FROM_TAR", btoa(c.BuildFromTar)},
+		{"PUT_MODE", octal(c.PutMode)},
+		{"SYNC_ONLY", btoa(c.SyncOnly)},
+		{"ONLY_DOCKER", btoa(c.OnlyDocker)},
+		{"SCAFFOLD", btoa(c.Scaffold)},
+		{"DRY_RUN", btoa(c.DryRun)},
+		{"SUMMARY_FILE", c.SummaryFile},
+		{"MANIFEST_FILE", c.ManifestFile},
+		{"BUILD_LOG", c.BuildLog},
+		{"CONTAINER_ID_FORMAT", c.ContainerIDFormat},
+		{"CONTAINER_ID_FILE", c.ContainerIDFile},
+		{"DOCKER_IMAGE_NAME", c.DockerImageName},
+		{"DOCKER_BUILD_ARGS", c.DockerBuildArgs},
+		{"DOCKER_RUN_ARGS", c.DockerRunArgs},
+		{"DOCKER_SUDO", btoa(c.DockerSudo)},
+		{"DOCKER_SSH_USER", c.DockerSSHUser},
+		{"REMOTE_CMD_PREFIX", c.RemoteCmdPrefix},
+		{"REMOVE_OLD_IMAGE", btoa(c.RemoveOldImage)},
+		{"CLEANUP_CMD", c.CleanupCmd},
+		{"NO_DOCKERFILE_CHECK", btoa(c.NoDockerfileCheck)},
+		{"STRICT_DOCKERFILE", btoa(c.StrictDockerfile)},
+		{"STRICT_ENV", btoa(c.StrictEnv)},
+		{"BEFORE_STOP_CMD", c.BeforeStopCmd},
+		{"BETWEEN_STOP_AND_BUILD_CMD", c.BetweenStopAndBuildCmd},
+		{"BEFORE_RUN_CMD", c.BeforeRunCmd},
+		{"AFTER_RUN_CMD", c.AfterRunCmd},
+		{"VERIFY_IMAGE", btoa(c.VerifyImage)},
+		{"ZERO_DOWNTIME", btoa(c.ZeroDowntime)},
+		{"HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout.String()},
+		{"STALL_WARNING", c.StallWarning.String()},
+		{"DEV_POLL_INTERVAL", c.DevPollInterval.String()},
+		{"DEV_DEBOUNCE", c.DevDebounce.String()},
+		{"IDLE_TIMEOUT", c.IdleTimeout.String()},
+		{"RUN_RETRIES", itoa(c.RunRetries)},
+		{"KEEP_IMAGES", itoa(c.KeepImages)},
+		{"AUDIT_COMMANDS", btoa(c.AuditCommands)},
+		{"COMMAND_ALLOWLIST", list(c.CommandAllowlist)},
+	}
+}
+
+// printConfig writes the effective configuration to stdout, one KEY: value
+// per line, so it can be read or diffed like a config file
+func printConfig(c *Config) {
+	for _, kv := range c.effectiveValues() {
+		fmt.Println(strings.TrimSpace(kv[0] + ": " + kv[1]))
+	}
+}
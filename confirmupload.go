@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// confirmUpload shows what a push of a folder is about to upload when that
+// is more than CONFIRM_UPLOAD_SIZE and, when run from a terminal, asks
+// before the transfer starts, so a folder that IGNORE failed to trim (a
+// stray node_modules) isn't uploaded by accident
+func (sm *SyncManager) confirmUpload(files []syncFile, result *FolderResult) error {
+	limit := sm.config.ConfirmUploadSize
+	if limit == 0 || sm.config.DryRun || sm.config.ApplyPlan != nil {
+		return nil
+	}
+	// No more than every file is uploaded, so a smaller folder needs no remote checks
+	var total int64
+	for _, file := range files {
+		total += file.info.Size()
+	}
+	if total < limit {
+		return nil
+	}
+
+	changed, err := sm.outOfSyncFiles(files)
+	if err != nil {
+		return err
+	}
+	outOfDate := make(map[string]bool, len(changed))
+	for _, d := range changed {
+		outOfDate[d.relPath] = true
+	}
+	count, size := 0, int64(0)
+	for _, file := range files {
+		if outOfDate[file.relPath] || matchPatternList(file.relPath, file.info, sm.config.AlwaysUploadPatterns, sm.config.IgnoreCase) {
+			count++
+			size += file.info.Size()
+		}
+	}
+	if size < limit {
+		return nil
+	}
+
+	log.Printf("📦 About to upload %s files totaling %s from %s (over CONFIRM_UPLOAD_SIZE)", formatCount(count), formatSize(size), result.LocalFolder)
+	if sm.quiet || sm.config.BatchMode || !stdinIsTerminal() {
+		// Nobody to ask, as with BATCH_MODE or parallel targets
+		return nil
+	}
+	if !confirmDestructive("Upload them?") {
+		return fmt.Errorf("push cancelled before uploading %s", formatSize(size))
+	}
+	return nil
+}
+
+// formatCount writes a number with thousands separators, e.g. 1,234
+func formatCount(n int) string {
+	digits := strconv.Itoa(n)
+	for i := len(digits) - 3; i > 0 && digits[i-1] != '-'; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return digits
+}
+
+// formatSize writes a byte size in the 1024-based units parseSize reads, e.g. 456.2 MB
+func formatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= unit.size {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(unit.size), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
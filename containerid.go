@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// containerIDLine is the full container ID docker run -d prints last
+var containerIDLine = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// parseContainerIDFormat validates CONTAINER_ID_FORMAT
+func parseContainerIDFormat(value string) (string, error) {
+	switch format := strings.ToLower(value); format {
+	case "", "full":
+		return "full", nil
+	case "short", "name":
+		return format, nil
+	default:
+		return "", fmt.Errorf("expected full, short or name, got %q", value)
+	}
+}
+
+// containerIDPath returns where the ID of a target's new container is saved
+func (c *Config) containerIDPath() string {
+	return strings.ReplaceAll(expandLocalHome(c.ContainerIDFile), "{target}", c.RemoteServer)
+}
+
+// parseContainerID picks the container ID out of the output of docker run
+// -d, which may start with warnings or the progress of pulling the image.
+// It returns "" if there is no ID, e.g. when DOCKER_RUN_ARGS lacks -d and
+// the output is the container's own.
+func parseContainerID(output string) (id string, other []string) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case containerIDLine.MatchString(line):
+			id = line
+		case line != "":
+			other = append(other, line)
+		}
+	}
+	return id, other
+}
+
+// containerNameCommand prints the name of a container, with a leading /
+func (sm *SyncManager) containerNameCommand(container string) string {
+	return fmt.Sprintf("%s inspect -f '{{.Name}}' %s", sm.dockerCmd(), container)
+}
+
+// recordContainer notes the container docker run started: its full ID in
+// the summary, and in CONTAINER_ID_FORMAT in the log and CONTAINER_ID_FILE
+func (sm *SyncManager) recordContainer(output string) error {
+	id, other := parseContainerID(output)
+	if id == "" {
+		if sm.config.ContainerIDFile != "" {
+			return fmt.Errorf("docker run printed no container ID for CONTAINER_ID_FILE; DOCKER_RUN_ARGS needs -d")
+		}
+		if len(other) > 0 {
+			log.Printf("✅ Container ran, with output:\n%s", strings.Join(other, "\n"))
+		}
+		return nil
+	}
+	for _, line := range other {
+		log.Printf("⚠️  docker run: %s", line)
+	}
+	sm.result.ContainerID = id
+
+	ref := id
+	switch sm.config.ContainerIDFormat {
+	case "short":
+		ref = shortID(id)
+	case "name":
+		output, err := sm.executeRemoteCommandWithOutput(sm.containerNameCommand(id), false)
+		if err != nil {
+			return fmt.Errorf("container %s started, but its name couldn't be read: %w", shortID(id), err)
+		}
+		ref = strings.TrimPrefix(strings.TrimSpace(output), "/")
+		sm.result.ContainerName = ref
+	}
+	log.Printf("✅ Container started: %s", ref)
+
+	if sm.config.ContainerIDFile != "" {
+		idPath := sm.config.containerIDPath()
+		if err := os.WriteFile(idPath, []byte(ref+"\n"), 0644); err != nil {
+			return fmt.Errorf("container %s started, but CONTAINER_ID_FILE couldn't be written: %w", ref, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseContainerID(t *testing.T) {
+	id := strings.Repeat("3f2a9c1e", 8)
+	tests := []struct {
+		name   string
+		output string
+		id     string
+		other  []string
+	}{
+		{name: "empty"},
+		{name: "just the ID", output: id + "\n", id: id},
+		{name: "CRLF", output: id + "\r\n", id: id},
+		{
+			name:   "after a pull",
+			output: "Unable to find image 'app:latest' locally\nlatest: Pulling from library/app\nStatus: Downloaded newer image for app:latest\n" + id + "\n",
+			id:     id,
+			other:  []string{"Unable to find image 'app:latest' locally", "latest: Pulling from library/app", "Status: Downloaded newer image for app:latest"},
+		},
+		{
+			name:   "after a warning",
+			output: "WARNING: Published ports are discarded when using host network mode\n" + id,
+			id:     id,
+			other:  []string{"WARNING: Published ports are discarded when using host network mode"},
+		},
+		{name: "without -d", output: "listening on :8080\n\nshutting down\n", other: []string{"listening on :8080", "shutting down"}},
+		{name: "short ID", output: id[:12] + "\n", other: []string{id[:12]}},
+		{name: "upper case", output: strings.ToUpper(id) + "\n", other: []string{strings.ToUpper(id)}},
+	}
+	for _, tt := range tests {
+		gotID, gotOther := parseContainerID(tt.output)
+		if gotID != tt.id || !reflect.DeepEqual(gotOther, tt.other) {
+			t.Errorf("%s: parseContainerID = %q, %q, want %q, %q", tt.name, gotID, gotOther, tt.id, tt.other)
+		}
+	}
+}
+
+func TestParseContainerIDFormat(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "", want: "full"},
+		{value: "full", want: "full"},
+		{value: "Short", want: "short"},
+		{value: "name", want: "name"},
+		{value: "long", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseContainerIDFormat(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseContainerIDFormat(%q) = %q, %v, want %q (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestContainerIDPath(t *testing.T) {
+	t.Setenv("HOME", "/home/dev")
+	tests := []struct {
+		file, server, want string
+	}{
+		{file: "container.id", server: "web1", want: "container.id"},
+		{file: "container-{target}.id", server: "web1", want: "container-web1.id"},
+		{file: "~/ids/{target}", server: "web2", want: "/home/dev/ids/web2"},
+	}
+	for _, tt := range tests {
+		c := &Config{ContainerIDFile: tt.file, RemoteServer: tt.server}
+		if got := c.containerIDPath(); got != tt.want {
+			t.Errorf("containerIDPath(%q, %q) = %q, want %q", tt.file, tt.server, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/sftp"
+)
+
+// OpenSSH multiplexing protocol messages, see PROTOCOL.mux in the OpenSSH sources
+const (
+	muxMsgHello          = 0x00000001
+	muxCNewSession       = 0x10000002
+	muxSPermissionDenied = 0x80000002
+	muxSFailure          = 0x80000003
+	muxSExitMessage      = 0x80000004
+	muxSSessionOpened    = 0x80000006
+	muxSTTYAllocFail     = 0x80000008
+
+	muxProtocolVersion = 4
+	muxNoEscapeChar    = 0xffffffff
+)
+
+// muxExitError reports a non-zero exit status of a command run through the control master
+type muxExitError struct {
+	status uint32
+}
+
+func (e *muxExitError) Error() string {
+	return fmt.Sprintf("Process exited with status %d", e.status)
+}
+
+// muxSession runs one command (or the sftp subsystem) through an OpenSSH
+// ControlMaster socket. It mirrors the parts of ssh.Session that pooshit uses.
+type muxSession struct {
+	socketPath string
+	subsystem  bool
+	conn       *net.UnixConn
+	reader     *bufio.Reader
+
+	stdout, stderr io.Writer
+	stdinPipe      *os.File
+	stdoutPipe     *os.File
+	stderrPipe     *os.File
+	wantStdin      bool
+	wantStdout     bool
+	wantStderr     bool
+
+	copies sync.WaitGroup
+}
+
+// connectControlSocket opens the SFTP subsystem over an existing ControlMaster
+// socket, so commands and transfers reuse its already authenticated connection
+func (sm *SyncManager) connectControlSocket(socketPath string) error {
+	session := &muxSession{socketPath: socketPath, subsystem: true, wantStdin: true, wantStdout: true}
+	if err := session.Start("sftp"); err != nil {
+		return err
+	}
+
+	sm.acquireSession()
+	sftpClient, err := sftp.NewClientPipe(session.stdoutPipe, session.stdinPipe, sm.sftpOptions()...)
+	if err != nil {
+		sm.releaseSession()
+		session.Close()
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	sm.sftpClient = sftpClient
+	sm.controlSession = session
+	sm.controlSocket = socketPath
+	return nil
+}
+
+// newMuxSession returns a session that runs through the control master at socketPath
+func newMuxSession(socketPath string) (remoteSession, error) {
+	return &muxSession{socketPath: socketPath}, nil
+}
+
+// StdoutPipe returns a reader for the command's standard output
+func (s *muxSession) StdoutPipe() (io.Reader, error) {
+	s.wantStdout = true
+	return readerFunc(func(p []byte) (int, error) { return s.stdoutPipe.Read(p) }), nil
+}
+
+// StderrPipe returns a reader for the command's standard error
+func (s *muxSession) StderrPipe() (io.Reader, error) {
+	s.wantStderr = true
+	return readerFunc(func(p []byte) (int, error) { return s.stderrPipe.Read(p) }), nil
+}
+
+// Start asks the control master to open a new session running command
+func (s *muxSession) Start(command string) error {
+	// The master talks to the remote command through these descriptors
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdinR.Close()
+	defer stdoutW.Close()
+	defer stderrW.Close()
+	s.stdinPipe, s.stdoutPipe, s.stderrPipe = stdinW, stdoutR, stderrR
+
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: s.socketPath, Net: "unix"})
+	if err != nil {
+		s.closePipes()
+		return fmt.Errorf("failed to dial control socket: %w", err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	if err := s.start(command, stdinR, stdoutW, stderrW); err != nil {
+		s.Close()
+		return err
+	}
+
+	if !s.wantStdin {
+		s.stdinPipe.Close()
+	}
+	s.copyOutput(s.stdoutPipe, s.stdout, s.wantStdout)
+	s.copyOutput(s.stderrPipe, s.stderr, s.wantStderr)
+	return nil
+}
+
+// start exchanges hellos, requests the session and hands over the stdio descriptors
+func (s *muxSession) start(command string, stdin, stdout, stderr *os.File) error {
+	var hello muxBuffer
+	hello.putUint32(muxMsgHello)
+	hello.putUint32(muxProtocolVersion)
+	if err := s.writePacket(hello.Bytes()); err != nil {
+		return err
+	}
+	msgType, _, err := s.readPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read control master hello: %w", err)
+	}
+	if msgType != muxMsgHello {
+		return fmt.Errorf("unexpected control master message %#x instead of hello", msgType)
+	}
+
+	subsystem := uint32(0)
+	if s.subsystem {
+		subsystem = 1
+	}
+	var req muxBuffer
+	req.putUint32(muxCNewSession)
+	req.putUint32(1) // request id
+	req.putString("")
+	req.putUint32(0) // tty
+	req.putUint32(0) // X11 forwarding
+	req.putUint32(0) // agent forwarding
+	req.putUint32(subsystem)
+	req.putUint32(muxNoEscapeChar)
+	req.putString(os.Getenv("TERM"))
+	req.putString(command)
+	if err := s.writePacket(req.Bytes()); err != nil {
+		return err
+	}
+
+	// Each descriptor goes in its own message, as ssh -S does
+	for _, f := range []*os.File{stdin, stdout, stderr} {
+		rights := syscall.UnixRights(int(f.Fd()))
+		if _, _, err := s.conn.WriteMsgUnix([]byte{0}, rights, nil); err != nil {
+			return fmt.Errorf("failed to pass descriptor to control master: %w", err)
+		}
+	}
+
+	msgType, body, err := s.readPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read control master reply: %w", err)
+	}
+	switch msgType {
+	case muxSSessionOpened:
+		return nil
+	case muxSPermissionDenied, muxSFailure:
+		return fmt.Errorf("control master refused session: %s", muxReason(body))
+	default:
+		return fmt.Errorf("unexpected control master message %#x", msgType)
+	}
+}
+
+// copyOutput drains a pipe into w, or discards it if nobody asked for it
+func (s *muxSession) copyOutput(pipe *os.File, w io.Writer, piped bool) {
+	if piped {
+		return
+	}
+	if w == nil {
+		w = io.Discard
+	}
+	s.copies.Add(1)
+	go func() {
+		defer s.copies.Done()
+		io.Copy(w, pipe)
+	}()
+}
+
+// Wait waits for the remote command to exit
+func (s *muxSession) Wait() error {
+	for {
+		msgType, body, err := s.readPacket()
+		if err != nil {
+			return fmt.Errorf("control master closed the session without an exit status: %w", err)
+		}
+		switch msgType {
+		case muxSTTYAllocFail:
+			continue
+		case muxSExitMessage:
+			s.copies.Wait()
+			// body: session id, exit value
+			if len(body) < 8 {
+				return fmt.Errorf("short exit message from control master")
+			}
+			if status := binary.BigEndian.Uint32(body[4:8]); status != 0 {
+				return &muxExitError{status: status}
+			}
+			return nil
+		default:
+			return fmt.Errorf("unexpected control master message %#x", msgType)
+		}
+	}
+}
+
+// Run starts command and waits for it to finish
+func (s *muxSession) Run(command string) error {
+	if err := s.Start(command); err != nil {
+		return err
+	}
+	return s.Wait()
+}
+
+// Output runs command and returns its standard output
+func (s *muxSession) Output(command string) ([]byte, error) {
+	var stdout bytes.Buffer
+	s.stdout = &stdout
+	err := s.Run(command)
+	return stdout.Bytes(), err
+}
+
+// CombinedOutput runs command and returns its standard output and error interleaved
+func (s *muxSession) CombinedOutput(command string) ([]byte, error) {
+	var output lockedBuffer
+	s.stdout, s.stderr = &output, &output
+	err := s.Run(command)
+	return output.Bytes(), err
+}
+
+// Close releases the control socket connection and the session's pipes
+func (s *muxSession) Close() error {
+	s.closePipes()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+func (s *muxSession) closePipes() {
+	for _, f := range []*os.File{s.stdinPipe, s.stdoutPipe, s.stderrPipe} {
+		if f != nil {
+			f.Close()
+		}
+	}
+}
+
+func (s *muxSession) writePacket(body []byte) error {
+	packet := make([]byte, 4, 4+len(body))
+	binary.BigEndian.PutUint32(packet, uint32(len(body)))
+	if _, err := s.conn.Write(append(packet, body...)); err != nil {
+		return fmt.Errorf("failed to write to control socket: %w", err)
+	}
+	return nil
+}
+
+func (s *muxSession) readPacket() (uint32, []byte, error) {
+	var size uint32
+	if err := binary.Read(s.reader, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+	if size < 4 || size > 256*1024 {
+		return 0, nil, fmt.Errorf("bad control message length %d", size)
+	}
+	packet := make([]byte, size)
+	if _, err := io.ReadFull(s.reader, packet); err != nil {
+		return 0, nil, err
+	}
+	return binary.BigEndian.Uint32(packet), packet[4:], nil
+}
+
+// muxReason extracts the reason string from a failure reply (request id, reason)
+func muxReason(body []byte) string {
+	if len(body) < 8 {
+		return "no reason given"
+	}
+	n := binary.BigEndian.Uint32(body[4:8])
+	if int(n) > len(body)-8 {
+		return "no reason given"
+	}
+	return string(body[8 : 8+n])
+}
+
+// muxBuffer builds SSH wire-format message bodies
+type muxBuffer struct {
+	bytes.Buffer
+}
+
+func (b *muxBuffer) putUint32(v uint32) {
+	binary.Write(&b.Buffer, binary.BigEndian, v)
+}
+
+func (b *muxBuffer) putString(s string) {
+	b.putUint32(uint32(len(s)))
+	b.WriteString(s)
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent writes from stdout and stderr copies
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// readerFunc adapts a function to io.Reader
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
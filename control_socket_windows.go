@@ -0,0 +1,13 @@
+package main
+
+import "fmt"
+
+// connectControlSocket is not available on Windows, which has no OpenSSH ControlMaster
+func (sm *SyncManager) connectControlSocket(socketPath string) error {
+	return fmt.Errorf("SSH control sockets are not supported on Windows")
+}
+
+// newMuxSession is not available on Windows, which has no OpenSSH ControlMaster
+func newMuxSession(socketPath string) (remoteSession, error) {
+	return nil, fmt.Errorf("SSH control sockets are not supported on Windows")
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// deltaBlockSize is the granularity of delta transfers: the remote copy is
+// split into blocks of this size, and any run of the local file that matches
+// one of them, at whatever offset, is copied on the server instead of sent
+const deltaBlockSize = 256 * 1024
+
+// defaultDeltaMinSize is the smallest file considered for delta transfer;
+// below it the checksum round-trip costs more than just sending the file
+const defaultDeltaMinSize = 8 << 20
+
+// useDelta reports whether a changed file should be sent as a delta
+func (sm *SyncManager) useDelta(info os.FileInfo) bool {
+	return sm.config.Delta && !sm.config.SyncOnly && sm.config.TransferProtocol != "scp" && info.Size() >= sm.config.DeltaMinSize
+}
+
+// blockSignature is what is known of one block of the remote copy: the
+// CRC of cksum, a weak checksum that can roll to find candidates cheaply,
+// and the MD5 that confirms them
+type blockSignature struct {
+	weak   uint32
+	strong string
+	size   int
+}
+
+// deltaOp is one step of rebuilding a file: copy count blocks of the remote
+// copy starting at block, or, with block -1, take count bytes of literal data
+type deltaOp struct {
+	block int
+	count int64
+}
+
+// cksumTable is the CRC-32 table of POSIX cksum, polynomial 0x04C11DB7
+// processed most significant bit first
+var cksumTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+// crcUpdate adds a byte to a cksum CRC
+func crcUpdate(crc uint32, c byte) uint32 {
+	return crc<<8 ^ cksumTable[byte(crc>>24)^c]
+}
+
+// crcOf is the CRC of data before cksumFinish, starting from 0 as cksum does
+func crcOf(data []byte) uint32 {
+	var crc uint32
+	for _, c := range data {
+		crc = crcUpdate(crc, c)
+	}
+	return crc
+}
+
+// cksumFinish turns the CRC of n bytes into what cksum prints, by adding
+// the length and inverting the bits
+func cksumFinish(crc uint32, n int64) uint32 {
+	for ; n > 0; n >>= 8 {
+		crc = crcUpdate(crc, byte(n))
+	}
+	return ^crc
+}
+
+// crcDropTable lets a CRC roll over a window of size bytes: as the CRC is
+// linear and starts from 0, XORing in the entry of the window's first byte
+// takes that byte out, and crcUpdate then adds the next one
+func crcDropTable(size int) (table [256]uint32) {
+	// What a set bit of the CRC becomes after size-1 more zero bytes
+	var shifted [32]uint32
+	for bit := range shifted {
+		crc := uint32(1) << bit
+		for i := 1; i < size; i++ {
+			crc = crcUpdate(crc, 0)
+		}
+		shifted[bit] = crc
+	}
+	for i := range table {
+		for bit, crc := range shifted {
+			if cksumTable[i]&(1<<bit) != 0 {
+				table[i] ^= crc
+			}
+		}
+	}
+	return table
+}
+
+// signatureCommand prints the MD5 and the cksum of each block of a remote
+// file, with POSIX tools only so nothing has to be installed on the server
+func signatureCommand(remotePath string, size int64) string {
+	blocks := (size + deltaBlockSize - 1) / deltaBlockSize
+	block := fmt.Sprintf(`dd if="$f" bs=%d skip=$i count=1 2>/dev/null`, deltaBlockSize)
+	return fmt.Sprintf(`f=%s; i=0; while [ $i -lt %d ]; do %s | md5sum || exit 1; %s | cksum || exit 1; i=$((i+1)); done`,
+		shellQuote(remotePath), blocks, block, block)
+}
+
+// parseSignatures reads the output of signatureCommand for a file of size bytes
+func parseSignatures(output []byte, size int64) ([]blockSignature, error) {
+	blocks := int((size + deltaBlockSize - 1) / deltaBlockSize)
+	var lines [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) > 0 {
+			lines = append(lines, fields)
+		}
+	}
+	if len(lines) != 2*blocks {
+		return nil, fmt.Errorf("expected %d block checksums, got %d lines", blocks, len(lines))
+	}
+
+	sigs := make([]blockSignature, blocks)
+	for i := range sigs {
+		strong, weak := lines[2*i], lines[2*i+1]
+		if len(weak) < 2 {
+			return nil, fmt.Errorf("unexpected cksum output %q", strings.Join(weak, " "))
+		}
+		crc, err := strconv.ParseUint(weak[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cksum output %q", strings.Join(weak, " "))
+		}
+		blockSize, err := strconv.Atoi(weak[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected cksum output %q", strings.Join(weak, " "))
+		}
+		sigs[i] = blockSignature{weak: uint32(crc), strong: strong[0], size: blockSize}
+	}
+	return sigs, nil
+}
+
+// remoteSignatures checksums the blocks of a remote file on the server, so
+// the file isn't downloaded
+func (sm *SyncManager) remoteSignatures(remotePath string, size int64) ([]blockSignature, error) {
+	session, err := sm.newSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer sm.closeSession(session)
+
+	output, err := session.Output(signatureCommand(remotePath, size))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum remote blocks: %w", err)
+	}
+	return parseSignatures(output, size)
+}
+
+// computeDelta works out how to rebuild the local file from the remote
+// copy's blocks. A window the size of a block rolls over the local file one
+// byte at a time; where its weak checksum and then its MD5 match a remote
+// block, the block is copied, otherwise the byte is literal data, written
+// to literals. A short last remote block can only match the end of the file.
+func computeDelta(local io.Reader, sigs []blockSignature, literals io.Writer) ([]deltaOp, error) {
+	byWeak := make(map[uint32][]int)
+	tail := -1
+	for i, sig := range sigs {
+		if sig.size == deltaBlockSize {
+			byWeak[sig.weak] = append(byWeak[sig.weak], i)
+		} else {
+			tail = i
+		}
+	}
+
+	var ops []deltaOp
+	addCopy := func(block int) {
+		if n := len(ops); n > 0 && ops[n-1].block >= 0 && ops[n-1].block+int(ops[n-1].count) == block {
+			ops[n-1].count++
+			return
+		}
+		ops = append(ops, deltaOp{block: block, count: 1})
+	}
+	out := bufio.NewWriter(literals)
+	addLiteral := func(c byte) error {
+		if n := len(ops); n > 0 && ops[n-1].block < 0 {
+			ops[n-1].count++
+		} else {
+			ops = append(ops, deltaOp{block: -1, count: 1})
+		}
+		return out.WriteByte(c)
+	}
+	// matchBlock returns the remote block with the window's content, preferring
+	// the one after the last copied block so runs stay in one op
+	matchBlock := func(window []byte, candidates []int) int {
+		sum := md5.Sum(window)
+		strong := hex.EncodeToString(sum[:])
+		found := -1
+		for _, i := range candidates {
+			if sigs[i].strong != strong {
+				continue
+			}
+			if n := len(ops); n > 0 && ops[n-1].block >= 0 && ops[n-1].block+int(ops[n-1].count) == i {
+				return i
+			}
+			if found < 0 {
+				found = i
+			}
+		}
+		return found
+	}
+
+	drop := crcDropTable(deltaBlockSize)
+	r := bufio.NewReaderSize(local, 4*deltaBlockSize)
+	var crc uint32
+	fresh := true
+	for {
+		window, err := r.Peek(deltaBlockSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(window) < deltaBlockSize {
+			break
+		}
+		if fresh {
+			crc = crcOf(window)
+			fresh = false
+		}
+		if candidates := byWeak[cksumFinish(crc, deltaBlockSize)]; len(candidates) > 0 {
+			if block := matchBlock(window, candidates); block >= 0 {
+				addCopy(block)
+				r.Discard(deltaBlockSize)
+				fresh = true
+				continue
+			}
+		}
+
+		c := window[0]
+		if err := addLiteral(c); err != nil {
+			return nil, err
+		}
+		r.Discard(1)
+		next, err := r.Peek(deltaBlockSize)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(next) == deltaBlockSize {
+			crc = crcUpdate(crc^drop[c], next[deltaBlockSize-1])
+		}
+	}
+
+	// Less than a block is left, which only the short last remote block can match
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	for len(rest) > 0 {
+		if tail >= 0 && len(rest) == sigs[tail].size && matchBlock(rest, []int{tail}) == tail {
+			addCopy(tail)
+			break
+		}
+		if err := addLiteral(rest[0]); err != nil {
+			return nil, err
+		}
+		rest = rest[1:]
+	}
+	return ops, out.Flush()
+}
+
+// deltaScript is the shell script that writes the rebuilt file to outPath:
+// block runs are copied from oldPath and literal data is read in order from
+// litPath, all with dd at block-aligned offsets, so it runs on any POSIX shell
+func deltaScript(oldPath, litPath, outPath string, ops []deltaOp) string {
+	var script strings.Builder
+	// ":" keeps the group valid when the new file is empty
+	fmt.Fprintf(&script, "set -e\nexec 3< %s\n{\n:\n", shellQuote(litPath))
+	for _, op := range ops {
+		if op.block >= 0 {
+			fmt.Fprintf(&script, "dd if=%s bs=%d skip=%d count=%d 2>/dev/null\n", shellQuote(oldPath), deltaBlockSize, op.block, op.count)
+			continue
+		}
+		if blocks := op.count / deltaBlockSize; blocks > 0 {
+			fmt.Fprintf(&script, "dd bs=%d count=%d <&3 2>/dev/null\n", deltaBlockSize, blocks)
+		}
+		if rest := op.count % deltaBlockSize; rest > 0 {
+			fmt.Fprintf(&script, "dd bs=%d count=1 <&3 2>/dev/null\n", rest)
+		}
+	}
+	fmt.Fprintf(&script, "} > %s\n", shellQuote(outPath))
+	return script.String()
+}
+
+// deltaUpload updates an existing remote file by sending only the data the
+// remote copy doesn't already have, rsync style. The new file is built next
+// to the original from its blocks and the uploaded literal data, checked
+// against the local MD5, and renamed over the original, so an interrupted
+// delta never leaves a half-written file behind. It returns the number of
+// bytes sent.
+func (sm *SyncManager) deltaUpload(localPath, remotePath string, remoteInfo os.FileInfo, mode os.FileMode) (sent int64, err error) {
+	sigs, err := sm.remoteSignatures(remotePath, remoteInfo.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer localFile.Close()
+
+	// The literal data is staged locally, as it is only known once the whole file is read
+	literals, err := os.CreateTemp("", "pooshit-delta-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create a temporary file: %w", err)
+	}
+	defer os.Remove(literals.Name())
+	defer literals.Close()
+
+	hash := md5.New()
+	ops, err := computeDelta(io.TeeReader(localFile, hash), sigs, literals)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read local file: %w", err)
+	}
+	localSum := hex.EncodeToString(hash.Sum(nil))
+	if _, err := literals.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	tempPath := remoteTempPath(remotePath)
+	litPath, scriptPath := tempPath+".lit", tempPath+".sh"
+	defer func() {
+		sm.sftpClient.Remove(litPath)
+		sm.sftpClient.Remove(scriptPath)
+		if err != nil {
+			sm.sftpClient.Remove(tempPath)
+		}
+	}()
+
+	litFile, err := sm.sftpClient.Create(litPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create remote file: %w", err)
+	}
+	n, err := sm.copyFile(litFile, literals)
+	sent += n
+	if closeErr := litFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return sent, fmt.Errorf("failed to send the changed data: %w", err)
+	}
+
+	script := deltaScript(remotePath, litPath, tempPath, ops)
+	scriptFile, err := sm.sftpClient.Create(scriptPath)
+	if err != nil {
+		return sent, fmt.Errorf("failed to create remote file: %w", err)
+	}
+	_, err = io.WriteString(scriptFile, script)
+	sent += int64(len(script))
+	if closeErr := scriptFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return sent, fmt.Errorf("failed to send the delta script: %w", err)
+	}
+
+	output, err := sm.executeRemoteCommandWithOutput(fmt.Sprintf("sh %s && md5sum %s", shellQuote(scriptPath), shellQuote(tempPath)), false)
+	if err != nil {
+		return sent, fmt.Errorf("failed to rebuild the file on the server: %w: %s", err, strings.TrimSpace(output))
+	}
+	if fields := strings.Fields(output); len(fields) == 0 || fields[0] != localSum {
+		return sent, fmt.Errorf("the rebuilt file doesn't match the local file")
+	}
+
+	// Keep permissions in line with a regular upload
+	if err := sm.sftpClient.Chmod(tempPath, mode); err != nil {
+		return sent, fmt.Errorf("failed to set the mode of the remote file: %w", err)
+	}
+	if err := sm.sftpClient.PosixRename(tempPath, remotePath); err != nil {
+		return sent, fmt.Errorf("failed to move the patched file into place: %w", err)
+	}
+	return sent, nil
+}
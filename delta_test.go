@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// signaturesOf checksums the blocks of data as signatureCommand does on the server
+func signaturesOf(data []byte) []blockSignature {
+	var sigs []blockSignature
+	for start := 0; start < len(data); start += deltaBlockSize {
+		end := start + deltaBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		sum := md5.Sum(data[start:end])
+		sigs = append(sigs, blockSignature{weak: cksumFinish(crcOf(data[start:end]), int64(end-start)), strong: hex.EncodeToString(sum[:]), size: end - start})
+	}
+	return sigs
+}
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestCksum(t *testing.T) {
+	// Checked against the output of cksum
+	tests := []struct {
+		data string
+		want uint32
+	}{
+		{data: "", want: 4294967295},
+		{data: "a", want: 1220704766},
+		{data: "hello world\n", want: 3733384285},
+	}
+	for _, tt := range tests {
+		if got := cksumFinish(crcOf([]byte(tt.data)), int64(len(tt.data))); got != tt.want {
+			t.Errorf("cksum of %q = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestRollCRC(t *testing.T) {
+	data := randomBytes(1, 3*deltaBlockSize)
+	drop := crcDropTable(deltaBlockSize)
+	crc := crcOf(data[:deltaBlockSize])
+	for i := 1; i <= 2*deltaBlockSize; i++ {
+		crc = crcUpdate(crc^drop[data[i-1]], data[i-1+deltaBlockSize])
+		if i%4099 != 1 {
+			continue
+		}
+		if want := crcOf(data[i : i+deltaBlockSize]); crc != want {
+			t.Fatalf("rolled CRC at %d = %d, want %d", i, crc, want)
+		}
+	}
+}
+
+func TestSignatureCommand(t *testing.T) {
+	data := concat(randomBytes(2, 2*deltaBlockSize+1000), bytes.Repeat([]byte{0xff}, deltaBlockSize))
+	file := filepath.Join(t.TempDir(), "old.bin")
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("sh", "-c", signatureCommand(file, int64(len(data)))).Output()
+	if err != nil {
+		t.Fatalf("signature command: %v", err)
+	}
+	sigs, err := parseSignatures(output, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := signaturesOf(data); !reflect.DeepEqual(sigs, want) {
+		t.Errorf("signatures = %v, want %v", sigs, want)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	old := randomBytes(3, 6*deltaBlockSize+12345)
+	tests := []struct {
+		name string
+		new  []byte
+		// maxLiteral is the most literal data the delta may send
+		maxLiteral int64
+	}{
+		{name: "unchanged", new: old, maxLiteral: 0},
+		{name: "insert", new: concat(old[:1000], []byte("inserted"), old[1000:]), maxLiteral: deltaBlockSize + 8},
+		{name: "insert in the middle", new: concat(old[:3*deltaBlockSize+7], randomBytes(4, 500), old[3*deltaBlockSize+7:]), maxLiteral: deltaBlockSize + 500},
+		{name: "delete", new: concat(old[:2*deltaBlockSize+10], old[2*deltaBlockSize+20:]), maxLiteral: deltaBlockSize},
+		{name: "append", new: concat(old, randomBytes(5, 3000)), maxLiteral: 12345 + 3000},
+		{name: "truncate", new: old[:4*deltaBlockSize+99], maxLiteral: 99},
+		{name: "truncate to blocks", new: old[:3*deltaBlockSize], maxLiteral: 0},
+		{name: "replace a block", new: concat(old[:deltaBlockSize], randomBytes(6, deltaBlockSize), old[2*deltaBlockSize:]), maxLiteral: deltaBlockSize},
+		{name: "move blocks", new: concat(old[4*deltaBlockSize:], old[:4*deltaBlockSize]), maxLiteral: 12345},
+		{name: "all new", new: randomBytes(7, 2*deltaBlockSize), maxLiteral: 2 * deltaBlockSize},
+		{name: "empty", new: nil, maxLiteral: 0},
+	}
+	dir := t.TempDir()
+	oldPath, litPath, outPath := filepath.Join(dir, "old"), filepath.Join(dir, "lit"), filepath.Join(dir, "out")
+	if err := os.WriteFile(oldPath, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sigs := signaturesOf(old)
+	for _, tt := range tests {
+		var literals bytes.Buffer
+		ops, err := computeDelta(bytes.NewReader(tt.new), sigs, &literals)
+		if err != nil {
+			t.Errorf("%s: computeDelta: %v", tt.name, err)
+			continue
+		}
+		if int64(literals.Len()) > tt.maxLiteral {
+			t.Errorf("%s: %d bytes of literal data, want at most %d", tt.name, literals.Len(), tt.maxLiteral)
+		}
+
+		if err := os.WriteFile(litPath, literals.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if output, err := exec.Command("sh", "-c", deltaScript(oldPath, litPath, outPath, ops)).CombinedOutput(); err != nil {
+			t.Errorf("%s: delta script: %v: %s", tt.name, err, output)
+			continue
+		}
+		got, err := os.ReadFile(outPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, tt.new) {
+			t.Errorf("%s: rebuilt %d bytes that differ from the %d bytes of the new file", tt.name, len(got), len(tt.new))
+		}
+	}
+}
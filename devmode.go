@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultDevPollInterval is how often dev mode checks the local folders
+const defaultDevPollInterval = time.Second
+
+// defaultDevDebounce lets an editor or a git checkout finish writing files
+// before dev mode deploys them
+const defaultDevDebounce = 500 * time.Millisecond
+
+// logsDrainTimeout is how long the output of a stopped log stream is waited for
+const logsDrainTimeout = 2 * time.Second
+
+// fileStamp is what dev mode compares to notice a changed file
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// devLoop is the state of a running dev mode
+type devLoop struct {
+	sm *SyncManager
+	// stopLogs ends the stream of the current container's logs; nil if none
+	stopLogs func()
+	// container is the one whose logs are streamed, to pick them up again
+	// after IDLE_TIMEOUT closed the connection
+	container string
+	// idle is set while IDLE_TIMEOUT has the connection closed
+	idle bool
+	// logsSince is when the log stream was stopped by IDLE_TIMEOUT, so it
+	// resumes with what the container printed in between; zero otherwise
+	logsSince time.Time
+}
+
+// RunDev is dev mode: a push, then a loop that watches the local folders,
+// syncs them when they change and, if anything was uploaded, rebuilds and
+// restarts the container. The container's logs are streamed in between.
+// Interrupting it stops the container and returns.
+func (sm *SyncManager) RunDev() error {
+	if sm.config.OnlyDocker {
+		return fmt.Errorf("dev mode watches LOCAL_FOLDER, so it can't be combined with ONLY_DOCKER")
+	}
+	if sm.config.FromGitRef != "" {
+		return fmt.Errorf("dev mode watches LOCAL_FOLDER, so it can't be combined with FROM_GIT_REF")
+	}
+	if sm.config.Fresh {
+		return fmt.Errorf("dev mode rebuilds on every change, so it can't be combined with FRESH")
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	// The snapshot leaves out OWNED_MANIFEST files, as the pushes do
+	if err := sm.loadOwnedManifest(); err != nil {
+		return err
+	}
+	loop := &devLoop{sm: sm}
+	baseline, err := sm.devSnapshot()
+	if err != nil {
+		return err
+	}
+	loop.deploy(true)
+
+	log.Printf("\n👀 Watching for changes every %s (Ctrl-C stops the container and exits)", sm.config.DevPollInterval)
+	ticker := time.NewTicker(sm.config.DevPollInterval)
+	defer ticker.Stop()
+	var changedAt time.Time
+	activeAt := time.Now()
+	for {
+		select {
+		case <-stop:
+			loop.shutdown()
+			return nil
+		case <-ticker.C:
+		}
+
+		if timeout := sm.config.IdleTimeout; timeout > 0 && changedAt.IsZero() && !loop.idle && time.Since(activeAt) >= timeout {
+			loop.disconnect()
+		}
+
+		current, err := sm.devSnapshot()
+		if err != nil {
+			log.Printf("⚠️  Could not check the local folders for changes: %v", err)
+			continue
+		}
+		// Changes made while a deploy runs are caught too, as the baseline
+		// is what the deploy started from
+		if !sameSnapshot(current, baseline) {
+			baseline = current
+			changedAt = time.Now()
+			continue
+		}
+		if !changedAt.IsZero() && time.Since(changedAt) >= sm.config.DevDebounce {
+			changedAt = time.Time{}
+			log.Println("\n🔁 Local files changed, syncing...")
+			if loop.reconnect() {
+				loop.deploy(false)
+			} else {
+				log.Println("   Waiting for the next change")
+			}
+			activeAt = time.Now()
+		}
+	}
+}
+
+// deploy syncs the local folders and, on the first run or when files were
+// uploaded, rebuilds and restarts the container. Failures are logged, not
+// returned, so the next change gets another try.
+func (l *devLoop) deploy(first bool) {
+	sm := l.sm
+	sm.result = &SyncResult{Target: sm.config.RemoteServer, Mode: "dev", Timestamp: time.Now()}
+	sm.phases = nil
+
+	if err := sm.SyncFiles(); err != nil {
+		log.Printf("❌ File synchronization failed: %v", err)
+		log.Println("   Waiting for the next change")
+		return
+	}
+	if sm.config.SyncOnly {
+		return
+	}
+	if !first && sm.result.FilesTransferred == 0 {
+		log.Println("   Nothing uploaded, so the container keeps running")
+		if l.stopLogs == nil && l.container != "" {
+			l.followLogs(l.container)
+		}
+		return
+	}
+
+	l.endLogs()
+	if err := sm.ExecuteDockerCommands(); err != nil {
+		log.Printf("❌ Docker operations failed: %v", err)
+		log.Println("   Waiting for the next change")
+		return
+	}
+	if sm.result.ContainerID == "" {
+		log.Println("⚠️  docker run printed no container ID to follow the logs of; DOCKER_RUN_ARGS needs -d")
+		return
+	}
+	l.logsSince = time.Time{}
+	l.followLogs(sm.result.ContainerID)
+}
+
+// followLogs streams a container's logs
+func (l *devLoop) followLogs(container string) {
+	l.container = container
+	log.Printf("\n📜 Logs of %s:", shortID(container))
+	stopLogs, err := l.sm.followContainerLogs(container, l.logsSince)
+	if err != nil {
+		log.Printf("⚠️  Could not follow the container's logs: %v", err)
+		return
+	}
+	l.stopLogs = stopLogs
+	l.logsSince = time.Time{}
+}
+
+// disconnect closes the connection once nothing has changed for
+// IDLE_TIMEOUT. The container keeps running; only its log stream ends.
+func (l *devLoop) disconnect() {
+	if l.stopLogs != nil {
+		l.logsSince = time.Now()
+	}
+	l.endLogs()
+	l.sm.Close()
+	l.idle = true
+	log.Printf("\n💤 No changes for %s, so the connection is closed until the next one (IDLE_TIMEOUT)", l.sm.config.IdleTimeout)
+}
+
+// reconnect opens the connection again if IDLE_TIMEOUT closed it, and
+// reports whether there is one
+func (l *devLoop) reconnect() bool {
+	if !l.idle {
+		return true
+	}
+	log.Printf("🔌 Reconnecting to %s", l.sm.config.RemoteServer)
+	if err := l.sm.Connect(); err != nil {
+		log.Printf("❌ Could not reconnect: %v", err)
+		return false
+	}
+	l.idle = false
+	return true
+}
+
+// endLogs stops streaming the current container's logs
+func (l *devLoop) endLogs() {
+	if l.stopLogs != nil {
+		l.stopLogs()
+		l.stopLogs = nil
+	}
+}
+
+// shutdown stops the log stream and the containers of the image
+func (l *devLoop) shutdown() {
+	l.endLogs()
+	if l.sm.config.SyncOnly {
+		log.Println("\n👋 Stopped watching")
+		return
+	}
+	if !l.reconnect() {
+		log.Println("⚠️  The containers were left running")
+		return
+	}
+	log.Printf("\n🛑 Stopping containers using image: %s", l.sm.config.DockerImageName)
+	if err := l.sm.executeRemoteCommandQuiet(l.sm.stopContainersCommand()); err != nil {
+		log.Printf("⚠️  Could not stop the containers: %v", err)
+		return
+	}
+	log.Println("👋 Dev container stopped")
+}
+
+// followContainerLogs streams a container's output to this terminal until
+// the returned function is called or the container exits. Output from
+// before since is left out, unless since is zero.
+func (sm *SyncManager) followContainerLogs(container string, since time.Time) (func(), error) {
+	session, err := sm.newSession()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		sm.closeSession(session)
+		return nil, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		sm.closeSession(session)
+		return nil, err
+	}
+	cmd := fmt.Sprintf("%s logs -f %s", sm.dockerCmd(), container)
+	if !since.IsZero() {
+		cmd = fmt.Sprintf("%s logs -f --since %d %s", sm.dockerCmd(), since.Unix(), container)
+	}
+	if err := session.Start(cmd); err != nil {
+		sm.closeSession(session)
+		return nil, err
+	}
+
+	// Wait only once the output has been read, or the end of it may be lost
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { io.Copy(os.Stdout, stdout); wg.Done() }()
+		go func() { io.Copy(os.Stderr, stderr); wg.Done() }()
+		wg.Wait()
+		session.Wait()
+		close(done)
+	}()
+
+	// A local shell's children can keep the pipes open after it is killed,
+	// so the rest of the output is only waited for briefly
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sm.closeSession(session)
+			select {
+			case <-done:
+			case <-time.After(logsDrainTimeout):
+			}
+		})
+	}, nil
+}
+
+// devSnapshot stamps every file a push of the local folders would consider,
+// with the same ignore rules
+func (sm *SyncManager) devSnapshot() (map[string]fileStamp, error) {
+	type folderPair struct {
+		local, remote string
+		ignores       []string
+	}
+	pairs := []folderPair{{sm.config.LocalFolder, sm.config.RemoteFolder, sm.config.IgnorePatterns}}
+	if sm.config.LocalFolder2 != "" {
+		pairs = append(pairs, folderPair{sm.config.LocalFolder2, sm.config.RemoteFolder2, sm.config.IgnorePatterns2})
+	}
+
+	snapshot := make(map[string]fileStamp)
+	for _, pair := range pairs {
+		localRoot, err := filepath.EvalSymlinks(pair.local)
+		if err == nil {
+			localRoot, err = filepath.Abs(localRoot)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve local folder '%s': %w", pair.local, err)
+		}
+		remotePath, err := sm.resolveRemotePath(pair.remote)
+		if err != nil {
+			return nil, err
+		}
+		files, err := sm.scanLocalFolder(pair.local, localRoot, remotePath, pair.remote == sm.config.RemoteFolder, pair.ignores, &FolderResult{}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan local directory: %w", err)
+		}
+		for _, file := range files {
+			snapshot[file.localPath] = fileStamp{size: file.info.Size(), modTime: file.info.ModTime()}
+		}
+	}
+	return snapshot, nil
+}
+
+// sameSnapshot reports whether no file was added, removed or changed
+func sameSnapshot(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		other, ok := b[path]
+		if !ok || other.size != stamp.size || !other.modTime.Equal(stamp.modTime) {
+			return false
+		}
+	}
+	return true
+}
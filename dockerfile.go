@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// dockerfileName returns the Dockerfile the build uses: the -f/--file given
+// in DOCKER_BUILD_ARGS, relative to the build context, or "Dockerfile"
+func (c *Config) dockerfileName() string {
+	args := strings.Fields(c.DockerBuildArgs)
+	for i, arg := range args {
+		switch {
+		case (arg == "-f" || arg == "--file") && i+1 < len(args):
+			return args[i+1]
+		case strings.HasPrefix(arg, "--file="):
+			return strings.TrimPrefix(arg, "--file=")
+		case strings.HasPrefix(arg, "-f") && len(arg) > 2 && !strings.HasPrefix(arg, "--"):
+			return arg[2:]
+		}
+	}
+	return "Dockerfile"
+}
+
+// checkLocalDockerfile fails a STRICT_DOCKERFILE push before it connects
+// when the Dockerfile isn't in LOCAL_FOLDER. An absolute -f path names a
+// file on the remote, which ExecuteDockerCommands checks instead.
+func (c *Config) checkLocalDockerfile() error {
+	name := c.dockerfileName()
+	if path.IsAbs(name) {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(c.LocalFolder, filepath.FromSlash(name))); err != nil {
+		return fmt.Errorf("STRICT_DOCKERFILE: %s not found in local folder '%s'", name, c.LocalFolder)
+	}
+	return nil
+}
+
+// checkRemoteDockerfile fails a STRICT_DOCKERFILE push before the running
+// container is stopped when the Dockerfile isn't in the remote folder
+func (sm *SyncManager) checkRemoteDockerfile(remotePath string) error {
+	name := sm.config.dockerfileName()
+	if !path.IsAbs(name) {
+		name = path.Join(remotePath, name)
+	}
+	output, err := sm.executeRemoteCommandWithOutput(fmt.Sprintf("test -f %s && echo found || echo missing", shellQuote(name)), false)
+	if err != nil {
+		return fmt.Errorf("STRICT_DOCKERFILE: could not check for %s: %w", name, err)
+	}
+	if strings.TrimSpace(output) != "found" {
+		return fmt.Errorf("STRICT_DOCKERFILE: %s not found on the remote; nothing was stopped", name)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// doctorCheck is one line of the doctor checklist
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+}
+
+// runDoctor verifies that a deploy with this config can succeed without
+// changing anything except a temporary file, printing a pass/fail checklist.
+// It returns false if any check failed.
+func runDoctor(config *Config) bool {
+	fmt.Println("\n🩺 Pooshit doctor")
+	fmt.Println("─────────────────────────────────────────")
+
+	var checks []doctorCheck
+	check := func(name string, err error) bool {
+		c := doctorCheck{name: name, ok: err == nil}
+		if err != nil {
+			c.detail = err.Error()
+		}
+		checks = append(checks, c)
+		return c.ok
+	}
+
+	// Local checks
+	if info, err := os.Stat(config.LocalFolder); err != nil {
+		check(fmt.Sprintf("Local folder %s exists", config.LocalFolder), err)
+	} else if !info.IsDir() {
+		check(fmt.Sprintf("Local folder %s exists", config.LocalFolder), fmt.Errorf("not a directory"))
+	} else {
+		check(fmt.Sprintf("Local folder %s exists", config.LocalFolder), nil)
+	}
+	if !config.SyncOnly {
+		_, err := os.Stat(filepath.Join(config.LocalFolder, "Dockerfile"))
+		check("Dockerfile found in local folder", err)
+	}
+
+	// Connection checks
+	syncManager, err := NewSyncManager(config)
+	if err == nil {
+		err = syncManager.Connect()
+	}
+	connection := "SSH/SFTP connection"
+	if config.TransferProtocol == "scp" {
+		// Connecting also tries the GNU coreutils the scp backend runs
+		connection = "SSH/scp connection and remote coreutils"
+	}
+	if check(fmt.Sprintf("%s to %s", connection, config.RemoteServer), err) {
+		defer syncManager.Close()
+		syncManager.doctorRemoteChecks(check)
+	}
+
+	// Report
+	fmt.Println()
+	failed := 0
+	for _, c := range checks {
+		if c.ok {
+			fmt.Printf("  ✅ %s\n", c.name)
+		} else {
+			failed++
+			fmt.Printf("  ❌ %s\n     %s\n", c.name, c.detail)
+		}
+	}
+	fmt.Println()
+	if failed > 0 {
+		fmt.Printf("%d of %d checks failed\n", failed, len(checks))
+		return false
+	}
+	fmt.Printf("All %d checks passed - ready to pooshit!\n", len(checks))
+	return true
+}
+
+// doctorRemoteChecks runs the checks that need a live connection
+func (sm *SyncManager) doctorRemoteChecks(check func(string, error) bool) {
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if !check(fmt.Sprintf("Remote folder %s resolves", sm.config.RemoteFolder), err) {
+		return
+	}
+
+	// Pushing creates the remote folder too, so doing it here isn't a surprise
+	if check(fmt.Sprintf("Remote folder %s exists or can be created", remotePath), sm.ensureRemoteDir(remotePath)) {
+		tempPath := fmt.Sprintf("%s/.pooshit-doctor-%d", remotePath, time.Now().UnixNano())
+		err := func() error {
+			f, err := sm.sftpClient.Create(tempPath)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write([]byte("pooshit doctor\n")); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+			return sm.sftpClient.Remove(tempPath)
+		}()
+		check("Can create and delete files in remote folder", err)
+	}
+	if sm.config.SyncOnly {
+		return
+	}
+
+	output, err := sm.executeRemoteCommandWithOutput("docker --version", false)
+	if err != nil {
+		err = fmt.Errorf("docker not found on remote: %s", strings.TrimSpace(output))
+	}
+	if !check("Docker is installed", err) {
+		return
+	}
+
+	// sudo -n fails instead of hanging if a password would be needed
+	output, err = sm.executeRemoteCommandWithOutput(sm.dockerCmdWith("sudo -n")+" info >/dev/null", false)
+	if err != nil {
+		err = fmt.Errorf("%s: %s", err, strings.TrimSpace(output))
+	}
+	check(fmt.Sprintf("User can run %q", sm.dockerCmd()), err)
+}
@@ -0,0 +1,63 @@
+package main
+
+import "errors"
+
+// ConfigError reports an invalid or unreadable configuration
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string { return e.Err.Error() }
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// ConnectionError reports a failure to connect or authenticate to a server
+type ConnectionError struct {
+	Server string
+	Err    error
+}
+
+func (e *ConnectionError) Error() string { return e.Err.Error() }
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+// SyncError reports a failure while pushing, pulling or cleaning files
+type SyncError struct {
+	Err error
+}
+
+func (e *SyncError) Error() string { return e.Err.Error() }
+func (e *SyncError) Unwrap() error { return e.Err }
+
+// DockerError reports a failure while building or running containers
+type DockerError struct {
+	Err error
+}
+
+func (e *DockerError) Error() string { return e.Err.Error() }
+func (e *DockerError) Unwrap() error { return e.Err }
+
+// wrapError wraps *err with wrap, unless it is nil or already categorised.
+// It is deferred by the public entry points, which use a named error result.
+func wrapError(err *error, wrap func(error) error) {
+	if *err != nil && errorKind(*err) == "" {
+		*err = wrap(*err)
+	}
+}
+
+// errorKind returns the category of a typed error, or "" for other errors
+func errorKind(err error) string {
+	var configErr *ConfigError
+	var connErr *ConnectionError
+	var syncErr *SyncError
+	var dockerErr *DockerError
+	switch {
+	case errors.As(err, &configErr):
+		return "config"
+	case errors.As(err, &connErr):
+		return "connection"
+	case errors.As(err, &syncErr):
+		return "sync"
+	case errors.As(err, &dockerErr):
+		return "docker"
+	}
+	return ""
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// allowedByExtension reports whether ALLOWED_EXTENSIONS lets a file be
+// synced. Entries starting with "." are extensions, such as .py or .tar.gz;
+// the others are patterns with the syntax of IGNORE, for files such as
+// Dockerfile or Makefile. Without entries every file is allowed, and in the
+// primary folder the Dockerfile the build uses and .dockerignore always are.
+func (sm *SyncManager) allowedByExtension(relPath string, info os.FileInfo, primary bool) bool {
+	if len(sm.config.AllowedExtensions) == 0 {
+		return true
+	}
+	slashed := filepath.ToSlash(relPath)
+	if primary && (slashed == path.Clean(sm.config.dockerfileName()) || slashed == ".dockerignore") {
+		return true
+	}
+
+	name := info.Name()
+	if sm.config.IgnoreCase {
+		name = strings.ToLower(name)
+	}
+	for _, entry := range sm.config.AllowedExtensions {
+		if !strings.HasPrefix(entry, ".") {
+			if matchIgnorePattern(relPath, info, entry, sm.config.IgnoreCase) {
+				return true
+			}
+			continue
+		}
+		if sm.config.IgnoreCase {
+			entry = strings.ToLower(entry)
+		}
+		if strings.HasSuffix(name, entry) {
+			return true
+		}
+	}
+	return false
+}
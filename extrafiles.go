@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// extraFile is an EXTRA_FILES entry: a local file from outside LOCAL_FOLDER
+// and where it goes, relative to REMOTE_FOLDER
+type extraFile struct {
+	Local  string
+	Remote string
+}
+
+func (f extraFile) String() string {
+	return f.Local + "=" + f.Remote
+}
+
+// parseExtraFiles parses comma-separated local=remote pairs, e.g.
+// /ci/version.json=version.json
+func parseExtraFiles(value string) ([]extraFile, error) {
+	var files []extraFile
+	for _, entry := range parsePatternList(value) {
+		local, remote, found := strings.Cut(entry, "=")
+		local, remote = strings.TrimSpace(local), strings.TrimSpace(remote)
+		if !found || local == "" || remote == "" {
+			return nil, fmt.Errorf("expected local_path=remote_path, got %q", entry)
+		}
+		remote = path.Clean(remote)
+		if path.IsAbs(remote) || remote == "." || remote == ".." || strings.HasPrefix(remote, "../") {
+			return nil, fmt.Errorf("remote path %q must be relative to REMOTE_FOLDER and stay inside it", remote)
+		}
+		files = append(files, extraFile{Local: local, Remote: remote})
+	}
+	return files, nil
+}
+
+// checkExtraFiles fails a push before it connects if an EXTRA_FILES entry
+// doesn't exist locally or isn't a regular file
+func (c *Config) checkExtraFiles() error {
+	for _, f := range c.ExtraFiles {
+		info, err := os.Stat(expandLocalHome(f.Local))
+		if err != nil {
+			return fmt.Errorf("EXTRA_FILES: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("EXTRA_FILES: %s is not a regular file", f.Local)
+		}
+	}
+	return nil
+}
+
+// uploadExtraFiles uploads the EXTRA_FILES after the main sync, skipping
+// those that are already up to date under COMPARE
+func (sm *SyncManager) uploadExtraFiles() error {
+	if len(sm.config.ExtraFiles) == 0 {
+		return nil
+	}
+	remoteRoot, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	if err := sm.checkAllowedRoot(remoteRoot); err != nil {
+		return err
+	}
+
+	log.Printf("\n📎 Uploading %d extra files...", len(sm.config.ExtraFiles))
+	for _, f := range sm.config.ExtraFiles {
+		localPath := expandLocalHome(f.Local)
+		info, err := os.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("extra file %s: %w", f.Local, err)
+		}
+		file := syncFile{
+			localPath:  localPath,
+			remotePath: path.Join(remoteRoot, f.Remote),
+			relPath:    f.Remote,
+			info:       info,
+		}
+
+		remoteInfo, err := sm.remoteStat(file.remotePath)
+		if err == nil && isSymlink(remoteInfo) {
+			log.Printf("⚠️  Not uploading extra file %s: the remote path is a symlink (SYMLINK_STAT: nofollow)", f.Remote)
+			continue
+		}
+		if err == nil && sm.upToDate(file, info, remoteInfo) {
+			sm.verbosef("Extra file %s is up to date", f.Remote)
+			continue
+		}
+		if sm.config.DryRun {
+			log.Printf("🔎 Would upload: %s -> %s (%d bytes)", f.Local, f.Remote, info.Size())
+		} else {
+			if err := sm.uploadFile(localPath, file.remotePath, sm.remoteFileMode(f.Remote, info)); err != nil {
+				return fmt.Errorf("failed to upload extra file %s: %w", f.Local, err)
+			}
+			log.Printf("   %s -> %s (%d bytes)", f.Local, f.Remote, info.Size())
+		}
+		sm.result.FilesTransferred++
+		sm.result.BytesTransferred += info.Size()
+		sm.recordChange(f.Remote)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// freshStart empties REMOTE_FOLDER before a FRESH push, so every file is
+// uploaded again and nothing left by an earlier deploy ends up in the
+// build. It has the guards of clean mode, and asks first: from a terminal
+// with a prompt, otherwise by requiring --force. Paths in OWNED_MANIFEST
+// are kept.
+func (sm *SyncManager) freshStart() error {
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	if _, err := sm.sftpClient.Stat(remotePath); os.IsNotExist(err) {
+		log.Printf("🧼 %s doesn't exist yet, so there is nothing to remove (FRESH)", remotePath)
+		return nil
+	}
+	if err := sm.checkCleanPath(remotePath); err != nil {
+		return err
+	}
+	if err := sm.loadOwnedManifest(); err != nil {
+		return err
+	}
+
+	entries, err := sm.sftpClient.ReadDir(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to list remote directory %s: %w", remotePath, err)
+	}
+	if len(entries) == 0 {
+		log.Printf("🧼 %s is already empty (FRESH)", remotePath)
+		return nil
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	sort.Strings(names)
+
+	if sm.config.DryRun {
+		log.Printf("🔎 Would remove %d entries from %s before syncing (FRESH): %s", len(names), remotePath, strings.Join(names, ", "))
+		return nil
+	}
+	if sm.quiet || sm.config.BatchMode || !stdinIsTerminal() {
+		if !sm.config.Force {
+			return fmt.Errorf("FRESH deletes the %d entries in %s, and there is no terminal to confirm that from; pass --force to go ahead", len(names), remotePath)
+		}
+	} else if !confirmDestructive(fmt.Sprintf("FRESH will permanently delete %d entries in %s:%s before syncing. Continue?", len(names), sm.config.RemoteServer, remotePath)) {
+		return fmt.Errorf("fresh push cancelled")
+	}
+
+	kept := 0
+	for _, name := range names {
+		n, err := sm.removeUnowned(remotePath, name)
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+		kept += n
+	}
+	sm.result.FreshRemoved = names
+	log.Printf("🧼 Removed %d entries from %s (FRESH): %s", len(names), remotePath, strings.Join(names, ", "))
+	if kept > 0 {
+		log.Printf("   Kept %d path(s) owned by another tool", kept)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitOutput runs git in a directory and returns its trimmed output, with
+// git's own message as the error
+func gitOutput(dir string, args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// maxDirtyFilesListed keeps the REQUIRE_CLEAN_GIT error readable in a large working tree
+const maxDirtyFilesListed = 20
+
+// checkCleanGit fails if a local folder has uncommitted changes or
+// untracked files, listing them as git status does
+func checkCleanGit(localFolder string) error {
+	status, err := gitOutput(localFolder, "status", "--porcelain", "--", ".")
+	if err != nil {
+		return fmt.Errorf("REQUIRE_CLEAN_GIT needs %s to be in a git repository: %w", localFolder, err)
+	}
+	if status == "" {
+		return nil
+	}
+	// Lines are "XY path"; trimmed, as gitOutput already trimmed the first
+	dirty := strings.Split(status, "\n")
+	for i := range dirty {
+		dirty[i] = strings.TrimSpace(dirty[i])
+	}
+	listed := dirty
+	if len(listed) > maxDirtyFilesListed {
+		listed = listed[:maxDirtyFilesListed]
+	}
+	msg := fmt.Sprintf("%s has %d uncommitted changes; commit or stash them (REQUIRE_CLEAN_GIT):\n   %s", localFolder, len(dirty), strings.Join(listed, "\n   "))
+	if len(dirty) > len(listed) {
+		msg += fmt.Sprintf("\n   ... and %d more", len(dirty)-len(listed))
+	}
+	return errors.New(msg)
+}
+
+// exportGitRef replaces LOCAL_FOLDER, and LOCAL_FOLDER_2 if set, with clean
+// exports of the FROM_GIT_REF commit, so the push doesn't pick up
+// uncommitted or untracked files. It returns a function that removes the
+// exports once the push is done.
+func (c *Config) exportGitRef() (func(), error) {
+	var dirs []string
+	cleanup := func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+
+	dir, sha, err := exportGitTree(c.LocalFolder, c.FromGitRef)
+	if err != nil {
+		return nil, err
+	}
+	dirs = append(dirs, dir)
+	log.Printf("📦 Deploying %s (%s) from git instead of the working tree in %s", c.FromGitRef, shortID(sha), c.LocalFolder)
+	c.LocalFolder, c.GitSHA = dir, sha
+
+	if c.LocalFolder2 != "" {
+		dir, sha2, err := exportGitTree(c.LocalFolder2, c.FromGitRef)
+		if err == nil && sha2 != sha {
+			os.RemoveAll(dir)
+			err = fmt.Errorf("LOCAL_FOLDER_2 is in a different git repository, where %s is %s", c.FromGitRef, shortID(sha2))
+		}
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+		c.LocalFolder2 = dir
+	}
+	return cleanup, nil
+}
+
+// exportGitTree extracts the part of a commit under localFolder into a new
+// temporary directory. Run from a subdirectory, `git archive` only includes
+// that subdirectory, with paths relative to it, and applies .gitattributes
+// export-ignore and export-subst. Every file gets the commit's time as its
+// modification time. It returns the directory and the full commit hash.
+func exportGitTree(localFolder, ref string) (string, string, error) {
+	sha, err := gitOutput(localFolder, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		if _, repoErr := gitOutput(localFolder, "rev-parse", "--git-dir"); repoErr != nil {
+			return "", "", fmt.Errorf("FROM_GIT_REF needs %s to be in a git repository: %w", localFolder, repoErr)
+		}
+		return "", "", fmt.Errorf("FROM_GIT_REF %s isn't a commit in the git repository of %s", ref, localFolder)
+	}
+	dir, err := os.MkdirTemp("", "pooshit-git-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create a directory for the git export: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", localFolder, "archive", "--format=tar", sha)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("failed to run git archive: %w", err)
+	}
+
+	extractErr := extractGitArchive(stdout, dir)
+	// Drain the rest so git isn't left blocked on a full pipe
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("git archive failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	if extractErr != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("failed to extract the git export: %w", extractErr)
+	}
+	return dir, sha, nil
+}
+
+// extractGitArchive writes the entries of a git archive into dir
+func extractGitArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		relPath := strings.TrimSuffix(hdr.Name, "/")
+		if !filepath.IsLocal(relPath) {
+			return fmt.Errorf("unexpected path %q in the archive", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(relPath))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			// Set the mode git recorded, whatever the umask
+			if err := os.Chmod(target, os.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+			if err := os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return err
+			}
+		}
+		// Other entries, such as the header with the commit hash, hold no files
+	}
+}
@@ -5,6 +5,8 @@ go 1.21
 require (
 	github.com/pkg/sftp v1.13.6
 	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.17.0
+	golang.org/x/term v0.13.0
 	github.com/joho/godotenv v1.5.1
 )
 
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// hashCacheFile is stored in each remote folder when HASH_CACHE is on
+const hashCacheFile = ".pooshit-hashes"
+
+// hashCacheHeader is the first line of a hash cache; a file without it is
+// from another version or was edited by hand, and is rebuilt
+const hashCacheHeader = "# pooshit-hashes v1 sha256"
+
+// loadHashCache reads the remote folder's hash cache, mapping relative path
+// to the SHA-256 the file had when it was last synced. A missing or stale
+// cache returns nil, so every file is compared as usual and the cache is
+// rebuilt at the end of the sync.
+func (sm *SyncManager) loadHashCache(remoteRoot string) map[string]string {
+	cachePath := path.Join(remoteRoot, hashCacheFile)
+	f, err := sm.sftpClient.Open(cachePath)
+	if os.IsNotExist(err) {
+		log.Printf("No hash cache on the remote yet; it will be built after this sync")
+		return nil
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not open hash cache %s, rebuilding it: %v", cachePath, err)
+		return nil
+	}
+	defer f.Close()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || scanner.Text() != hashCacheHeader {
+		log.Printf("⚠️  Hash cache %s is stale or unrecognized, rebuilding it", cachePath)
+		return nil
+	}
+	for scanner.Scan() {
+		// "<sha256>  <relative path>", the same layout as sha256sum
+		sum, relPath, found := strings.Cut(scanner.Text(), "  ")
+		if !found || len(sum) != sha256.Size*2 {
+			log.Printf("⚠️  Hash cache %s is corrupt, rebuilding it", cachePath)
+			return nil
+		}
+		hashes[relPath] = sum
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("⚠️  Could not read hash cache %s, rebuilding it: %v", cachePath, err)
+		return nil
+	}
+	sm.verbosef("Loaded %d hashes from %s", len(hashes), cachePath)
+	return hashes
+}
+
+// saveHashCache replaces the remote folder's hash cache. It only lists the
+// files of this sync, so entries for deleted or ignored files drop out.
+func (sm *SyncManager) saveHashCache(remoteRoot string, hashes map[string]string) error {
+	relPaths := make([]string, 0, len(hashes))
+	for relPath := range hashes {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+
+	var buf bytes.Buffer
+	buf.WriteString(hashCacheHeader + "\n")
+	for _, relPath := range relPaths {
+		fmt.Fprintf(&buf, "%s  %s\n", hashes[relPath], relPath)
+	}
+
+	cachePath := path.Join(remoteRoot, hashCacheFile)
+	f, err := sm.sftpClient.Create(cachePath)
+	if err != nil {
+		return fmt.Errorf("failed to create hash cache %s: %w", cachePath, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write hash cache %s: %w", cachePath, err)
+	}
+	return nil
+}
+
+// localSHA256 returns the SHA-256 of a local file as a hex string
+func localSHA256(localPath string) (string, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read local file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// hookCommand returns the command a hook runs, in the remote folder like the Docker build
+func hookCommand(command, remotePath string) string {
+	return fmt.Sprintf("cd %s && %s", shellQuote(remotePath), command)
+}
+
+// runHook runs one of the deploy hooks, e.g. BETWEEN_STOP_AND_BUILD_CMD, on
+// the remote and logs its output. An unset hook does nothing; a failing one
+// aborts the deploy.
+func (sm *SyncManager) runHook(key, command, remotePath string) error {
+	if command == "" {
+		return nil
+	}
+	log.Printf("🪝 Running %s: %s", key, command)
+	hookStart := time.Now()
+	output, err := sm.executeRemoteCommandWithOutput(hookCommand(command, remotePath), false)
+	sm.timePhase("hooks", hookStart)
+	if output != "" {
+		log.Printf("Output:\n%s", output)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed, aborting the deploy: %w", key, err)
+	}
+	return nil
+}
+
+// appendHook adds a hook to a dry-run command list if it is set
+func appendHook(commands []string, command, remotePath string) []string {
+	if command == "" {
+		return commands
+	}
+	return append(commands, hookCommand(command, remotePath))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certHostKeyAlgorithms ask the server for its host certificate first when
+// HOST_CA_KEY is set; without them most servers present the plain key
+var certHostKeyAlgorithms = []string{
+	ssh.CertAlgoED25519v01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+}
+
+// plainHostKeyAlgorithms follow the certificate algorithms so a pinned
+// fingerprint can still be checked on servers without a certificate
+var plainHostKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+}
+
+// normalizeFingerprint accepts a SHA256 fingerprint with or without its
+// "SHA256:" prefix and trailing base64 padding, as ssh-keygen -l prints it
+func normalizeFingerprint(fingerprint string) string {
+	return "SHA256:" + strings.TrimRight(strings.TrimPrefix(strings.TrimSpace(fingerprint), "SHA256:"), "=")
+}
+
+// loadHostCAKey reads HOST_CA_KEY, given either as a public key file or as
+// the key itself in authorized_keys format
+func loadHostCAKey(value string) (ssh.PublicKey, error) {
+	data := []byte(value)
+	if !strings.HasPrefix(value, "ssh-") && !strings.HasPrefix(value, "ecdsa-") {
+		var err error
+		data, err = os.ReadFile(expandLocalHome(value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HOST_CA_KEY: %w", err)
+		}
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse HOST_CA_KEY: %w", err)
+	}
+	return key, nil
+}
+
+// hostKeyCallback returns how the server's host key is verified: against the
+// HOST_KEY_FINGERPRINT pins, a certificate signed by HOST_CA_KEY, or either
+// when both are set. Without them any host key is accepted, as before. The
+// returned algorithms, if any, replace the client's host key preferences.
+func (sm *SyncManager) hostKeyCallback() (ssh.HostKeyCallback, []string, error) {
+	pins := make(map[string]bool)
+	for _, fingerprint := range sm.config.HostKeyFingerprints {
+		pins[normalizeFingerprint(fingerprint)] = true
+	}
+
+	checkPin := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		if !pins[fingerprint] {
+			return fmt.Errorf("host key %s of %s does not match HOST_KEY_FINGERPRINT - the server was reinstalled, or the connection is being intercepted", fingerprint, hostname)
+		}
+		sm.verbosef("Host key %s matches HOST_KEY_FINGERPRINT", fingerprint)
+		return nil
+	}
+
+	if sm.config.HostCAKey == "" {
+		if len(pins) == 0 {
+			return ssh.InsecureIgnoreHostKey(), nil, nil
+		}
+		return checkPin, nil, nil
+	}
+
+	ca, err := loadHostCAKey(sm.config.HostCAKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), ca.Marshal())
+		},
+		HostKeyFallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if len(pins) == 0 {
+				return fmt.Errorf("%s presented a plain host key, but HOST_CA_KEY requires a certificate signed by the CA", hostname)
+			}
+			return checkPin(hostname, remote, key)
+		},
+	}
+
+	algorithms := certHostKeyAlgorithms
+	if len(pins) > 0 {
+		algorithms = append(append([]string{}, certHostKeyAlgorithms...), plainHostKeyAlgorithms...)
+	}
+	return checker.CheckHostKey, algorithms, nil
+}
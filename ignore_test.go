@@ -0,0 +1,202 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeFileInfo is the os.FileInfo of a file or directory that doesn't exist
+type fakeFileInfo struct {
+	name string
+	dir  bool
+}
+
+func (fi fakeFileInfo) Name() string       { return fi.name }
+func (fi fakeFileInfo) Size() int64        { return 0 }
+func (fi fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fakeFileInfo) IsDir() bool        { return fi.dir }
+func (fi fakeFileInfo) Sys() interface{}   { return nil }
+func (fi fakeFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func fileInfo(relPath string) os.FileInfo { return fakeFileInfo{name: path.Base(relPath)} }
+
+func TestShouldIgnoreNegation(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		relPath  string
+		want     bool
+	}{
+		{patterns: []string{"*.log"}, relPath: "app.log", want: true},
+		{patterns: []string{"*.log", "!keep.log"}, relPath: "keep.log", want: false},
+		{patterns: []string{"*.log", "!keep.log"}, relPath: "app.log", want: true},
+		// The last matching pattern wins, so a "!" before the pattern it
+		// should override has no effect
+		{patterns: []string{"!keep.log", "*.log"}, relPath: "keep.log", want: true},
+		{patterns: []string{"*.log", "!keep.log", "keep.*"}, relPath: "keep.log", want: true},
+		{patterns: []string{"*.log", "!*.log", "*.log", "!keep.log"}, relPath: "keep.log", want: false},
+		// A "!" alone only re-includes what was excluded before
+		{patterns: []string{"!keep.log"}, relPath: "keep.log", want: false},
+		{patterns: []string{"*.log", "!keep.log"}, relPath: "logs/keep.log", want: false},
+		// pooshit's own files stay ignored whatever the patterns say
+		{patterns: []string{"!" + manifestFile}, relPath: manifestFile, want: true},
+	}
+	sm := &SyncManager{config: &Config{}}
+	for _, tt := range tests {
+		if got := sm.shouldIgnore(tt.relPath, fileInfo(tt.relPath), tt.patterns); got != tt.want {
+			t.Errorf("shouldIgnore(%q, %q) = %v, want %v", tt.relPath, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestShouldIgnoreHiddenNegation(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		relPath  string
+		want     bool
+	}{
+		{relPath: ".env", want: true},
+		{relPath: ".github/workflows/ci.yml", want: true},
+		{patterns: []string{"!.github"}, relPath: ".github/workflows/ci.yml", want: false},
+		{patterns: []string{"!.github", ".github/workflows/"}, relPath: ".github/workflows/ci.yml", want: true},
+		{patterns: []string{"*.go"}, relPath: "main.go", want: true},
+		{relPath: "main.go", want: false},
+	}
+	sm := &SyncManager{config: &Config{IgnoreHidden: true}}
+	for _, tt := range tests {
+		if got := sm.shouldIgnore(tt.relPath, fileInfo(tt.relPath), tt.patterns); got != tt.want {
+			t.Errorf("shouldIgnore(%q, %q) with IGNORE_HIDDEN = %v, want %v", tt.relPath, tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestMatchIgnorePattern(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		relPath    string
+		dir        bool
+		ignoreCase bool
+		want       bool
+	}{
+		// A leading "/" is dropped, so /dist is the same as dist
+		{pattern: "/dist", relPath: "dist", dir: true, want: true},
+		{pattern: "/dist", relPath: "dist/app.js", want: true},
+		{pattern: "./dist", relPath: "dist/app.js", want: true},
+		{pattern: "/dist", relPath: "web/dist/app.js", want: true},
+		{pattern: "/*.log", relPath: "app.log", want: true},
+
+		// A "/" in the middle matches the path from the root
+		{pattern: "src/*.test.js", relPath: "src/app.test.js", want: true},
+		{pattern: "src/*.test.js", relPath: "src/lib/app.test.js", want: false},
+		{pattern: "src/*.test.js", relPath: "lib/src/app.test.js", want: false},
+		{pattern: "docs/drafts", relPath: "docs/drafts", dir: true, want: true},
+		{pattern: "docs/drafts", relPath: "docs/drafts/intro.md", want: true},
+		{pattern: "docs/drafts", relPath: "old/docs/drafts/intro.md", want: false},
+
+		// A trailing "/" matches directories only, and what is in them
+		{pattern: "assets/raw/", relPath: "assets/raw", dir: true, want: true},
+		{pattern: "assets/raw/", relPath: "assets/raw", want: false},
+		{pattern: "assets/raw/", relPath: "assets/raw/photo.png", want: true},
+		{pattern: "build/", relPath: "build", dir: true, want: true},
+		{pattern: "build/", relPath: "src/build/out.o", want: true},
+
+		// "**" matches any number of directories, including none
+		{pattern: "src/**/*.test.js", relPath: "src/app.test.js", want: true},
+		{pattern: "src/**/*.test.js", relPath: "src/lib/app.test.js", want: true},
+		{pattern: "src/**/*.test.js", relPath: "src/lib/util/app.test.js", want: true},
+		{pattern: "src/**/*.test.js", relPath: "test/app.test.js", want: false},
+		{pattern: "**/fixtures/", relPath: "fixtures", dir: true, want: true},
+		{pattern: "**/fixtures/", relPath: "a/b/fixtures/data.json", want: true},
+		{pattern: "**/fixtures/", relPath: "a/b/fixtures", want: false},
+		{pattern: "**/*.log", relPath: "logs/2024/app.log", want: true},
+		{pattern: "logs/**", relPath: "logs/2024/app.log", want: true},
+		{pattern: "logs/**", relPath: "src/logs/app.log", want: false},
+
+		// Names without a "/" match any segment, wildcards the base name
+		{pattern: "node_modules", relPath: "web/node_modules/react/index.js", want: true},
+		{pattern: "*.log", relPath: "logs/app.log", want: true},
+		{pattern: "*.log", relPath: "app.log.gz", want: false},
+
+		// IGNORE_CASE
+		{pattern: "*.LOG", relPath: "app.log", want: false},
+		{pattern: "*.LOG", relPath: "app.log", ignoreCase: true, want: true},
+		{pattern: "Docs/Drafts", relPath: "docs/drafts/intro.md", ignoreCase: true, want: true},
+		{pattern: "SRC/**/*.Test.js", relPath: "src/Lib/App.test.JS", ignoreCase: true, want: true},
+		{pattern: "Build/", relPath: "build", dir: true, want: false},
+		{pattern: "Build/", relPath: "build", dir: true, ignoreCase: true, want: true},
+	}
+	for _, tt := range tests {
+		info := fileInfo(tt.relPath)
+		if tt.dir {
+			info = fakeFileInfo{name: path.Base(tt.relPath), dir: true}
+		}
+		if got := matchIgnorePattern(tt.relPath, info, tt.pattern, tt.ignoreCase); got != tt.want {
+			t.Errorf("matchIgnorePattern(%q, dir=%v, %q, ignoreCase=%v) = %v, want %v", tt.relPath, tt.dir, tt.pattern, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestIsPartialDownload(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.bin", "app.bin.part", "orphan.part", "notes.part.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "app.bin.d.part"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "app.bin.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "app.bin.part", want: true},
+		{name: "app.bin", want: false},
+		// Without the file it would replace, it is the project's own
+		{name: "orphan.part", want: false},
+		{name: "notes.part.txt", want: false},
+		{name: "app.bin.d.part", want: false},
+	}
+	for _, tt := range tests {
+		localPath := filepath.Join(dir, tt.name)
+		info, err := os.Stat(localPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isPartialDownload(localPath, info); got != tt.want {
+			t.Errorf("isPartialDownload(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanSkipsPartialDownloads(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"app.bin", "app.bin.part", "orphan.part"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sm := &SyncManager{config: &Config{}}
+	files, err := sm.scanLocalFolder(dir, dir, "/srv/app", false, nil, &FolderResult{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range files {
+		got = append(got, filepath.ToSlash(file.relPath))
+	}
+	if want := []string{"app.bin", "orphan.part"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("scanned %q, want %q", got, want)
+	}
+}
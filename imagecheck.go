@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imageCheck is what VERIFY_IMAGE notes before a build: the image the tag
+// pointed to, if any, and the remote clock
+type imageCheck struct {
+	previousID string
+	start      time.Time
+}
+
+// imageInspectCommand prints the image's ID and creation time, and fails if there is no such image
+func (sm *SyncManager) imageInspectCommand() string {
+	return fmt.Sprintf("%s image inspect -f '{{.Id}} {{.Created}}' %s", sm.dockerCmd(), sm.config.DockerImageName)
+}
+
+// startImageCheck notes the state before a build for verifyBuiltImage. It
+// must run before the old image is removed. Without VERIFY_IMAGE it returns nil.
+func (sm *SyncManager) startImageCheck() (*imageCheck, error) {
+	if !sm.config.VerifyImage {
+		return nil, nil
+	}
+	// The remote clock is the one docker stamps the image with
+	output, err := sm.executeRemoteCommandWithOutput("date +%s", false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the remote clock for VERIFY_IMAGE: %w", err)
+	}
+	seconds, err := strconv.ParseInt(strings.TrimSpace(output), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the remote clock for VERIFY_IMAGE: unexpected output %q", strings.TrimSpace(output))
+	}
+	check := &imageCheck{start: time.Unix(seconds, 0)}
+	if output, err := sm.executeRemoteCommandWithOutput(sm.imageInspectCommand()+" 2>/dev/null", false); err == nil {
+		check.previousID, _, _ = strings.Cut(strings.TrimSpace(output), " ")
+	}
+	return check, nil
+}
+
+// verifyBuiltImage fails unless the build left DOCKER_IMAGE_NAME pointing at
+// an image created during this run. A build served entirely from cache gives
+// back the image that was already there, with its old creation time; that is
+// accepted. An older image that wasn't there before, e.g. a base image a
+// Dockerfile only pulls and tags, is not.
+func (sm *SyncManager) verifyBuiltImage(check *imageCheck) error {
+	if check == nil {
+		return nil
+	}
+	image := sm.config.DockerImageName
+	output, err := sm.executeRemoteCommandWithOutput(sm.imageInspectCommand(), false)
+	if err != nil {
+		return fmt.Errorf("the build finished but there is no image %s: %w", image, err)
+	}
+	id, createdText, _ := strings.Cut(strings.TrimSpace(output), " ")
+	created, err := time.Parse(time.RFC3339Nano, createdText)
+	if err != nil {
+		return fmt.Errorf("failed to read the creation time of %s from %q: %w", image, createdText, err)
+	}
+
+	switch {
+	case !created.Before(check.start):
+		log.Printf("✅ Image %s (%s) was created by this build", image, shortID(id))
+	case id == check.previousID:
+		log.Printf("✅ Image %s (%s) is unchanged; the build was served from cache", image, shortID(id))
+	default:
+		return fmt.Errorf("image %s (%s) was created %s, before this build started, and isn't the image deployed before it; check that the Dockerfile builds %s rather than only pulling or tagging another image",
+			image, shortID(id), created.Local().Format(time.RFC3339), image)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// managedTagPrefix marks the image tags pooshit creates, and may prune, with KEEP_IMAGES
+const managedTagPrefix = "pooshit-"
+
+// imageRepository strips the tag from DOCKER_IMAGE_NAME, leaving any registry port alone
+func imageRepository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// tagBuildCommand returns the command that gives the new build its own
+// pooshit-managed tag, so it survives the next build taking over the name
+func (sm *SyncManager) tagBuildCommand(tag string) string {
+	return fmt.Sprintf("%s tag %s %s:%s", sm.dockerCmd(), sm.config.DockerImageName, imageRepository(sm.config.DockerImageName), tag)
+}
+
+// tagBuild tags the image that was just built for KEEP_IMAGES
+func (sm *SyncManager) tagBuild() {
+	if sm.config.KeepImages == 0 {
+		return
+	}
+	tag := managedTagPrefix + time.Now().UTC().Format("20060102-150405")
+	if err := sm.executeRemoteCommandQuiet(sm.tagBuildCommand(tag)); err != nil {
+		log.Printf("⚠️  Could not tag the new image as %s: %v", tag, err)
+		return
+	}
+	log.Printf("🏷️  Tagged the new image as %s:%s", imageRepository(sm.config.DockerImageName), tag)
+}
+
+// managedImage is one pooshit-managed tag of the image
+type managedImage struct {
+	tag     string
+	id      string
+	created time.Time
+}
+
+// pruneImages removes all but the KEEP_IMAGES most recent pooshit-managed
+// tags, by creation time. Tags of an image a container is running from are
+// always kept. Failures are only logged, as the deploy itself succeeded.
+func (sm *SyncManager) pruneImages() {
+	if sm.config.KeepImages == 0 {
+		return
+	}
+	docker := sm.dockerCmd()
+	repo := imageRepository(sm.config.DockerImageName)
+
+	output, err := sm.executeRemoteCommandWithOutput(fmt.Sprintf("%s images --format '{{.Tag}}' %s", docker, repo), false)
+	if err != nil {
+		log.Printf("⚠️  Could not list image tags to prune: %s", strings.TrimSpace(output))
+		return
+	}
+	var tags, refs []string
+	for _, tag := range strings.Fields(output) {
+		if strings.HasPrefix(tag, managedTagPrefix) {
+			tags = append(tags, tag)
+			refs = append(refs, shellQuote(repo+":"+tag))
+		}
+	}
+	if len(tags) <= sm.config.KeepImages {
+		return
+	}
+
+	output, err = sm.executeRemoteCommandWithOutput(fmt.Sprintf("%s image inspect --format '{{.Id}} {{.Created}}' %s", docker, strings.Join(refs, " ")), false)
+	if err != nil {
+		log.Printf("⚠️  Could not inspect image tags to prune: %s", strings.TrimSpace(output))
+		return
+	}
+	var images []managedImage
+	for i, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(line)
+		if i >= len(tags) || len(fields) != 2 {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339Nano, fields[1])
+		if err != nil {
+			continue
+		}
+		images = append(images, managedImage{tag: tags[i], id: fields[0], created: created})
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].created.After(images[j].created) })
+
+	// Image IDs of every container, so a rollback target in use is never untagged
+	output, _ = sm.executeRemoteCommandWithOutput(fmt.Sprintf("%s ps -aq | xargs -r %s inspect --format '{{.Image}}'", docker, docker), false)
+	inUse := make(map[string]bool)
+	for _, id := range strings.Fields(output) {
+		inUse[id] = true
+	}
+
+	for i, image := range images {
+		if i < sm.config.KeepImages {
+			continue
+		}
+		if inUse[image.id] {
+			log.Printf("📌 Keeping %s:%s: a container uses it", repo, image.tag)
+			continue
+		}
+		if err := sm.executeRemoteCommandQuiet(fmt.Sprintf("%s rmi %s", docker, shellQuote(repo+":"+image.tag))); err != nil {
+			log.Printf("⚠️  Could not remove %s:%s: %v", repo, image.tag, err)
+			continue
+		}
+		sm.result.PrunedImages = append(sm.result.PrunedImages, repo+":"+image.tag)
+	}
+	if len(sm.result.PrunedImages) > 0 {
+		log.Printf("🧽 Pruned %d old image tag(s), keeping the %d most recent: %s",
+			len(sm.result.PrunedImages), sm.config.KeepImages, strings.Join(sm.result.PrunedImages, ", "))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// binarySniffSize is how much of a file is checked for NUL bytes to tell
+// binary files from text, as git does
+const binarySniffSize = 8000
+
+// parseLineEndings validates a LINE_ENDINGS value
+func parseLineEndings(value string) (string, error) {
+	switch mode := strings.ToLower(value); mode {
+	case "", "keep":
+		return "keep", nil
+	case "lf":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("expected keep or lf, got %q", value)
+	}
+}
+
+// convertLineEndings applies LINE_ENDINGS: lf to the scanned files. Text
+// files matching LINE_ENDINGS_FILES that contain CRLF are converted into a
+// temporary copy with the same mode and modification time, and the file is
+// pointed at that copy, so comparing, uploading and verifying all see the
+// converted content. Binary files are left alone. The returned cleanup
+// removes the copies.
+func (sm *SyncManager) convertLineEndings(files []syncFile, result *FolderResult) (func(), error) {
+	cleanup := func() {}
+	if sm.config.LineEndings != "lf" {
+		return cleanup, nil
+	}
+
+	tempDir := ""
+	for i, file := range files {
+		if len(sm.config.LineEndingsPatterns) > 0 && !matchPatternList(file.relPath, file.info, sm.config.LineEndingsPatterns, sm.config.IgnoreCase) {
+			continue
+		}
+		data, err := readTextFile(file.localPath)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		if !bytes.Contains(data, []byte("\r\n")) {
+			continue
+		}
+
+		if tempDir == "" {
+			if tempDir, err = os.MkdirTemp("", "pooshit-lf-"); err != nil {
+				return nil, fmt.Errorf("failed to create a directory for converted files: %w", err)
+			}
+			dir := tempDir
+			cleanup = func() { os.RemoveAll(dir) }
+		}
+		converted := filepath.Join(tempDir, fmt.Sprintf("%d", i))
+		if err := os.WriteFile(converted, bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), file.info.Mode().Perm()); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to convert line endings of %s: %w", file.relPath, err)
+		}
+		os.Chmod(converted, file.info.Mode().Perm())
+		os.Chtimes(converted, file.info.ModTime(), file.info.ModTime())
+		info, err := os.Stat(converted)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to convert line endings of %s: %w", file.relPath, err)
+		}
+
+		sm.verbosef("Converting %s to LF line endings", file.relPath)
+		files[i].localPath = converted
+		files[i].info = info
+		result.LineEndingsConverted++
+	}
+	return cleanup, nil
+}
+
+// readTextFile returns the contents of a text file, or nil for a binary one:
+// a file with a NUL byte in its first binarySniffSize bytes
+func readTextFile(localPath string) ([]byte, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local file: %w", err)
+	}
+	defer f.Close()
+
+	head := make([]byte, binarySniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read local file: %w", err)
+	}
+	if bytes.IndexByte(head[:n], 0) >= 0 {
+		return nil, nil
+	}
+	rest, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local file: %w", err)
+	}
+	return append(head[:n], rest...), nil
+}
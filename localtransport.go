@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// localServerName stands in for REMOTE_SERVER with TRANSPORT: local
+const localServerName = "localhost"
+
+// parseTransport validates a TRANSPORT value
+func parseTransport(value string) (string, error) {
+	switch transport := strings.ToLower(value); transport {
+	case "", "ssh":
+		return "ssh", nil
+	case "local":
+		return transport, nil
+	default:
+		return "", fmt.Errorf("expected ssh or local, got %q", value)
+	}
+}
+
+// pipeConn joins the two pipes between the SFTP client and the in-process server
+type pipeConn struct {
+	io.Reader
+	io.WriteCloser
+	reader io.Closer
+}
+
+func (c pipeConn) Close() error {
+	c.reader.Close()
+	return c.WriteCloser.Close()
+}
+
+// connectLocal treats this machine as the remote for TRANSPORT: local. An
+// SFTP server runs in-process on the local filesystem, so every file
+// operation goes through the same SFTP client as over SSH, and commands run
+// in a local shell.
+func (sm *SyncManager) connectLocal() error {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(pipeConn{serverReader, serverWriter, serverReader})
+	if err != nil {
+		return fmt.Errorf("failed to start local SFTP server: %w", err)
+	}
+	go func() {
+		if err := server.Serve(); err != nil && err != io.EOF {
+			sm.verbosef("Local SFTP server stopped: %v", err)
+		}
+		server.Close()
+	}()
+
+	sm.acquireSession()
+	sftpClient, err := sftp.NewClientPipe(clientReader, clientWriter, sm.sftpOptions()...)
+	if err != nil {
+		sm.releaseSession()
+		clientWriter.Close()
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	sm.sftpClient = sftpClient
+	log.Printf("\n✅ Using the local filesystem as the remote (TRANSPORT: local)")
+	return nil
+}
+
+// localSession runs one command in a local shell. It mirrors the parts of
+// ssh.Session that pooshit uses.
+type localSession struct {
+	cmd *exec.Cmd
+}
+
+func newLocalSession() *localSession {
+	return &localSession{cmd: exec.Command("sh", "-c")}
+}
+
+// command sets the shell command to run
+func (s *localSession) command(cmd string) *exec.Cmd {
+	s.cmd.Args = append(s.cmd.Args, cmd)
+	return s.cmd
+}
+
+func (s *localSession) Output(cmd string) ([]byte, error) {
+	return s.command(cmd).Output()
+}
+
+func (s *localSession) CombinedOutput(cmd string) ([]byte, error) {
+	return s.command(cmd).CombinedOutput()
+}
+
+func (s *localSession) StdoutPipe() (io.Reader, error) {
+	return s.cmd.StdoutPipe()
+}
+
+func (s *localSession) StderrPipe() (io.Reader, error) {
+	return s.cmd.StderrPipe()
+}
+
+func (s *localSession) StdinPipe() (io.WriteCloser, error) {
+	return s.cmd.StdinPipe()
+}
+
+func (s *localSession) Start(cmd string) error {
+	return s.command(cmd).Start()
+}
+
+func (s *localSession) Wait() error {
+	return s.cmd.Wait()
+}
+
+// Signal forwards an interrupt to the command
+func (s *localSession) Signal(sig ssh.Signal) error {
+	if s.cmd.Process == nil {
+		return fmt.Errorf("command not started")
+	}
+	if sig == ssh.SIGINT {
+		return s.cmd.Process.Signal(os.Interrupt)
+	}
+	return s.cmd.Process.Kill()
+}
+
+// Close stops the command if it is still running, like closing an SSH session
+func (s *localSession) Close() error {
+	if s.cmd.Process != nil && s.cmd.ProcessState == nil {
+		s.cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+// isLockedFile reports whether opening a local file failed because another
+// program holds it. Other systems don't lock files against reading.
+func isLockedFile(err error) bool {
+	return false
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// The Windows errors for a file another process opened without sharing it,
+// or holds a byte range lock on; the syscall package doesn't name them
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isLockedFile reports whether opening a local file failed because another
+// program holds it, such as an editor or a running build
+func isLockedFile(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -2,16 +2,26 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
 )
 
 // Config holds the application configuration
@@ -19,12 +29,219 @@ type Config struct {
 	RemoteServer     string
 	SSHUsername      string
 	SSHPassword      string
+	SSHKeyFile       string
 	RemoteFolder     string
 	LocalFolder      string
 	DockerImageName  string
 	DockerBuildArgs  string
 	DockerRunArgs    string
 	IgnorePatterns   []string
+	NoDefaultIgnores bool
+	RemoveOldImage   bool
+	LocalFolder2     string
+	RemoteFolder2    string
+	IgnorePatterns2  []string
+	SummaryFile      string
+	ManifestFile     string
+	SSHCompression   bool
+	Since            time.Time
+	MaxDepth         int
+	MaxFiles         int
+	MaxSSHSessions   int
+	MaxConcurrentSessions int
+	// SharedSessions holds a slot for every session open on any target, up
+	// to MAX_CONCURRENT_SESSIONS; nil without a limit
+	SharedSessions   chan struct{}
+	Delta            bool
+	DeltaMinSize     int64
+	DockerSudo       bool
+	MaxParallelTargets int
+	ContinueOnDockerError bool
+	DeployStateFile  string
+	Resume           bool
+	Restart          bool
+	ParallelDownloads int
+	SSHControlPath   string
+	Proxy            string
+	ZeroDowntime     bool
+	HealthCheckTimeout time.Duration
+	SymlinkEscape    string
+	SymlinkStat      string
+	ExecutablePatterns []string
+	PullExecutablePatterns []string
+	PermissionsFile  string
+	PermissionRules  []permissionRule
+	DefaultFileMode  os.FileMode
+	DefaultDirMode   os.FileMode
+	LineEndings      string
+	LineEndingsPatterns []string
+	SSHCiphers       []string
+	SSHKeyExchanges  []string
+	SSHMACs          []string
+	ShowBanner       bool
+	DryRun           bool
+	AlwaysUploadPatterns []string
+	VerifyAfter      string
+	AuthOrder        []string
+	Verbose          bool
+	StrictEnv        bool
+	NoDockerfileCheck bool
+	ConcurrencyPerFile int
+	OwnedManifest    string
+	Compare          string
+	HashCache        bool
+	BuildFromTar     bool
+	StagingDir       string
+	OnlyDocker       bool
+	RemoteRoot       string
+	SyncOnly         bool
+	KeepImages       int
+	AuditCommands    bool
+	CommandAllowlist []string
+	CopyBufferSize   int64
+	StallWarning     time.Duration
+	DevPollInterval  time.Duration
+	DevDebounce      time.Duration
+	IdleTimeout      time.Duration
+	HostKeyFingerprints []string
+	HostCAKey        string
+	RemoteCmdPrefix  string
+	BatchMode        bool
+	PutMode          os.FileMode
+	RunRetries       int
+	MtimeTolerance   time.Duration
+	UploadNewerOnly  bool
+	Fresh            bool
+	SkipLocked       bool
+	UpdateSymlink    string
+	StrictDockerfile bool
+	Scaffold         bool
+	IgnoreHidden     bool
+	AllowedExtensions []string
+	IgnoreCase       bool
+	BackupPatterns   []string
+	BackupKeep       int
+	DockerSSHUser    string
+	ExtraFiles       []extraFile
+	AllowedRemoteRoot string
+	ShowDiff         bool
+	PickFiles        bool
+	ShowDiffMaxSize  int64
+	ConfirmUploadSize int64
+	Transport        string
+	TransferProtocol string
+	BeforeStopCmd    string
+	CleanupCmd       string
+	BetweenStopAndBuildCmd string
+	BeforeRunCmd     string
+	AfterRunCmd      string
+	VerifyImage      bool
+	PermsSidecar     bool
+	BuildLog         string
+	FromGitRef       string
+	RequireCleanGit  bool
+	Force            bool
+	ContainerIDFormat string
+	ContainerIDFile  string
+	
+	// GitSHA is the commit FROM_GIT_REF resolved to, set when it is exported
+	GitSHA           string
+	
+	// MakePlan and ApplyPlan are set by plan and apply mode, not the config file
+	MakePlan         bool
+	ApplyPlan        *Plan
+}
+
+// concurrentTransferThreshold is the smallest file CONCURRENCY_PER_FILE
+// uploads in parallel chunks; below it the extra requests aren't worth it
+const concurrentTransferThreshold = 4 << 20
+
+// defaultSFTPPacket is the SFTP payload size every server must accept
+const defaultSFTPPacket = 32 << 10
+
+// maxTunedSFTPPacket keeps COPY_BUFFER_SIZE packets under OpenSSH's 256 KiB
+// message limit, leaving room for the packet header
+const maxTunedSFTPPacket = 255 << 10
+
+// defaultMaxSSHSessions stays below OpenSSH's default MaxSessions of 10
+const defaultMaxSSHSessions = 8
+
+// defaultMaxFiles is far more than a deployable project has, and far less
+// than a home directory or a filesystem root
+const defaultMaxFiles = 100000
+
+// remoteUserName is what DOCKER_SSH_USER accepts, so it can go into a command unquoted
+var remoteUserName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// defaultIgnorePatterns are used when no IGNORE option is specified
+var defaultIgnorePatterns = []string{".git", ".gitignore", ".env", "*.swp", "*.tmp"}
+
+// FolderResult summarizes the synchronization of one local/remote folder pair
+type FolderResult struct {
+	LocalFolder  string `json:"local_folder"`
+	RemoteFolder string `json:"remote_folder"`
+	Checked      int    `json:"checked"`
+	Transferred  int    `json:"transferred"`
+	Skipped      int    `json:"skipped"`
+	Ignored      int    `json:"ignored"`
+	NotModified  int    `json:"not_modified_since,omitempty"`
+	NotAllowed   int    `json:"excluded_by_extension,omitempty"`
+	Bytes        int64  `json:"bytes"`
+	DeltaFiles   int    `json:"delta_files,omitempty"`
+	DeltaSaved   int64  `json:"delta_bytes_saved,omitempty"`
+	SymlinkEscapes int  `json:"symlink_escapes,omitempty"`
+	SymlinksSkipped int `json:"symlinks_skipped,omitempty"`
+	AlwaysUploaded int  `json:"always_uploaded,omitempty"`
+	Verified     int      `json:"verified,omitempty"`
+	Mismatches   []string `json:"verify_mismatches,omitempty"`
+	Resumed      int      `json:"resumed,omitempty"`
+	Owned        int      `json:"owned,omitempty"`
+	HashCacheHits int     `json:"hash_cache_hits,omitempty"`
+	Failed       []string `json:"failed,omitempty"`
+	BackedUp     int      `json:"backed_up,omitempty"`
+	Declined     []string `json:"declined,omitempty"`
+	Unpicked     []string `json:"unpicked,omitempty"`
+	Conflicts    []string `json:"conflicts,omitempty"`
+	Locked       []string `json:"skipped_locked,omitempty"`
+	LineEndingsConverted int `json:"line_endings_converted,omitempty"`
+}
+
+// syncFile is a file found while scanning, with its paths on both sides
+type syncFile struct {
+	localPath  string
+	remotePath string
+	relPath    string
+	info       os.FileInfo
+}
+
+// SyncResult collects what happened during a run, for logging and the summary file
+type SyncResult struct {
+	Target           string          `json:"target"`
+	Mode             string          `json:"mode"`
+	DryRun           bool            `json:"dry_run,omitempty"`
+	Timestamp        time.Time       `json:"timestamp"`
+	Duration         string          `json:"duration"`
+	Folders          []*FolderResult `json:"folders,omitempty"`
+	FilesTransferred int             `json:"files_transferred"`
+	BytesTransferred int64           `json:"bytes_transferred"`
+	ImageTag         string          `json:"image_tag,omitempty"`
+	ContainerID      string          `json:"container_id,omitempty"`
+	ContainerName    string          `json:"container_name,omitempty"`
+	Result           string          `json:"result"`
+	Error            string          `json:"error,omitempty"`
+	ErrorKind        string          `json:"error_kind,omitempty"`
+	Timings          map[string]string `json:"timings,omitempty"`
+	PrunedImages     []string        `json:"pruned_images,omitempty"`
+	FreshRemoved     []string        `json:"fresh_removed,omitempty"`
+	RunRetries       int             `json:"run_retries,omitempty"`
+	DirsCreated      int             `json:"dirs_created,omitempty"`
+	Manifest         string          `json:"manifest,omitempty"`
+	BuildLog         string          `json:"build_log,omitempty"`
+	GitSHA           string          `json:"git_sha,omitempty"`
+	ChangedByType    map[string]int  `json:"changed_by_type,omitempty"`
+	
+	// plan collects what a push would do in plan mode
+	plan             *TargetPlan
 }
 
 // SyncManager handles the synchronization and Docker operations
@@ -32,39 +249,131 @@ type SyncManager struct {
 	config     *Config
 	sshClient  *ssh.Client
 	sftpClient *sftp.Client
+	result     *SyncResult
+	
+	// controlSocket is set when commands and SFTP run through an OpenSSH ControlMaster
+	// socket instead of sshClient; controlSession carries the SFTP subsystem
+	controlSocket  string
+	controlSession remoteSession
+	
+	// authUsed names the auth method last attempted, which is the one that
+	// succeeded once the connection is up
+	authUsed string
+	
+	// quiet hides progress bars, which would garble each other when targets run in parallel
+	quiet bool
+	
+	// ownedPaths are the OWNED_MANIFEST entries, relative to REMOTE_FOLDER
+	ownedPaths []string
+	
+	// contextTarball is the remote build context uploaded with BUILD_FROM_TAR
+	contextTarball string
+	
+	// homeDir caches the remote home directory once it has been looked up
+	homeDir string
+	
+	// phases accumulates how long each phase of the run took
+	phases map[string]time.Duration
+	
+	// sessionSlots bounds the number of concurrently open SSH sessions
+	// (including the one used by the SFTP client) to MAX_SSH_SESSIONS
+	sessionSlots chan struct{}
 }
 
-// ProgressBar represents a simple progress bar
+// ProgressBar represents a simple progress bar. It is safe to update from
+// several goroutines: updates only change its state, and a single render
+// goroutine draws the latest state, so output never interleaves.
 type ProgressBar struct {
-	total   int
-	current int
-	width   int
-	lastMsg string
+	total  int
+	width  int
+	hidden bool
+	
+	mu       sync.Mutex
+	current  int
+	lastMsg  string
+	complete bool
+	
+	// redraw wakes the render goroutine; several updates in a row are drawn once.
+	// done is closed after the final draw.
+	redraw chan struct{}
+	done   chan struct{}
+}
+
+// newProgressBar creates a progress bar for this manager, hidden in quiet mode
+func (sm *SyncManager) newProgressBar(total int) *ProgressBar {
+	return startProgressBar(total, sm.quiet || sm.config.DryRun)
 }
 
 // NewProgressBar creates a new progress bar
 func NewProgressBar(total int) *ProgressBar {
-	return &ProgressBar{
-		total:   total,
-		current: 0,
-		width:   50,
-	}
+	return startProgressBar(total, false)
 }
 
-// Update updates the progress bar
+// startProgressBar creates a progress bar and starts its render goroutine
+func startProgressBar(total int, hidden bool) *ProgressBar {
+	p := &ProgressBar{
+		total:  total,
+		width:  50,
+		hidden: hidden,
+		redraw: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go p.render()
+	return p
+}
+
+// Update sets the progress to current and shows message
 func (p *ProgressBar) Update(current int, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.complete {
+		return
+	}
 	p.current = current
 	p.lastMsg = message
-	p.Draw()
+	p.wake()
+}
+
+// Increment counts one more item done and shows message, for workers that
+// don't know how far the others have got
+func (p *ProgressBar) Increment(message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.complete {
+		return
+	}
+	p.current++
+	p.lastMsg = message
+	p.wake()
+}
+
+// wake asks the render goroutine to draw; p.mu must be held
+func (p *ProgressBar) wake() {
+	select {
+	case p.redraw <- struct{}{}:
+	default:
+		// A redraw is already pending and will pick up this state
+	}
 }
 
-// Draw draws the progress bar
-func (p *ProgressBar) Draw() {
-	if p.total == 0 {
+// render draws the latest state whenever it changes, and the final state once Complete closes redraw
+func (p *ProgressBar) render() {
+	defer close(p.done)
+	for range p.redraw {
+		p.mu.Lock()
+		current, message := p.current, p.lastMsg
+		p.mu.Unlock()
+		p.draw(current, message)
+	}
+}
+
+// draw draws the progress bar
+func (p *ProgressBar) draw(current int, message string) {
+	if p.total == 0 || p.hidden {
 		return
 	}
 	
-	percent := float64(p.current) / float64(p.total)
+	percent := float64(current) / float64(p.total)
 	filledWidth := int(percent * float64(p.width))
 	
 	// Clear the line
@@ -81,277 +390,1829 @@ func (p *ProgressBar) Draw() {
 			fmt.Print(" ")
 		}
 	}
-	fmt.Printf("] %3d%% (%d/%d)\n", int(percent*100), p.current, p.total)
+	fmt.Printf("] %3d%% (%d/%d)\n", int(percent*100), current, p.total)
 	
 	// Show current operation on the next line
-	if p.lastMsg != "" {
-		fmt.Printf("\r\033[K%s", p.lastMsg)
+	if message != "" {
+		fmt.Printf("\r\033[K%s", message)
 	}
 	
 	// Move cursor up one line for next update
-	if p.current < p.total {
+	if current < p.total {
 		fmt.Print("\033[1A")
 	}
 }
 
-// Complete marks the progress as complete
+// Complete marks the progress as complete and returns once the final state
+// is drawn. Later updates are ignored, and calling it again does nothing.
 func (p *ProgressBar) Complete() {
+	p.mu.Lock()
+	if p.complete {
+		p.mu.Unlock()
+		<-p.done
+		return
+	}
+	p.complete = true
 	p.current = p.total
-	p.Draw()
-	fmt.Println() // Add extra newline after completion
+	p.wake()
+	close(p.redraw)
+	p.mu.Unlock()
+	
+	<-p.done
+	if !p.hidden {
+		fmt.Println() // Add extra newline after completion
+	}
 }
 
+// stdinReader is shared by all interactive prompts so buffered input isn't lost between them
+var stdinReader = bufio.NewReader(os.Stdin)
+
 // confirmAction prompts the user for a yes/no confirmation
 func confirmAction(prompt string) bool {
 	fmt.Printf("%s (Y/n): ", prompt)
-	var response string
-	fmt.Scanln(&response)
+	response, _ := stdinReader.ReadString('\n')
 	response = strings.ToLower(strings.TrimSpace(response))
 	return response == "" || response == "y" || response == "yes"
 }
 
-// LoadConfig loads configuration from a file
-func LoadConfig(filename string) (*Config, error) {
-	file, err := os.Open(filename)
+// confirmDestructive asks for confirmation of an irreversible action; unlike confirmAction it defaults to no
+func confirmDestructive(prompt string) bool {
+	fmt.Printf("%s (y/N): ", prompt)
+	response, _ := stdinReader.ReadString('\n')
+	response = strings.ToLower(strings.TrimSpace(response))
+	return response == "y" || response == "yes"
+}
+
+// promptInput prompts the user for a line of input, returning defaultValue if nothing is entered
+func promptInput(prompt, defaultValue string) string {
+	if defaultValue != "" {
+		fmt.Printf("%s [%s]: ", prompt, defaultValue)
+	} else {
+		fmt.Printf("%s: ", prompt)
+	}
+	response, _ := stdinReader.ReadString('\n')
+	response = strings.TrimSpace(response)
+	if response == "" {
+		return defaultValue
+	}
+	return response
+}
+
+// promptPassword asks for a secret on the terminal without echoing it
+func promptPassword(prompt string) (string, error) {
+	fmt.Printf("%s: ", prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return string(password), err
+}
+
+// stdinIsTerminal reports whether stdin is a terminal someone can type a password into
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// expandLocalHome expands a leading "~/" to the local user's home directory
+func expandLocalHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
+
+// Targets returns the servers listed in REMOTE_SERVER, which may be comma-separated
+func (c *Config) Targets() []string {
+	return parsePatternList(c.RemoteServer)
+}
+
+// forTarget returns a copy of the config that deploys to a single server
+func (c *Config) forTarget(target string) *Config {
+	targetConfig := *c
+	targetConfig.RemoteServer = target
+	return &targetConfig
+}
+
+// flagToConfigKey maps a command line flag name ("dry-run") to its config key ("DRY_RUN")
+func flagToConfigKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configKeyToFlag maps a config key ("DRY_RUN") to its command line flag name ("dry-run")
+func configKeyToFlag(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
+
+// parseBool interprets common truthy config values (true/yes/1/on)
+func parseBool(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "1", "on":
+		return true
+	}
+	return false
+}
+
+// expandEnv replaces $VAR and ${VAR} with local environment variables; "$$"
+// stands for a literal "$" so remote shell variables can still be passed through.
+// Unset variables expand to nothing and are returned in missing.
+func expandEnv(value string) (expanded string, missing []string) {
+	const dollar = "\x00"
+	expanded = os.Expand(strings.ReplaceAll(value, "$$", dollar), func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	return strings.ReplaceAll(expanded, dollar, "$"), missing
+}
+
+// parseSize parses a byte size such as "512", "64KB", "10MB" or "1GB" (1024-based)
+func parseSize(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			multiplier = unit.size
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 512, 64KB or 10MB, got %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// parsePatternList splits a comma-separated list of patterns, dropping empty entries
+func parsePatternList(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// setValue applies a single KEY: value setting to the config
+func (c *Config) setValue(key, value string) error {
+	switch key {
+	case "REMOTE_SERVER":
+		c.RemoteServer = value
+	case "SSH_USERNAME":
+		c.SSHUsername = value
+	case "SSH_PASSWORD":
+		c.SSHPassword = value
+	case "SSH_KEY_FILE", "SSH_KEY_FILES":
+		// Both keys, and every line of them, add to one list, so keys under
+		// SSH_KEY_FILES aren't lost to a legacy SSH_KEY_FILE line
+		keys := c.keyFiles()
+		for _, key := range parsePatternList(value) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+		c.SSHKeyFile = strings.Join(keys, ", ")
+	case "AUTH_ORDER":
+		order, err := parseAuthOrder(value)
+		if err != nil {
+			return err
+		}
+		c.AuthOrder = order
+	case "NO_DOCKERFILE_CHECK":
+		c.NoDockerfileCheck = parseBool(value)
+	case "OWNED_MANIFEST":
+		c.OwnedManifest = value
+	case "STRICT_ENV":
+		c.StrictEnv = parseBool(value)
+	case "VERBOSE":
+		c.Verbose = parseBool(value)
+	case "SSH_CIPHERS":
+		c.SSHCiphers = parsePatternList(value)
+	case "SSH_KEX":
+		c.SSHKeyExchanges = parsePatternList(value)
+	case "SSH_MACS":
+		c.SSHMACs = parsePatternList(value)
+	case "DRY_RUN":
+		c.DryRun = parseBool(value)
+	case "SHOW_BANNER":
+		c.ShowBanner = parseBool(value)
+	case "SSH_CONTROL_PATH":
+		c.SSHControlPath = value
+	case "PROXY":
+		proxyURL, err := parseProxy(value)
+		if err != nil {
+			return err
+		}
+		c.Proxy = proxyURL
+	case "REMOTE_FOLDER":
+		c.RemoteFolder = value
+	case "LOCAL_FOLDER":
+		c.LocalFolder = value
+	case "DOCKER_IMAGE_NAME":
+		c.DockerImageName = value
+	case "DOCKER_BUILD_ARGS":
+		c.DockerBuildArgs = value
+	case "DOCKER_RUN_ARGS":
+		c.DockerRunArgs = value
+	case "REMOVE_OLD_IMAGE":
+		c.RemoveOldImage = parseBool(value)
+	case "DOCKER_SUDO":
+		c.DockerSudo = parseBool(value)
+	case "IGNORE":
+		// Parse comma-separated ignore patterns
+		c.IgnorePatterns = append(c.IgnorePatterns, parsePatternList(value)...)
+	case "NO_DEFAULT_IGNORES":
+		c.NoDefaultIgnores = parseBool(value)
+	case "ALLOWED_EXTENSIONS":
+		c.AllowedExtensions = append(c.AllowedExtensions, parsePatternList(value)...)
+	case "LOCAL_FOLDER_2":
+		c.LocalFolder2 = value
+	case "REMOTE_FOLDER_2":
+		c.RemoteFolder2 = value
+	case "IGNORE_2":
+		c.IgnorePatterns2 = append(c.IgnorePatterns2, parsePatternList(value)...)
+	case "SUMMARY_FILE":
+		c.SummaryFile = value
+	case "MANIFEST_FILE":
+		c.ManifestFile = value
+	case "SSH_COMPRESSION":
+		c.SSHCompression = parseBool(value)
+	case "SINCE":
+		since, err := parseSince(value)
+		if err != nil {
+			return err
+		}
+		c.Since = since
+	case "MAX_DEPTH":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			return fmt.Errorf("expected a non-negative number, got %q", value)
+		}
+		c.MaxDepth = depth
+	case "MAX_FILES":
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return fmt.Errorf("expected a non-negative number, got %q", value)
+		}
+		c.MaxFiles = limit
+	case "DELTA":
+		c.Delta = parseBool(value)
+	case "DELTA_MIN_SIZE":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		c.DeltaMinSize = size
+	case "ZERO_DOWNTIME":
+		c.ZeroDowntime = parseBool(value)
+	case "HEALTH_CHECK_TIMEOUT":
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("expected a positive duration like 30s or 2m, got %q", value)
+		}
+		c.HealthCheckTimeout = timeout
+	case "VERIFY_AFTER":
+		switch strings.ToLower(value) {
+		case "", "false", "no", "0", "off":
+			c.VerifyAfter = ""
+		case "true", "yes", "1", "on", "size":
+			c.VerifyAfter = "size"
+		case "checksum":
+			c.VerifyAfter = "checksum"
+		default:
+			return fmt.Errorf("expected size, checksum or false, got %q", value)
+		}
+	case "UPLOAD_NEWER_ONLY":
+		c.UploadNewerOnly = parseBool(value)
+	case "FRESH":
+		c.Fresh = parseBool(value)
+	case "SKIP_LOCKED":
+		c.SkipLocked = parseBool(value)
+	case "COMPARE":
+		strategy, err := parseCompare(value)
+		if err != nil {
+			return err
+		}
+		c.Compare = strategy
+	case "REMOTE_CMD_PREFIX":
+		c.RemoteCmdPrefix = value
+	case "HOST_KEY_FINGERPRINT":
+		c.HostKeyFingerprints = append(c.HostKeyFingerprints, parsePatternList(value)...)
+	case "HOST_CA_KEY":
+		c.HostCAKey = value
+	case "STALL_WARNING":
+		if off := strings.ToLower(value); off == "0" || off == "off" || off == "false" {
+			c.StallWarning = 0
+			break
+		}
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("expected a positive duration like 2m, or off, got %q", value)
+		}
+		c.StallWarning = interval
+	case "DEV_POLL_INTERVAL":
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("expected a positive duration like 1s, got %q", value)
+		}
+		c.DevPollInterval = interval
+	case "DEV_DEBOUNCE":
+		debounce, err := time.ParseDuration(value)
+		if err != nil || debounce < 0 {
+			return fmt.Errorf("expected a duration like 500ms, got %q", value)
+		}
+		c.DevDebounce = debounce
+	case "IDLE_TIMEOUT":
+		if off := strings.ToLower(value); off == "0" || off == "off" || off == "false" {
+			c.IdleTimeout = 0
+			break
+		}
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("expected a positive duration like 10m, or off, got %q", value)
+		}
+		c.IdleTimeout = timeout
+	case "COPY_BUFFER_SIZE":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		if size < 1024 {
+			return fmt.Errorf("expected at least 1KB, got %q", value)
+		}
+		c.CopyBufferSize = size
+	case "AUDIT_COMMANDS":
+		c.AuditCommands = parseBool(value)
+	case "COMMAND_ALLOWLIST":
+		// Commands are checked one simple command at a time, so an entry
+		// spanning several could never match
+		for _, prefix := range parsePatternList(value) {
+			if commands, err := simpleCommands(prefix); err != nil || len(commands) != 1 {
+				return fmt.Errorf("expected prefixes of single commands, without ; && | or $(...), got %q", prefix)
+			}
+			c.CommandAllowlist = append(c.CommandAllowlist, prefix)
+		}
+	case "KEEP_IMAGES":
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("expected a number of at least 1, got %q", value)
+		}
+		c.KeepImages = keep
+	case "BEFORE_STOP_CMD":
+		c.BeforeStopCmd = value
+	case "CLEANUP_CMD":
+		cmd, err := parseCleanupCmd(value)
+		if err != nil {
+			return err
+		}
+		c.CleanupCmd = cmd
+	case "BETWEEN_STOP_AND_BUILD_CMD":
+		c.BetweenStopAndBuildCmd = value
+	case "BEFORE_RUN_CMD":
+		c.BeforeRunCmd = value
+	case "AFTER_RUN_CMD":
+		c.AfterRunCmd = value
+	case "VERIFY_IMAGE":
+		c.VerifyImage = parseBool(value)
+	case "PERMS_SIDECAR":
+		c.PermsSidecar = parseBool(value)
+	case "BUILD_LOG":
+		c.BuildLog = value
+	case "FROM_GIT_REF":
+		c.FromGitRef = value
+	case "REQUIRE_CLEAN_GIT":
+		c.RequireCleanGit = parseBool(value)
+	case "FORCE":
+		c.Force = parseBool(value)
+	case "CONTAINER_ID_FORMAT":
+		format, err := parseContainerIDFormat(value)
+		if err != nil {
+			return err
+		}
+		c.ContainerIDFormat = format
+	case "CONTAINER_ID_FILE":
+		c.ContainerIDFile = value
+	case "TRANSFER_PROTOCOL":
+		protocol, err := parseTransferProtocol(value)
+		if err != nil {
+			return err
+		}
+		c.TransferProtocol = protocol
+	case "TRANSPORT":
+		transport, err := parseTransport(value)
+		if err != nil {
+			return err
+		}
+		c.Transport = transport
+	case "SHOW_DIFF":
+		c.ShowDiff = parseBool(value)
+	case "PICK_FILES":
+		c.PickFiles = parseBool(value)
+	case "SHOW_DIFF_MAX_SIZE":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		c.ShowDiffMaxSize = size
+	case "CONFIRM_UPLOAD_SIZE":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		c.ConfirmUploadSize = size
+	case "ALLOWED_REMOTE_ROOT":
+		c.AllowedRemoteRoot = value
+	case "EXTRA_FILES":
+		files, err := parseExtraFiles(value)
+		if err != nil {
+			return err
+		}
+		c.ExtraFiles = append(c.ExtraFiles, files...)
+	case "DOCKER_SSH_USER":
+		if value != "" && !remoteUserName.MatchString(value) {
+			return fmt.Errorf("expected a user name, got %q", value)
+		}
+		c.DockerSSHUser = value
+	case "BACKUP_ON_OVERWRITE":
+		c.BackupPatterns = append(c.BackupPatterns, parsePatternList(value)...)
+	case "BACKUP_KEEP":
+		keep, err := strconv.Atoi(value)
+		if err != nil || keep < 1 {
+			return fmt.Errorf("expected a number of at least 1, got %q", value)
+		}
+		c.BackupKeep = keep
+	case "IGNORE_HIDDEN":
+		c.IgnoreHidden = parseBool(value)
+	case "IGNORE_CASE":
+		c.IgnoreCase = parseBool(value)
+	case "SCAFFOLD":
+		c.Scaffold = parseBool(value)
+	case "STRICT_DOCKERFILE":
+		c.StrictDockerfile = parseBool(value)
+	case "UPDATE_SYMLINK":
+		c.UpdateSymlink = value
+	case "MTIME_TOLERANCE":
+		tolerance, err := time.ParseDuration(value)
+		if err != nil || tolerance < 0 {
+			return fmt.Errorf("expected a duration such as 2s, got %q", value)
+		}
+		c.MtimeTolerance = tolerance
+	case "RUN_RETRIES":
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("expected a number of retries, got %q", value)
+		}
+		c.RunRetries = retries
+	case "DEFAULT_FILE_MODE", "DEFAULT_DIR_MODE":
+		var mode os.FileMode
+		if value != "" {
+			var err error
+			if mode, err = parseFileMode(value); err != nil {
+				return err
+			}
+			if mode > 0777 {
+				return fmt.Errorf("setuid, setgid and sticky bits aren't supported, got %q", value)
+			}
+		}
+		if key == "DEFAULT_DIR_MODE" {
+			if mode != 0 && mode&0700 != 0700 {
+				return fmt.Errorf("the owner needs rwx on directories for pushes to work, got %q", value)
+			}
+			c.DefaultDirMode = mode
+		} else {
+			c.DefaultFileMode = mode
+		}
+	case "PUT_MODE":
+		c.PutMode = 0
+		if value != "" {
+			mode, err := parseFileMode(value)
+			if err != nil {
+				return err
+			}
+			c.PutMode = mode
+		}
+	case "BATCH_MODE":
+		c.BatchMode = parseBool(value)
+	case "SYNC_ONLY", "NO_SSH_EXEC":
+		c.SyncOnly = parseBool(value)
+	case "REMOTE_ROOT":
+		c.RemoteRoot = ""
+		if value != "" {
+			c.RemoteRoot = path.Clean(value)
+		}
+	case "ONLY_DOCKER":
+		c.OnlyDocker = parseBool(value)
+	case "STAGING_DIR":
+		c.StagingDir = value
+	case "BUILD_FROM_TAR":
+		c.BuildFromTar = parseBool(value)
+	case "HASH_CACHE":
+		c.HashCache = parseBool(value)
+	case "ALWAYS_UPLOAD":
+		c.AlwaysUploadPatterns = append(c.AlwaysUploadPatterns, parsePatternList(value)...)
+	case "PERMISSIONS_FILE":
+		c.PermissionsFile = value
+	case "EXECUTABLE":
+		c.ExecutablePatterns = append(c.ExecutablePatterns, parsePatternList(value)...)
+	case "PULL_EXECUTABLE":
+		c.PullExecutablePatterns = append(c.PullExecutablePatterns, parsePatternList(value)...)
+	case "LINE_ENDINGS":
+		mode, err := parseLineEndings(value)
+		if err != nil {
+			return err
+		}
+		c.LineEndings = mode
+	case "LINE_ENDINGS_FILES":
+		c.LineEndingsPatterns = append(c.LineEndingsPatterns, parsePatternList(value)...)
+	case "SYMLINK_ESCAPE":
+		mode := strings.ToLower(value)
+		if mode != "skip" && mode != "fail" && mode != "allow" {
+			return fmt.Errorf("expected skip, fail or allow, got %q", value)
+		}
+		c.SymlinkEscape = mode
+	case "SYMLINK_STAT":
+		mode, err := parseSymlinkStat(value)
+		if err != nil {
+			return err
+		}
+		c.SymlinkStat = mode
+	case "MAX_PARALLEL_TARGETS":
+		parallel, err := strconv.Atoi(value)
+		if err != nil || parallel < 1 {
+			return fmt.Errorf("expected a number of at least 1, got %q", value)
+		}
+		c.MaxParallelTargets = parallel
+	case "CONTINUE_ON_DOCKER_ERROR":
+		c.ContinueOnDockerError = parseBool(value)
+	case "DEPLOY_STATE_FILE":
+		c.DeployStateFile = value
+	case "RESUME":
+		c.Resume = parseBool(value)
+	case "RESTART":
+		c.Restart = parseBool(value)
+	case "PARALLEL_DOWNLOADS":
+		parallel, err := strconv.Atoi(value)
+		if err != nil || parallel < 1 {
+			return fmt.Errorf("expected a number of at least 1, got %q", value)
+		}
+		c.ParallelDownloads = parallel
+	case "CONCURRENCY_PER_FILE":
+		concurrency, err := strconv.Atoi(value)
+		if err != nil || concurrency < 1 {
+			return fmt.Errorf("expected a number of at least 1, got %q", value)
+		}
+		c.ConcurrencyPerFile = concurrency
+	case "MAX_SSH_SESSIONS":
+		sessions, err := strconv.Atoi(value)
+		if err != nil || sessions < 2 {
+			return fmt.Errorf("expected a number of at least 2 (one session is used by SFTP), got %q", value)
+		}
+		c.MaxSSHSessions = sessions
+	case "MAX_CONCURRENT_SESSIONS":
+		sessions, err := strconv.Atoi(value)
+		if err != nil || sessions < 0 || sessions == 1 {
+			return fmt.Errorf("expected 0 for no limit or a number of at least 2, got %q", value)
+		}
+		c.MaxConcurrentSessions = sessions
+	}
+	return nil
+}
+
+// parseSince parses a SINCE value given either as a duration ago ("10m") or an RFC3339 timestamp
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
+		return time.Time{}, fmt.Errorf("expected a duration like \"10m\" or an RFC3339 timestamp, got %q", value)
+	}
+	return t, nil
+}
+
+// defaultConfig returns a config with every option at its default value
+func defaultConfig() *Config {
+	return &Config{
+		RemoveOldImage: true,
+		DockerSudo:     true,
+		MaxSSHSessions: defaultMaxSSHSessions,
+		DeltaMinSize:   defaultDeltaMinSize,
+		MaxParallelTargets: 1,
+		DeployStateFile: defaultDeployStateFile,
+		ParallelDownloads: 1,
+		HealthCheckTimeout: defaultHealthCheckTimeout,
+		SymlinkEscape:  "skip",
+		SymlinkStat:    "follow",
+		Compare:        defaultCompare,
+		StallWarning:   defaultStallWarning,
+		DevPollInterval: defaultDevPollInterval,
+		DevDebounce:    defaultDevDebounce,
+		RunRetries:     defaultRunRetries,
+		MtimeTolerance: defaultMtimeTolerance,
+		BackupKeep:     defaultBackupKeep,
+		ShowDiffMaxSize: defaultShowDiffMaxSize,
+		Transport:      "ssh",
+		TransferProtocol: "sftp",
+		ContainerIDFormat: "full",
+		LineEndings:    "keep",
+		MaxFiles:       defaultMaxFiles,
+	}
+}
+
+// splitConfigLine splits a "KEY: value" line of a config file. Blank lines,
+// # comments and lines without a colon give ok false.
+func splitConfigLine(line string) (key, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok = strings.Cut(line, ":")
+	return strings.TrimSpace(key), strings.TrimSpace(value), ok
+}
+
+// LoadConfig loads configuration from a file. Overrides (typically from command
+// line flags) are applied on top of the file's values, keyed by config key.
+// An empty filename loads the overrides alone, for ad-hoc transfers.
+func LoadConfig(filename string, overrides map[string]string) (_ *Config, err error) {
+	defer wrapError(&err, func(err error) error { return &ConfigError{Err: err} })
+	
+	var configData io.Reader = strings.NewReader("")
+	if filename != "" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open config file: %w", err)
+		}
+		defer file.Close()
+		configData = file
 	}
-	defer file.Close()
 
-	config := &Config{}
-	scanner := bufio.NewScanner(file)
+	config := defaultConfig()
+	scanner := bufio.NewScanner(configData)
 	
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		parts := strings.SplitN(line, ":", 2)
-		if len(parts) != 2 {
+		key, value, ok := splitConfigLine(scanner.Text())
+		if !ok {
 			continue
 		}
 		
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		
-		switch key {
-		case "REMOTE_SERVER":
-			config.RemoteServer = value
-		case "SSH_USERNAME":
-			config.SSHUsername = value
-		case "SSH_PASSWORD":
-			config.SSHPassword = value
-		case "REMOTE_FOLDER":
-			config.RemoteFolder = value
-		case "LOCAL_FOLDER":
-			config.LocalFolder = value
-		case "DOCKER_IMAGE_NAME":
-			config.DockerImageName = value
-		case "DOCKER_BUILD_ARGS":
-			config.DockerBuildArgs = value
-		case "DOCKER_RUN_ARGS":
-			config.DockerRunArgs = value
-		case "IGNORE":
-			// Parse comma-separated ignore patterns
-			patterns := strings.Split(value, ",")
-			for _, pattern := range patterns {
-				pattern = strings.TrimSpace(pattern)
-				if pattern != "" {
-					config.IgnorePatterns = append(config.IgnorePatterns, pattern)
-				}
-			}
+		if err := config.setValue(key, value); err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
 		}
+
 	}
 	
 	if err := scanner.Err(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
 	
-	// Validate required fields
-	if config.RemoteServer == "" || config.SSHUsername == "" || config.SSHPassword == "" ||
-		config.RemoteFolder == "" || config.DockerImageName == "" {
+	for key, value := range overrides {
+		if err := config.setValue(key, value); err != nil {
+			return nil, fmt.Errorf("invalid value for --%s: %w", configKeyToFlag(key), err)
+		}
+	}
+	
+	// Docker args may reference local environment variables, e.g. -p ${APP_PORT}:8080
+	for _, arg := range []struct {
+		key   string
+		value *string
+	}{
+		{"DOCKER_BUILD_ARGS", &config.DockerBuildArgs},
+		{"DOCKER_RUN_ARGS", &config.DockerRunArgs},
+	} {
+		expanded, missing := expandEnv(*arg.value)
+		if len(missing) > 0 && config.StrictEnv {
+			return nil, fmt.Errorf("%s references unset environment variables: %s", arg.key, strings.Join(missing, ", "))
+		} else if len(missing) > 0 {
+			log.Printf("⚠️  %s references unset environment variables %s; they expand to nothing", arg.key, strings.Join(missing, ", "))
+		}
+		*arg.value = expanded
+	}
+	
+	// The local transport has no server to log in to
+	if config.Transport == "local" {
+		if config.RemoteServer == "" {
+			config.RemoteServer = localServerName
+		}
+		if config.SSHUsername == "" {
+			config.SSHUsername = os.Getenv("USER")
+		}
+		if config.SSHUsername == "" {
+			config.SSHUsername = "local"
+		}
+	}
+	
+	// Validate required fields; there is no image to build in SYNC_ONLY mode
+	if config.RemoteServer == "" || config.SSHUsername == "" ||
+		config.RemoteFolder == "" || (config.DockerImageName == "" && !config.SyncOnly) {
 		return nil, fmt.Errorf("missing required configuration fields")
 	}
+	if err := config.checkSyncOnly(); err != nil {
+		return nil, err
+	}
+	// A control master is already authenticated, so credentials are only needed for the fallback;
+	// the agent and keyboard-interactive methods need nothing in the config file
+	// Without any of them, ask for the password on the terminal rather than storing it on disk
+	if config.Transport != "local" && config.SSHPassword == "" && config.SSHKeyFile == "" && config.SSHControlPath == "" &&
+		!config.usesAuth("agent") && !config.usesAuth("keyboard-interactive") {
+		if !config.usesAuth("password") || !stdinIsTerminal() || config.BatchMode {
+			return nil, fmt.Errorf("either SSH_PASSWORD, SSH_KEY_FILE or SSH_CONTROL_PATH must be specified, or an AUTH_ORDER with agent or keyboard-interactive (there is no terminal to prompt for a password, or BATCH_MODE is set)")
+		}
+		password, err := promptPassword(fmt.Sprintf("SSH password for %s@%s", config.SSHUsername, config.RemoteServer))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH password: %w", err)
+		}
+		if password == "" {
+			return nil, fmt.Errorf("no SSH password entered")
+		}
+		config.SSHPassword = password
+	}
 	
 	// Default local folder to current directory if not specified
 	if config.LocalFolder == "" {
 		config.LocalFolder = "."
 	}
 	
-	// Add default ignore patterns if none specified
-	if len(config.IgnorePatterns) == 0 {
-		config.IgnorePatterns = []string{".git", ".gitignore", ".env", "*.swp", "*.tmp"}
+	// Every file of a git export carries the commit's time, so SINCE would keep all or none
+	if config.FromGitRef != "" && !config.Since.IsZero() {
+		return nil, fmt.Errorf("SINCE can't be combined with FROM_GIT_REF, whose files all have the commit's time")
+	}
+	if config.PickFiles && config.BatchMode {
+		return nil, fmt.Errorf("PICK_FILES asks which files to upload, so it can't be combined with BATCH_MODE")
+	}
+	// FRESH uploads the whole tree into an emptied folder, which these would leave gaps in
+	if config.Fresh {
+		switch {
+		case !config.Since.IsZero():
+			return nil, fmt.Errorf("FRESH uploads every file, so it can't be combined with SINCE")
+		case config.PickFiles:
+			return nil, fmt.Errorf("FRESH uploads every file, so it can't be combined with PICK_FILES")
+		case config.OnlyDocker:
+			return nil, fmt.Errorf("FRESH syncs the files again, so it can't be combined with ONLY_DOCKER")
+		}
 	}
 	
-	return config, nil
-}
-
-// NewSyncManager creates a new sync manager instance
-func NewSyncManager(config *Config) (*SyncManager, error) {
-	return &SyncManager{
-		config: config,
-	}, nil
-}
-
-// Connect establishes SSH and SFTP connections
-func (sm *SyncManager) Connect() error {
-	// SSH configuration
-	sshConfig := &ssh.ClientConfig{
-		User: sm.config.SSHUsername,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(sm.config.SSHPassword),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // In production, use proper host key verification
-		Timeout:         10 * time.Second,
+	// A templated REMOTE_FOLDER, e.g. a date-stamped release, is fixed for the whole run
+	remoteFolder, err := expandFolderTemplate(config.RemoteFolder, config.LocalFolder, config.FromGitRef, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("invalid REMOTE_FOLDER template: %w", err)
 	}
+	config.RemoteFolder = remoteFolder
 	
-	// Add port if not specified
-	addr := sm.config.RemoteServer
-	if !strings.Contains(addr, ":") {
-		addr = addr + ":22"
+	// Extra files are uploaded into REMOTE_FOLDER, which the tarball build doesn't use
+	if len(config.ExtraFiles) > 0 && config.BuildFromTar {
+		return nil, fmt.Errorf("EXTRA_FILES can't be combined with BUILD_FROM_TAR")
 	}
 	
-	// Connect via SSH
-	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
-	if err != nil {
-		return fmt.Errorf("failed to connect via SSH: %w", err)
+	// A zero-downtime deploy builds before it stops anything
+	if config.ZeroDowntime && config.BetweenStopAndBuildCmd != "" {
+		return nil, fmt.Errorf("BETWEEN_STOP_AND_BUILD_CMD can't be used with ZERO_DOWNTIME, which stops the old containers last")
 	}
-	sm.sshClient = sshClient
 	
-	// Create SFTP client
-	sftpClient, err := sftp.NewClient(sshClient)
-	if err != nil {
-		sm.sshClient.Close()
-		return fmt.Errorf("failed to create SFTP client: %w", err)
+	if config.Resume && config.Restart {
+		return nil, fmt.Errorf("--resume and --restart can't be combined")
 	}
-	sm.sftpClient = sftpClient
 	
-	log.Printf("\n✅ Connected to %s", sm.config.RemoteServer)
-	return nil
-}
-
-// Close closes all connections
-func (sm *SyncManager) Close() {
-	if sm.sftpClient != nil {
-		sm.sftpClient.Close()
+	// Every connected target keeps a session open for SFTP, so the shared
+	// limit needs room for one more, or targets could all wait on each other
+	if config.MaxConcurrentSessions > 0 {
+		running := min(config.MaxParallelTargets, len(config.Targets()))
+		if config.MaxConcurrentSessions <= running {
+			return nil, fmt.Errorf("MAX_CONCURRENT_SESSIONS must be more than the %d targets deployed at once, as each keeps a session open for SFTP", running)
+		}
+		config.SharedSessions = make(chan struct{}, config.MaxConcurrentSessions)
 	}
-	if sm.sshClient != nil {
-		sm.sshClient.Close()
+	
+	// The scp backend runs shell commands next to each transfer, on its own sessions
+	if config.TransferProtocol == "scp" {
+		if config.SSHControlPath != "" {
+			return nil, fmt.Errorf("TRANSFER_PROTOCOL: scp can't be combined with SSH_CONTROL_PATH")
+		}
+		if config.MaxSSHSessions < 2 {
+			return nil, fmt.Errorf("TRANSFER_PROTOCOL: scp needs MAX_SSH_SESSIONS of at least 2")
+		}
 	}
-}
-
-// shouldIgnore checks if a file/directory should be ignored based on patterns
-func (sm *SyncManager) shouldIgnore(relPath string, info os.FileInfo) bool {
-	baseName := filepath.Base(relPath)
-	relPathSlash := filepath.ToSlash(relPath)
 	
-	for _, pattern := range sm.config.IgnorePatterns {
-		// Clean up pattern - remove leading slashes
-		pattern = strings.TrimPrefix(pattern, "/")
-		pattern = strings.TrimPrefix(pattern, "./")
-		
-		// Check if it's explicitly a directory pattern (ends with /)
-		isDirectoryPattern := strings.HasSuffix(pattern, "/")
-		if isDirectoryPattern {
-			pattern = strings.TrimSuffix(pattern, "/")
+	// golang.org/x/crypto/ssh only negotiates the "none" compression algorithm,
+	// so a config asking for compression would silently go without it
+	if config.SSHCompression {
+		return nil, fmt.Errorf("SSH_COMPRESSION can't be enabled: the Go SSH library pooshit uses only supports uncompressed connections; remove it, or set it to false")
+	}
+	
+	// A fixed mode replaces the sidecar's modes just as it replaces the filesystem's
+	if config.DefaultFileMode != 0 && config.PermsSidecar {
+		return nil, fmt.Errorf("DEFAULT_FILE_MODE can't be combined with PERMS_SIDECAR, whose modes it would replace")
+	}
+	
+	if config.PermissionsFile != "" {
+		rules, err := loadPermissionRules(config.PermissionsFile)
+		if err != nil {
+			return nil, err
+		}
+		config.PermissionRules = rules
+	}
+	
+	// Each target gets its own manifest
+	if config.ManifestFile != "" && len(config.Targets()) > 1 && !strings.Contains(config.ManifestFile, "{target}") {
+		return nil, fmt.Errorf("MANIFEST_FILE must contain {target} when REMOTE_SERVER lists several servers, e.g. manifest-{target}.json")
+	}
+	if config.BuildLog != "" && len(config.Targets()) > 1 && !strings.Contains(config.BuildLog, "{target}") {
+		return nil, fmt.Errorf("BUILD_LOG must contain {target} when REMOTE_SERVER lists several servers, e.g. build-{target}.log")
+	}
+	if config.ContainerIDFile != "" && len(config.Targets()) > 1 && !strings.Contains(config.ContainerIDFile, "{target}") {
+		return nil, fmt.Errorf("CONTAINER_ID_FILE must contain {target} when REMOTE_SERVER lists several servers, e.g. container-{target}.id")
+	}
+	
+	// Scaffolding only touches directories, so there is nothing for it to do
+	if config.OnlyDocker && config.Scaffold {
+		return nil, fmt.Errorf("SCAFFOLD can't be combined with ONLY_DOCKER, which skips the file sync")
+	}
+	
+	// The tarball is the build context, so it can't be skipped
+	if config.OnlyDocker && config.BuildFromTar {
+		return nil, fmt.Errorf("ONLY_DOCKER can't be combined with BUILD_FROM_TAR, which uploads the build context")
+	}
+	
+	// The secondary sync pair needs both ends
+	if (config.LocalFolder2 == "") != (config.RemoteFolder2 == "") {
+		return nil, fmt.Errorf("LOCAL_FOLDER_2 and REMOTE_FOLDER_2 must be specified together")
+	}
+	
+	// Add default ignore patterns if none specified (unless opted out)
+	if !config.NoDefaultIgnores {
+		if len(config.IgnorePatterns) == 0 {
+			config.IgnorePatterns = defaultIgnorePatterns
+		}
+		if config.LocalFolder2 != "" && len(config.IgnorePatterns2) == 0 {
+			config.IgnorePatterns2 = defaultIgnorePatterns
+		}
+	}
+	
+	return config, nil
+}
+
+// NewSyncManager creates a new sync manager instance
+func NewSyncManager(config *Config) (*SyncManager, error) {
+	maxSessions := config.MaxSSHSessions
+	if maxSessions < 2 {
+		maxSessions = defaultMaxSSHSessions
+	}
+	
+	result := &SyncResult{
+		Target:    config.RemoteServer,
+		Timestamp: time.Now(),
+		DryRun:    config.DryRun,
+		GitSHA:    config.GitSHA,
+	}
+	if config.MakePlan {
+		result.plan = &TargetPlan{Target: config.RemoteServer}
+	}
+	
+	return &SyncManager{
+		config:       config,
+		sessionSlots: make(chan struct{}, maxSessions),
+		result:       result,
+	}, nil
+}
+
+// Result returns the results collected so far during this run
+func (sm *SyncManager) Result() *SyncResult {
+	return sm.result
+}
+
+// Finish records the outcome of the run
+func (sm *SyncManager) Finish(mode string, runErr error) {
+	sm.result.Mode = mode
+	sm.result.Duration = time.Since(sm.result.Timestamp).Round(time.Millisecond).String()
+	sm.result.Timings = sm.phaseTimings()
+	sm.result.Result = "success"
+	if runErr != nil {
+		sm.result.Result = "failure"
+		sm.result.Error = runErr.Error()
+		sm.result.ErrorKind = errorKind(runErr)
+	}
+}
+
+// writeSummary writes the run results as JSON to the configured SUMMARY_FILE.
+// A single target is written as one object; several targets are wrapped with an overall result.
+func writeSummary(path string, results []*SyncResult) {
+	if path == "" {
+		return
+	}
+	
+	var summary interface{} = results[0]
+	if len(results) > 1 {
+		overall := "success"
+		for _, r := range results {
+			if r.Result == "failure" {
+				overall = "failure"
+			}
+		}
+		summary = struct {
+			Result  string        `json:"result"`
+			Targets []*SyncResult `json:"targets"`
+		}{overall, results}
+	}
+	
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		log.Printf("⚠️  Failed to encode summary: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		log.Printf("⚠️  Failed to write summary file %s: %v", path, err)
+		return
+	}
+	log.Printf("📝 Summary written to %s", path)
+}
+
+// remoteSession is a single remote command, either an SSH session of our own
+// connection or a session opened through an OpenSSH control master
+type remoteSession interface {
+	Output(cmd string) ([]byte, error)
+	CombinedOutput(cmd string) ([]byte, error)
+	StdoutPipe() (io.Reader, error)
+	StderrPipe() (io.Reader, error)
+	Start(cmd string) error
+	Wait() error
+	Close() error
+}
+
+// controlSocketPath expands the tokens OpenSSH allows in a ControlPath:
+// %h host, %p port, %r remote user, %% a literal percent sign
+func (sm *SyncManager) controlSocketPath() string {
+	host, port := sm.config.RemoteServer, "22"
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	replacer := strings.NewReplacer("%h", host, "%p", port, "%r", sm.config.SSHUsername, "%%", "%")
+	return replacer.Replace(expandLocalHome(sm.config.SSHControlPath))
+}
+
+// connectViaControlSocket tries to reuse an existing ControlMaster connection.
+// It returns false when the socket is missing or unusable so a normal dial can follow.
+func (sm *SyncManager) connectViaControlSocket() bool {
+	socketPath := sm.controlSocketPath()
+	if _, err := os.Stat(socketPath); err != nil {
+		log.Printf("ℹ️  No control socket at %s, connecting directly", socketPath)
+		return false
+	}
+	if err := sm.connectControlSocket(socketPath); err != nil {
+		log.Printf("⚠️  Could not use control socket %s: %v; connecting directly", socketPath, err)
+		return false
+	}
+	return true
+}
+
+// bannerCallback shows the server's pre-auth banner if SHOW_BANNER is on, and drops it otherwise
+func (sm *SyncManager) bannerCallback(message string) error {
+	if sm.config.ShowBanner && strings.TrimSpace(message) != "" {
+		log.Printf("📢 Server banner:\n%s", strings.TrimRight(message, "\n"))
+	}
+	return nil
+}
+
+// Connect establishes SSH and SFTP connections
+func (sm *SyncManager) Connect() (err error) {
+	defer wrapError(&err, func(err error) error { return &ConnectionError{Server: sm.config.RemoteServer, Err: err} })
+	
+	if sm.config.Transport == "local" {
+		if sm.config.TransferProtocol == "scp" {
+			return sm.connectSCP()
+		}
+		return sm.connectLocal()
+	}
+	
+	if sm.config.SSHControlPath != "" && sm.connectViaControlSocket() {
+		log.Printf("\n✅ Connected to %s via control socket %s", sm.config.RemoteServer, sm.controlSocket)
+		return nil
+	}
+	
+	auth, cleanup, err := sm.authMethods()
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if len(auth) == 0 {
+		return fmt.Errorf("no usable auth method in AUTH_ORDER %s", strings.Join(sm.config.authOrder(), ","))
+	}
+	
+	// Host keys are only verified when HOST_KEY_FINGERPRINT or HOST_CA_KEY is set
+	hostKeyCallback, hostKeyAlgorithms, err := sm.hostKeyCallback()
+	if err != nil {
+		return err
+	}
+	
+	// SSH configuration
+	sshConfig := &ssh.ClientConfig{
+		User:              sm.config.SSHUsername,
+		Auth:              auth,
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: hostKeyAlgorithms,
+		Timeout:           10 * time.Second,
+		BannerCallback:    sm.bannerCallback,
+	}
+	
+	// Empty lists keep the library's secure defaults; setting one replaces them entirely
+	sshConfig.Ciphers = sm.config.SSHCiphers
+	sshConfig.KeyExchanges = sm.config.SSHKeyExchanges
+	sshConfig.MACs = sm.config.SSHMACs
+	
+	// Add port if not specified
+	addr := sm.config.RemoteServer
+	if !strings.Contains(addr, ":") {
+		addr = addr + ":22"
+	}
+	
+	// Connect via SSH, through PROXY or ALL_PROXY if set
+	conn, err := sm.dialServer(addr, sshConfig.Timeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, sshConfig)
+	if err != nil {
+		conn.Close()
+		if strings.Contains(err.Error(), "no common algorithm") {
+			return fmt.Errorf("failed to connect via SSH: %w (legacy servers may need SSH_CIPHERS, SSH_KEX or SSH_MACS)", err)
+		}
+		return fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	sshClient := ssh.NewClient(clientConn, chans, reqs)
+	sm.sshClient = sshClient
+	sm.verbosef("Authenticated with %s", sm.authUsed)
+	
+	if sm.config.TransferProtocol == "scp" {
+		if err := sm.connectSCP(); err != nil {
+			sm.sshClient.Close()
+			return err
+		}
+		log.Printf("\n✅ Connected to %s", sm.config.RemoteServer)
+		return nil
+	}
+	
+	// Create SFTP client, which holds one session slot for the lifetime of the connection
+	sm.acquireSession()
+	sftpClient, err := sftp.NewClient(sshClient, sm.sftpOptions()...)
+	if err != nil {
+		sm.releaseSession()
+		sm.sshClient.Close()
+		if sftpUnavailable(err) {
+			return fmt.Errorf("failed to create SFTP client: %w (the server has no SFTP subsystem; TRANSFER_PROTOCOL: scp transfers files with scp instead)", err)
+		}
+		return fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+	sm.sftpClient = sftpClient
+	
+	log.Printf("\n✅ Connected to %s", sm.config.RemoteServer)
+	return nil
+}
+
+// sftpOptions returns the SFTP client options for the configured CONCURRENCY_PER_FILE.
+// Downloads already read large files with concurrent requests; this caps how many,
+// and 1 turns it off for servers that can't handle out-of-order reads.
+func (sm *SyncManager) sftpOptions() []sftp.ClientOption {
+	var options []sftp.ClientOption
+	switch n := sm.config.ConcurrencyPerFile; {
+	case n == 1:
+		options = append(options, sftp.UseConcurrentReads(false))
+	case n > 1:
+		options = append(options, sftp.MaxConcurrentRequestsPerFile(n))
+	}
+	
+	// Each COPY_BUFFER_SIZE write is sent as several packets in flight instead
+	// of one at a time, and packets grow with the buffer
+	if size := sm.config.CopyBufferSize; size > 0 {
+		options = append(options, sftp.UseConcurrentWrites(true))
+		if size > defaultSFTPPacket {
+			packet := maxTunedSFTPPacket
+			if size < int64(packet) {
+				packet = int(size)
+			}
+			options = append(options, sftp.MaxPacketUnchecked(packet))
+		}
+	}
+	return options
+}
+
+// Close closes all connections
+func (sm *SyncManager) Close() {
+	if sm.sftpClient != nil {
+		sm.removeBuildContext()
+		sm.sftpClient.Close()
+		sm.sftpClient = nil
+		sm.releaseSession()
+	}
+	if sm.controlSession != nil {
+		sm.controlSession.Close()
+		sm.controlSession = nil
+	}
+	if sm.sshClient != nil {
+		sm.sshClient.Close()
+		sm.sshClient = nil
+	}
+}
+
+// shouldIgnore checks if a file/directory should be ignored based on patterns.
+// Patterns are evaluated in order and the last match wins, so a later
+// "!pattern" re-includes paths excluded by an earlier pattern.
+func (sm *SyncManager) shouldIgnore(relPath string, info os.FileInfo, patterns []string) bool {
+	// The HASH_CACHE and MANIFEST_FILE copies and the PERMS_SIDECAR file are pooshit's own bookkeeping, never project content
+	if filepath.ToSlash(relPath) == hashCacheFile || filepath.ToSlash(relPath) == manifestFile || filepath.ToSlash(relPath) == permsSidecarFile {
+		return true
+	}
+	// So are the BACKUP_ON_OVERWRITE copies
+	if !info.IsDir() && isBackupFile(relPath) {
+		return true
+	}
+	// With IGNORE_HIDDEN, dotfiles and everything in dot-directories start out
+	// ignored; a "!pattern" can still bring them back
+	hidden := sm.config.IgnoreHidden && hiddenPath(relPath)
+	return applyPatternList(hidden, relPath, info, patterns, sm.config.IgnoreCase)
+}
+
+// hiddenPath reports whether any segment of a relative path starts with "."
+func hiddenPath(relPath string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPatternList reports whether a path matches a pattern list with ignore
+// semantics: in order, last match wins, "!pattern" negates
+func matchPatternList(relPath string, info os.FileInfo, patterns []string, ignoreCase bool) bool {
+	return applyPatternList(false, relPath, info, patterns, ignoreCase)
+}
+
+// applyPatternList applies a pattern list on top of an initial decision
+func applyPatternList(matched bool, relPath string, info os.FileInfo, patterns []string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		if negate {
+			pattern = pattern[1:]
 		}
 		
-		// For directory patterns or patterns without wildcards, check directory names
-		if isDirectoryPattern || !strings.Contains(pattern, "*") {
-			// Check if this is the directory itself
-			if info.IsDir() && (baseName == pattern || matchPattern(baseName, pattern)) {
+		if matchIgnorePattern(relPath, info, pattern, ignoreCase) {
+			matched = !negate
+		}
+	}
+	
+	return matched
+}
+
+// remoteFileMode returns the permissions a file gets on the remote: its local
+// mode, or DEFAULT_FILE_MODE, or the mode a PERMISSIONS_FILE rule gives it,
+// plus the executable bits if it matches an EXECUTABLE pattern
+func (sm *SyncManager) remoteFileMode(relPath string, info os.FileInfo) os.FileMode {
+	mode := info.Mode().Perm()
+	if sm.config.DefaultFileMode != 0 {
+		mode = sm.config.DefaultFileMode
+	}
+	if ruleMode, ok := sm.config.ruleFileMode(relPath, info); ok {
+		mode = ruleMode
+	}
+	if matchPatternList(relPath, info, sm.config.ExecutablePatterns, sm.config.IgnoreCase) {
+		mode |= 0111
+	}
+	return mode
+}
+
+// localFileMode returns the permissions a pulled file gets locally: its
+// remote mode, plus the executable bits if it matches a PULL_EXECUTABLE pattern
+func (sm *SyncManager) localFileMode(relPath string, info os.FileInfo) os.FileMode {
+	mode := info.Mode().Perm()
+	if matchPatternList(relPath, info, sm.config.PullExecutablePatterns, sm.config.IgnoreCase) {
+		mode |= 0111
+	}
+	return mode
+}
+
+// matchIgnorePattern checks if a file/directory matches a single ignore
+// pattern; with ignoreCase, IGNORE_CASE, both are lowercased first
+func matchIgnorePattern(relPath string, info os.FileInfo, pattern string, ignoreCase bool) bool {
+	if ignoreCase {
+		relPath = strings.ToLower(relPath)
+		pattern = strings.ToLower(pattern)
+	}
+	baseName := filepath.Base(relPath)
+	relPathSlash := filepath.ToSlash(relPath)
+	
+	// Clean up pattern - remove leading slashes
+	pattern = strings.TrimPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "./")
+	
+	// Check if it's explicitly a directory pattern (ends with /)
+	isDirectoryPattern := strings.HasSuffix(pattern, "/")
+	if isDirectoryPattern {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	
+	// Patterns with a slash are anchored at the folder root and matched against
+	// the whole relative path, or one of its parent directories
+	if strings.Contains(pattern, "/") {
+		parts := strings.Split(relPathSlash, "/")
+		for i := len(parts); i > 0; i-- {
+			if i == len(parts) && isDirectoryPattern && !info.IsDir() {
+				continue
+			}
+			if matchPathPattern(pattern, strings.Join(parts[:i], "/")) {
+				return true
+			}
+		}
+		return false
+	}
+	
+	// For directory patterns or patterns without wildcards, check directory names
+	if isDirectoryPattern || !strings.Contains(pattern, "*") {
+		// Check if this is the directory itself
+		if info.IsDir() && (baseName == pattern || matchPattern(baseName, pattern)) {
+			return true
+		}
+		
+		// Check if any parent directory matches
+		pathParts := strings.Split(relPathSlash, "/")
+		for _, part := range pathParts {
+			if part == pattern || matchPattern(part, pattern) {
 				return true
 			}
-			
-			// Check if any parent directory matches
-			pathParts := strings.Split(relPathSlash, "/")
-			for _, part := range pathParts {
-				if part == pattern || matchPattern(part, pattern) {
-					return true
+		}
+	}
+	
+	// For file patterns (containing wildcards)
+	if strings.Contains(pattern, "*") {
+		if matchPattern(baseName, pattern) {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// beyondMaxDepth reports whether a directory sits at or below MAX_DEPTH, meaning
+// its contents would exceed the limit and the walk should not descend into it.
+// Depth counts path segments, so files directly in the root folder are at depth 1.
+func (sm *SyncManager) beyondMaxDepth(relPath string, info os.FileInfo) bool {
+	if sm.config.MaxDepth == 0 || !info.IsDir() {
+		return false
+	}
+	depth := len(strings.Split(filepath.ToSlash(relPath), "/"))
+	return depth >= sm.config.MaxDepth
+}
+
+// followSymlink resolves a symlink found while scanning and returns the info of
+// the file it points to, or nil if the link should be skipped. Links resolving
+// outside localRoot are skipped and counted, or fail the scan, per SYMLINK_ESCAPE.
+func (sm *SyncManager) followSymlink(localRoot, localPath, relPath string, result *FolderResult) (os.FileInfo, error) {
+	resolved, err := filepath.EvalSymlinks(localPath)
+	if err != nil {
+		log.Printf("⚠️  Skipping broken symlink %s: %v", relPath, err)
+		return nil, nil
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return nil, err
+	}
+	
+	rel, err := filepath.Rel(localRoot, resolved)
+	escapes := err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	if escapes && sm.config.SymlinkEscape != "allow" {
+		if sm.config.SymlinkEscape == "fail" {
+			return nil, fmt.Errorf("symlink %s points outside the local folder to %s (set SYMLINK_ESCAPE: allow to follow it)", relPath, resolved)
+		}
+		log.Printf("⚠️  Not following symlink %s: it points outside the local folder to %s", relPath, resolved)
+		result.SymlinkEscapes++
+		return nil, nil
+	}
+	
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		// filepath.Walk doesn't descend into linked directories
+		log.Printf("⚠️  Skipping symlinked directory %s", relPath)
+		return nil, nil
+	}
+	return info, nil
+}
+
+// matchPathPattern matches a slash path against a pattern one segment at a
+// time, like path.Match, except that a "**" segment matches any number of
+// segments, none included
+func matchPathPattern(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			for i := len(names); i >= 0; i-- {
+				if matchSegments(patterns[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(patterns[0], names[0]); !matched {
+			return false
+		}
+		patterns, names = patterns[1:], names[1:]
+	}
+	return len(names) == 0
+}
+
+// matchPattern checks if a string matches a simple glob pattern
+func matchPattern(str, pattern string) bool {
+	// Handle simple wildcard patterns
+	if strings.Contains(pattern, "*") {
+		// Use filepath.Match for glob pattern matching
+		matched, _ := filepath.Match(pattern, str)
+		return matched
+	}
+	// Exact match
+	return str == pattern
+}
+
+// SyncFiles synchronizes local folder to remote folder, followed by the
+// secondary LOCAL_FOLDER_2/REMOTE_FOLDER_2 pair when one is configured
+func (sm *SyncManager) SyncFiles() (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+	
+	if err := sm.loadOwnedManifest(); err != nil {
+		return err
+	}
+	
+	result, err := sm.syncPrimary()
+	if err != nil {
+		return err
+	}
+	sm.recordFolder(result)
+	results := []*FolderResult{result}
+	
+	if sm.config.LocalFolder2 != "" {
+		log.Println("\n📦 Syncing secondary folder pair...")
+		result, err := sm.syncFolder(sm.config.LocalFolder2, sm.config.RemoteFolder2, sm.config.IgnorePatterns2)
+		if err != nil {
+			return fmt.Errorf("secondary sync failed: %w", err)
+		}
+		sm.recordFolder(result)
+		results = append(results, result)
+		
+		log.Println("\n📊 Sync summary:")
+		for _, r := range results {
+			log.Printf("   %s -> %s: %d checked, %d transferred (%d always), %d up-to-date, %d ignored",
+				r.LocalFolder, r.RemoteFolder, r.Checked, r.Transferred, r.AlwaysUploaded, r.Skipped, r.Ignored)
+		}
+	}
+	
+	if err := sm.uploadExtraFiles(); err != nil {
+		return err
+	}
+	
+	// Check if Dockerfile exists in the synced files
+	dockerfilePath := filepath.Join(sm.config.LocalFolder, "Dockerfile")
+	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) && !sm.config.NoDockerfileCheck && !sm.config.SyncOnly {
+		log.Printf("WARNING: No Dockerfile found in local folder '%s'", sm.config.LocalFolder)
+	}
+	
+	if sm.config.DryRun {
+		sm.printChanges("to upload")
+	} else {
+		sm.printChanges("uploaded")
+	}
+	return nil
+}
+
+// syncPrimary transfers the primary folder pair: as a single tarball with
+// BUILD_FROM_TAR, through STAGING_DIR if set, or straight into REMOTE_FOLDER
+func (sm *SyncManager) syncPrimary() (*FolderResult, error) {
+	if sm.config.BuildFromTar {
+		return sm.uploadBuildContext()
+	}
+	if sm.config.StagingDir != "" && sm.config.DryRun {
+		log.Printf("🔎 Would sync into %s and swap it into %s", sm.config.StagingDir, sm.config.RemoteFolder)
+	}
+	if sm.config.StagingDir == "" || sm.config.DryRun {
+		return sm.syncFolder(sm.config.LocalFolder, sm.config.RemoteFolder, sm.config.IgnorePatterns)
+	}
+	
+	stagingPath, destPath, err := sm.prepareStaging()
+	if err != nil {
+		return nil, err
+	}
+	result, err := sm.syncFolder(sm.config.LocalFolder, sm.config.StagingDir, sm.config.IgnorePatterns)
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.swapStaging(stagingPath, destPath); err != nil {
+		return nil, err
+	}
+	result.RemoteFolder = sm.config.RemoteFolder
+	return result, nil
+}
+
+// recordFolder adds a folder's transfer counts to the run result
+func (sm *SyncManager) recordFolder(result *FolderResult) {
+	sm.result.Folders = append(sm.result.Folders, result)
+	sm.result.FilesTransferred += result.Transferred
+	sm.result.BytesTransferred += result.Bytes
+}
+
+// resolveRemotePath expands a leading "~/" to the remote home directory.
+// With REMOTE_ROOT, relative paths are resolved against it as well.
+func (sm *SyncManager) resolveRemotePath(remotePath string) (string, error) {
+	if sm.config.RemoteRoot != "" && !strings.HasPrefix(remotePath, "~/") && !path.IsAbs(filepath.ToSlash(remotePath)) {
+		return path.Join(sm.config.RemoteRoot, filepath.ToSlash(remotePath)), nil
+	}
+	if strings.HasPrefix(remotePath, "~/") {
+		homeDir, err := sm.getRemoteHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get remote home directory: %w", err)
+		}
+		remotePath = filepath.Join(homeDir, remotePath[2:])
+	}
+	return filepath.ToSlash(remotePath), nil
+}
+
+// syncFolder uploads a single local folder to a remote folder
+func (sm *SyncManager) syncFolder(localFolder, remoteFolder string, ignorePatterns []string) (*FolderResult, error) {
+	log.Printf("Starting file synchronization from '%s' to '%s'...", localFolder, remoteFolder)
+	
+	if len(ignorePatterns) > 0 {
+		log.Printf("Ignoring patterns: %s", strings.Join(ignorePatterns, ", "))
+	}
+	
+	result := &FolderResult{
+		LocalFolder:  localFolder,
+		RemoteFolder: remoteFolder,
+	}
+	
+	// Check if local folder exists
+	localInfo, err := os.Stat(localFolder)
+	if err != nil {
+		return nil, fmt.Errorf("local folder '%s' does not exist or cannot be accessed: %w", localFolder, err)
+	}
+	if !localInfo.IsDir() {
+		return nil, fmt.Errorf("local path '%s' is not a directory", localFolder)
+	}
+	
+	// Symlinks are checked against the real location of the folder
+	localRoot, err := filepath.EvalSymlinks(localFolder)
+	if err == nil {
+		localRoot, err = filepath.Abs(localRoot)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local folder '%s': %w", localFolder, err)
+	}
+	
+	// Expand tilde in remote folder path
+	remotePath, err := sm.resolveRemotePath(remoteFolder)
+	if err != nil {
+		return nil, err
+	}
+	if err := sm.checkAllowedRoot(remotePath); err != nil {
+		return nil, err
+	}
+	log.Printf("Resolved remote path: %s", remotePath)
+	
+	// Check if remote directory exists and create if needed
+	if _, err := sm.sftpClient.Stat(remotePath); err != nil && sm.config.DryRun {
+		log.Printf("🔎 Would create remote directory: %s", remotePath)
+	} else if err != nil {
+		log.Printf("Remote directory doesn't exist, creating: %s", remotePath)
+		if err := sm.ensureRemoteDir(remotePath); err != nil {
+			return nil, fmt.Errorf("failed to create remote directory %s: %w", remotePath, err)
+		}
+		sm.applyDirMode(remotePath)
+		log.Printf("✅ Successfully created remote directory: %s", remotePath)
+	} else {
+		log.Printf("Remote directory exists: %s", remotePath)
+	}
+	
+	// First pass: count total files to sync
+	log.Print("Scanning local directory...")
+	scanStart := time.Now()
+	primary := remoteFolder == sm.config.RemoteFolder || remoteFolder == sm.config.StagingDir
+	filesToSync, err := sm.scanLocalFolder(localFolder, localRoot, remotePath, primary, ignorePatterns, result, func(remoteDir string) error {
+		if sm.config.DryRun {
+			return nil
+		}
+		if err := sm.ensureRemoteDir(remoteDir); err != nil {
+			// Every upload below would fail the same way, so stop here
+			var permErr *remotePermissionError
+			if errors.As(err, &permErr) {
+				return err
+			}
+			log.Printf("⚠️  Could not create remote directory %s: %v", remoteDir, err)
+			return nil
+		}
+		sm.applyDirMode(remoteDir)
+		return nil
+	})
+	sm.timePhase("scan", scanStart)
+	ignored := result.Ignored
+	
+	var permErr *remotePermissionError
+	if errors.As(err, &permErr) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan local directory: %w", err)
+	}
+	if result.SymlinkEscapes > 0 {
+		log.Printf("(%d symlinks pointing outside %s skipped)", result.SymlinkEscapes, localFolder)
+	}
+	if result.SymlinksSkipped > 0 {
+		log.Printf("(%d symlinks in %s not followed, SYMLINK_STAT: nofollow)", result.SymlinksSkipped, localFolder)
+	}
+	if result.NotModified > 0 {
+		log.Printf("(%d files not modified since %s excluded)", result.NotModified, sm.config.Since.Format(time.RFC3339))
+	}
+	if result.NotAllowed > 0 {
+		log.Printf("(%d files excluded by ALLOWED_EXTENSIONS)", result.NotAllowed)
+	}
+	
+	if len(filesToSync) == 0 {
+		if _, _, err := sm.planFolder(localFolder, remoteFolder, nil); err != nil {
+			return nil, err
+		}
+		log.Println("No files to sync")
+		if ignored > 0 {
+			log.Printf("(%d files/directories ignored based on patterns)", ignored)
+		}
+		return result, nil
+	}
+	
+	log.Printf("Found %d files to check (%d ignored)", len(filesToSync), ignored)
+	if sm.config.MaxFiles > 0 && len(filesToSync) > sm.config.MaxFiles/2 {
+		log.Printf("⚠️  %d files is over half of MAX_FILES (%d); check LOCAL_FOLDER and IGNORE if that's more than expected", len(filesToSync), sm.config.MaxFiles)
+	}
+	
+	// LINE_ENDINGS: lf swaps CRLF text files for converted copies before anything is compared
+	cleanupConverted, err := sm.convertLineEndings(filesToSync, result)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanupConverted()
+	
+	// PERMS_SIDECAR replaces modes a Windows or FAT checkout doesn't keep
+	if err := sm.applyPermsSidecar(localFolder, filesToSync); err != nil {
+		return nil, err
+	}
+	
+	// A saved plan fixes which files are uploaded; making one records them
+	filesToSync, folderPlan, err := sm.planFolder(localFolder, remoteFolder, filesToSync)
+	if err != nil {
+		return nil, err
+	}
+	
+	// PICK_FILES lets the changed files that aren't wanted yet be left out
+	if sm.config.PickFiles {
+		filesToSync, err = sm.pickFiles(filesToSync, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	// CONFIRM_UPLOAD_SIZE asks before a push much larger than expected
+	if err := sm.confirmUpload(filesToSync, result); err != nil {
+		return nil, err
+	}
+	
+	// Create progress bar
+	progressBar := sm.newProgressBar(len(filesToSync))
+	transferStart := time.Now()
+	
+	// Second pass: sync files with progress bar
+	skippedCount := 0
+	syncedCount := 0
+	
+	// With HASH_CACHE, files whose hash matches the last sync skip the remote check entirely
+	var storedHashes, newHashes map[string]string
+	if sm.config.HashCache {
+		storedHashes = sm.loadHashCache(remotePath)
+		newHashes = make(map[string]string, len(filesToSync))
+	}
+	
+	for i, file := range filesToSync {
+		mode := sm.remoteFileMode(file.relPath, file.info)
+		
+		// Check if file needs to be updated; ALWAYS_UPLOAD files and the uploads of an applied plan skip the comparison
+		needsUpdate := true
+		forced := matchPatternList(file.relPath, file.info, sm.config.AlwaysUploadPatterns, sm.config.IgnoreCase)
+		if forced {
+			result.AlwaysUploaded++
+		}
+		forced = forced || sm.config.ApplyPlan != nil
+		
+		cacheKey := filepath.ToSlash(file.relPath)
+		localHash := ""
+		if newHashes != nil {
+			if hash, err := localSHA256(file.localPath); err != nil {
+				sm.verbosef("Not caching the hash of %s: %v", file.relPath, err)
+			} else {
+				localHash = hash
+			}
+		}
+		if localHash != "" && !forced && storedHashes[cacheKey] == localHash {
+			newHashes[cacheKey] = localHash
+			skippedCount++
+			result.HashCacheHits++
+			progressBar.Update(i+1, fmt.Sprintf("Skipped (hash unchanged): %s", file.relPath))
+			continue
+		}
+		
+		remoteInfo, err := sm.remoteStat(file.remotePath)
+		if err == nil && isSymlink(remoteInfo) {
+			// With SYMLINK_STAT: nofollow a remote symlink is never written through
+			result.SymlinksSkipped++
+			skippedCount++
+			progressBar.Update(i+1, fmt.Sprintf("Skipped (remote symlink): %s", file.relPath))
+			continue
+		}
+		if err == nil && !forced {
+			// File exists, check if it needs updating using the COMPARE strategy
+			if sm.upToDate(file, file.info, remoteInfo) {
+				needsUpdate = false
+				skippedCount++
+				progressBar.Update(i+1, fmt.Sprintf("Skipped (up-to-date): %s", file.relPath))
+				if localHash != "" {
+					newHashes[cacheKey] = localHash
+				}
+				
+				// An unchanged file may still be missing an EXECUTABLE bit from an
+				// earlier push, or have a mode other than the one it is pinned to
+				ruled := sm.modePinned(file.relPath, file.info)
+				missingExec := mode&0111 != 0 && remoteInfo.Mode().Perm()&0111 != mode&0111
+				if !sm.config.DryRun && (missingExec || ruled && remoteInfo.Mode().Perm() != mode) {
+					sm.sftpClient.Chmod(file.remotePath, mode)
+				}
+			}
+		}
+		
+		// UPLOAD_NEWER_ONLY leaves remote files that aren't older than the local
+		// ones, as they may hold changes made on the server
+		if needsUpdate && err == nil && !forced && sm.config.UploadNewerOnly && !sm.config.Force && !localIsNewer(file.info, remoteInfo) {
+			skippedCount++
+			result.Conflicts = append(result.Conflicts, filepath.ToSlash(file.relPath))
+			progressBar.Update(i+1, fmt.Sprintf("Skipped (remote is newer): %s", file.relPath))
+			continue
+		}
+		
+		// SHOW_DIFF prints what changed in small text files, and lets an interactive push skip them
+		if needsUpdate && err == nil && sm.config.ShowDiff && !sm.showDiff(file, remoteInfo) {
+			skippedCount++
+			result.Declined = append(result.Declined, filepath.ToSlash(file.relPath))
+			progressBar.Update(i+1, fmt.Sprintf("Skipped (declined): %s", file.relPath))
+			continue
+		}
+		
+		if needsUpdate && sm.config.DryRun {
+			log.Printf("🔎 Would upload: %s (%d bytes)", file.relPath, file.info.Size())
+			planUpload(folderPlan, file)
+			syncedCount++
+			sm.recordChange(file.relPath)
+			result.Bytes += file.info.Size()
+		} else if needsUpdate {
+			// BACKUP_ON_OVERWRITE keeps the current remote copy for a manual rollback
+			if err == nil && matchPatternList(file.relPath, file.info, sm.config.BackupPatterns, sm.config.IgnoreCase) {
+				if backupErr := sm.backupRemoteFile(file.remotePath); backupErr != nil {
+					progressBar.Complete()
+					return nil, fmt.Errorf("failed to back up %s before overwriting it: %w", file.remotePath, backupErr)
+				}
+				result.BackedUp++
+			}
+			progressBar.Update(i+1, fmt.Sprintf("Uploading: %s (%d bytes)", file.relPath, file.info.Size()))
+			if err == nil && sm.useDelta(file.info) {
+				if sent, deltaErr := sm.deltaUpload(file.localPath, file.remotePath, remoteInfo, mode); deltaErr == nil {
+					syncedCount++
+					sm.recordChange(file.relPath)
+					result.Bytes += sent
+					result.DeltaFiles++
+					result.DeltaSaved += file.info.Size() - sent
+					if localHash != "" {
+						newHashes[cacheKey] = localHash
+					}
+					continue
+				} else {
+					// Anything unexpected (no dd/md5sum on the remote, etc.) falls back to a full upload
+					sm.verbosef("Sending %s in full, as the delta failed: %v", file.relPath, deltaErr)
+				}
+			}
+			if err := sm.uploadFile(file.localPath, file.remotePath, mode); err != nil {
+				// SKIP_LOCKED leaves files another program holds open on Windows
+				// for the next push, rather than failing this one
+				if sm.config.SkipLocked && isLockedFile(err) {
+					skippedCount++
+					result.Locked = append(result.Locked, filepath.ToSlash(file.relPath))
+					progressBar.Update(i+1, fmt.Sprintf("Skipped (locked): %s", file.relPath))
+					continue
 				}
+				progressBar.Complete()
+				return nil, fmt.Errorf("failed to upload %s: %w", file.localPath, err)
 			}
-		}
-		
-		// For file patterns (containing wildcards)
-		if strings.Contains(pattern, "*") {
-			if matchPattern(baseName, pattern) {
-				return true
+			syncedCount++
+			sm.recordChange(file.relPath)
+			result.Bytes += file.info.Size()
+			if localHash != "" {
+				newHashes[cacheKey] = localHash
 			}
+		} else {
+			progressBar.Update(i+1, fmt.Sprintf("Checking: %s", file.relPath))
 		}
 	}
 	
-	return false
-}
-
-// matchPattern checks if a string matches a simple glob pattern
-func matchPattern(str, pattern string) bool {
-	// Handle simple wildcard patterns
-	if strings.Contains(pattern, "*") {
-		// Use filepath.Match for glob pattern matching
-		matched, _ := filepath.Match(pattern, str)
-		return matched
+	progressBar.Complete()
+	sm.timePhase("transfer", transferStart)
+	if sm.config.DryRun {
+		log.Printf("Dry run: %d files checked, %d would be uploaded, %d already up-to-date",
+			len(filesToSync), syncedCount, skippedCount)
+	} else {
+		log.Printf("File synchronization completed: %d files checked, %d uploaded, %d already up-to-date", 
+			len(filesToSync), syncedCount, skippedCount)
 	}
-	// Exact match
-	return str == pattern
-}
-
-// SyncFiles synchronizes local folder to remote folder
-func (sm *SyncManager) SyncFiles() error {
-	log.Printf("Starting file synchronization from '%s' to '%s'...", sm.config.LocalFolder, sm.config.RemoteFolder)
-	
-	if len(sm.config.IgnorePatterns) > 0 {
-		log.Printf("Ignoring patterns: %s", strings.Join(sm.config.IgnorePatterns, ", "))
+	if ignored > 0 {
+		log.Printf("(%d files/directories ignored based on patterns)", ignored)
 	}
-	
-	// Check if local folder exists
-	localInfo, err := os.Stat(sm.config.LocalFolder)
-	if err != nil {
-		return fmt.Errorf("local folder '%s' does not exist or cannot be accessed: %w", sm.config.LocalFolder, err)
+	if result.AlwaysUploaded > 0 {
+		log.Printf("(%d files uploaded unconditionally via ALWAYS_UPLOAD)", result.AlwaysUploaded)
 	}
-	if !localInfo.IsDir() {
-		return fmt.Errorf("local path '%s' is not a directory", sm.config.LocalFolder)
+	if len(result.Declined) > 0 {
+		log.Printf("(%d changed files not uploaded after reviewing their diff: %s)", len(result.Declined), strings.Join(result.Declined, ", "))
+	}
+	if len(result.Conflicts) > 0 {
+		log.Printf("⚠️  %d remote files are not older than the local ones and were left as they are (UPLOAD_NEWER_ONLY; --force overwrites them): %s", len(result.Conflicts), strings.Join(result.Conflicts, ", "))
+	}
+	if len(result.Locked) > 0 {
+		log.Printf("⚠️  %d files are locked by another program and were not uploaded (SKIP_LOCKED): %s", len(result.Locked), strings.Join(result.Locked, ", "))
+	}
+	if len(result.Unpicked) > 0 {
+		log.Printf("(%d changed files not picked for upload: %s)", len(result.Unpicked), strings.Join(result.Unpicked, ", "))
+	}
+	if result.BackedUp > 0 {
+		log.Printf("(%d overwritten files backed up via BACKUP_ON_OVERWRITE)", result.BackedUp)
+	}
+	if result.DeltaFiles > 0 {
+		log.Printf("(%d files sent as deltas, %d bytes saved)", result.DeltaFiles, result.DeltaSaved)
+	}
+	if result.HashCacheHits > 0 {
+		log.Printf("(%d unchanged files skipped via the hash cache)", result.HashCacheHits)
+	}
+	if result.LineEndingsConverted > 0 {
+		log.Printf("(%d text files converted to LF line endings via LINE_ENDINGS)", result.LineEndingsConverted)
 	}
 	
-	// Expand tilde in remote folder path
-	remotePath := sm.config.RemoteFolder
-	if strings.HasPrefix(remotePath, "~/") {
-		homeDir, err := sm.getRemoteHomeDir()
+	result.Checked = len(filesToSync)
+	result.Transferred = syncedCount
+	result.Skipped = skippedCount
+	
+	if sm.config.VerifyAfter != "" && !sm.config.DryRun {
+		verifyStart := time.Now()
+		err := sm.verifyFolder(filesToSync, result)
+		sm.timePhase("verify", verifyStart)
 		if err != nil {
-			return fmt.Errorf("failed to get remote home directory: %w", err)
+			sm.recordFolder(result)
+			return nil, err
 		}
-		remotePath = filepath.Join(homeDir, remotePath[2:])
 	}
-	log.Printf("Resolved remote path: %s", remotePath)
 	
-	// Check if remote directory exists and create if needed
-	if _, err := sm.sftpClient.Stat(remotePath); err != nil {
-		log.Printf("Remote directory doesn't exist, creating: %s", remotePath)
-		if err := sm.sftpClient.MkdirAll(remotePath); err != nil {
-			return fmt.Errorf("failed to create remote directory %s: %w", remotePath, err)
+	// A cache that fails to save is only rebuilt next time, so it doesn't fail the push.
+	// An applied plan only saw its own files, so the cache is left as it was.
+	if newHashes != nil && !sm.config.DryRun && sm.config.ApplyPlan == nil {
+		if err := sm.saveHashCache(remotePath, newHashes); err != nil {
+			log.Printf("⚠️  %v", err)
 		}
-		log.Printf("✅ Successfully created remote directory: %s", remotePath)
-	} else {
-		log.Printf("Remote directory exists: %s", remotePath)
-	}
-	
-	// First pass: count total files to sync
-	log.Print("Scanning local directory...")
-	var filesToSync []struct {
-		localPath  string
-		remotePath string
-		relPath    string
-		info       os.FileInfo
 	}
-	ignored := 0
-	
-	err = filepath.Walk(sm.config.LocalFolder, func(localPath string, info os.FileInfo, err error) error {
+	return result, nil
+}
+
+// scanLocalFolder walks a local folder to push, applying the ignore patterns,
+// MAX_DEPTH, OWNED_MANIFEST (for the primary folder), symlink and SINCE rules.
+// Directories are passed to onDir, if set, with their remote path; files are
+// returned for comparison. Ignored and skipped entries are counted in result.
+func (sm *SyncManager) scanLocalFolder(localFolder, localRoot, remotePath string, primary bool, ignorePatterns []string, result *FolderResult, onDir func(remoteDir string) error) ([]syncFile, error) {
+	var files []syncFile
+	err := filepath.Walk(localFolder, func(localPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 		
 		// Get relative path
-		relPath, err := filepath.Rel(sm.config.LocalFolder, localPath)
+		relPath, err := filepath.Rel(localFolder, localPath)
 		if err != nil {
 			return err
 		}
@@ -362,8 +2223,8 @@ func (sm *SyncManager) SyncFiles() error {
 		}
 		
 		// Check if file/directory should be ignored
-		if sm.shouldIgnore(relPath, info) {
-			ignored++
+		if sm.shouldIgnore(relPath, info, ignorePatterns) {
+			result.Ignored++
 			if info.IsDir() {
 				// Log when skipping a directory for debugging
 				if relPath == "node_modules" || strings.Contains(relPath, "node_modules") {
@@ -373,96 +2234,91 @@ func (sm *SyncManager) SyncFiles() error {
 			}
 			return nil
 		}
+		if isPartialDownload(localPath, info) {
+			result.Ignored++
+			sm.verbosef("Skipping %s: the partial download of an interrupted pull", relPath)
+			return nil
+		}
+		
+		// Don't descend past MAX_DEPTH; the whole subtree counts as ignored
+		if sm.beyondMaxDepth(relPath, info) {
+			result.Ignored++
+			return filepath.SkipDir
+		}
+		
+		// Paths another tool owns in the primary folder are never overwritten
+		if primary && sm.ownedByOther(filepath.ToSlash(relPath)) {
+			result.Ignored++
+			result.Owned++
+			sm.verbosef("Skipping %s: owned by another tool", relPath)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		// Symlinks are uploaded as the file they point to, if that stays inside
+		// the folder, or skipped with SYMLINK_STAT: nofollow
+		if info.Mode()&os.ModeSymlink != 0 && sm.config.SymlinkStat == "nofollow" {
+			result.SymlinksSkipped++
+			sm.verbosef("Not following symlink %s", relPath)
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := sm.followSymlink(localRoot, localPath, relPath, result)
+			if err != nil {
+				return err
+			}
+			if target == nil {
+				return nil
+			}
+			info = target
+		}
 		
 		if !info.IsDir() {
+			// ALLOWED_EXTENSIONS leaves out every file it doesn't list
+			if !sm.allowedByExtension(relPath, info, primary) {
+				result.NotAllowed++
+				return nil
+			}
+			
+			// Skip files that haven't changed since the SINCE cutoff
+			if !sm.config.Since.IsZero() && info.ModTime().Before(sm.config.Since) {
+				result.NotModified++
+				return nil
+			}
+			
 			remoteFilePath := filepath.Join(remotePath, relPath)
 			remoteFilePath = filepath.ToSlash(remoteFilePath)
 			
-			filesToSync = append(filesToSync, struct {
-				localPath  string
-				remotePath string
-				relPath    string
-				info       os.FileInfo
-			}{
+			files = append(files, syncFile{
 				localPath:  localPath,
 				remotePath: remoteFilePath,
 				relPath:    relPath,
 				info:       info,
 			})
-		} else {
-			// Create directory on remote
-			remoteFilePath := filepath.Join(remotePath, relPath)
-			remoteFilePath = filepath.ToSlash(remoteFilePath)
-			sm.sftpClient.MkdirAll(remoteFilePath)
+			
+			// Stop early rather than walk all of a folder that was never meant to be synced
+			if sm.config.MaxFiles > 0 && len(files) > sm.config.MaxFiles {
+				return fmt.Errorf("%s has more than %d files (MAX_FILES). Check that LOCAL_FOLDER points at the project and that IGNORE covers directories such as node_modules, or raise MAX_FILES (0 turns the limit off)",
+					localFolder, sm.config.MaxFiles)
+			}
+		} else if onDir != nil {
+			remoteDirPath := filepath.ToSlash(filepath.Join(remotePath, relPath))
+			if err := onDir(remoteDirPath); err != nil {
+				return err
+			}
 		}
 		
 		return nil
 	})
-	
-	if err != nil {
-		return fmt.Errorf("failed to scan local directory: %w", err)
-	}
-	
-	if len(filesToSync) == 0 {
-		log.Println("No files to sync")
-		if ignored > 0 {
-			log.Printf("(%d files/directories ignored based on patterns)", ignored)
-		}
-		return nil
-	}
-	
-	log.Printf("Found %d files to check (%d ignored)", len(filesToSync), ignored)
-	
-	// Create progress bar
-	progressBar := NewProgressBar(len(filesToSync))
-	
-	// Second pass: sync files with progress bar
-	skippedCount := 0
-	syncedCount := 0
-	
-	for i, file := range filesToSync {
-		// Check if file needs to be updated
-		needsUpdate := true
-		remoteInfo, err := sm.sftpClient.Stat(file.remotePath)
-		if err == nil {
-			// File exists, check if it needs updating (simple size and time comparison)
-			if remoteInfo.Size() == file.info.Size() && remoteInfo.ModTime().After(file.info.ModTime().Add(-time.Second)) {
-				needsUpdate = false
-				skippedCount++
-				progressBar.Update(i+1, fmt.Sprintf("Skipped (up-to-date): %s", file.relPath))
-			}
-		}
-		
-		if needsUpdate {
-			progressBar.Update(i+1, fmt.Sprintf("Uploading: %s (%d bytes)", file.relPath, file.info.Size()))
-			if err := sm.uploadFile(file.localPath, file.remotePath); err != nil {
-				progressBar.Complete()
-				return fmt.Errorf("failed to upload %s: %w", file.localPath, err)
-			}
-			syncedCount++
-		} else {
-			progressBar.Update(i+1, fmt.Sprintf("Checking: %s", file.relPath))
-		}
-	}
-	
-	progressBar.Complete()
-	log.Printf("File synchronization completed: %d files checked, %d uploaded, %d already up-to-date", 
-		len(filesToSync), syncedCount, skippedCount)
-	if ignored > 0 {
-		log.Printf("(%d files/directories ignored based on patterns)", ignored)
-	}
-	
-	// Check if Dockerfile exists in the synced files
-	dockerfilePath := filepath.Join(sm.config.LocalFolder, "Dockerfile")
-	if _, err := os.Stat(dockerfilePath); os.IsNotExist(err) {
-		log.Printf("WARNING: No Dockerfile found in local folder '%s'", sm.config.LocalFolder)
-	}
-	
-	return nil
+	return files, err
 }
 
 // PullFiles downloads files from remote to local (reverse sync)
-func (sm *SyncManager) PullFiles() error {
+func (sm *SyncManager) PullFiles() (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+	
 	log.Printf("Starting file pull from '%s' to '%s'...", sm.config.RemoteFolder, sm.config.LocalFolder)
 	
 	if len(sm.config.IgnorePatterns) > 0 {
@@ -470,15 +2326,10 @@ func (sm *SyncManager) PullFiles() error {
 	}
 	
 	// Expand tilde in remote folder path
-	remotePath := sm.config.RemoteFolder
-	if strings.HasPrefix(remotePath, "~/") {
-		homeDir, err := sm.getRemoteHomeDir()
-		if err != nil {
-			return fmt.Errorf("failed to get remote home directory: %w", err)
-		}
-		remotePath = filepath.Join(homeDir, remotePath[2:])
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
 	}
-	remotePath = filepath.ToSlash(remotePath)
 	log.Printf("Resolved remote path: %s", remotePath)
 	
 	// Check if remote directory exists
@@ -497,13 +2348,11 @@ func (sm *SyncManager) PullFiles() error {
 	
 	// Walk through remote directory and pull files
 	log.Print("Scanning remote directory...")
-	var filesToPull []struct {
-		localPath  string
-		remotePath string
-		relPath    string
-		info       os.FileInfo
-	}
+	scanStart := time.Now()
+	var filesToPull []syncFile
 	ignored := 0
+	symlinksSkipped := 0
+	notAllowed := 0
 	
 	// Use SFTP Walker to traverse remote directory
 	walker := sm.sftpClient.Walk(remotePath)
@@ -528,20 +2377,46 @@ func (sm *SyncManager) PullFiles() error {
 		}
 		
 		// Check if file/directory should be ignored
-		if sm.shouldIgnore(relPath, stat) {
+		if sm.shouldIgnore(relPath, stat, sm.config.IgnorePatterns) {
+			ignored++
+			continue
+		}
+		
+		// Don't descend past MAX_DEPTH; the whole subtree counts as ignored
+		if sm.beyondMaxDepth(relPath, stat) {
 			ignored++
+			walker.SkipDir()
 			continue
 		}
 		
+		// The walk doesn't follow symlinks; with SYMLINK_STAT: follow they are
+		// pulled as the file they point to, like a push uploads local ones
+		if isSymlink(stat) {
+			if sm.config.SymlinkStat == "nofollow" {
+				symlinksSkipped++
+				sm.verbosef("Not following remote symlink %s", relPath)
+				continue
+			}
+			target, err := sm.remoteStat(remoteFilePath)
+			if err != nil {
+				log.Printf("⚠️  Skipping broken remote symlink %s: %v", relPath, err)
+				continue
+			}
+			if target.IsDir() {
+				log.Printf("⚠️  Skipping remote symlinked directory %s", relPath)
+				continue
+			}
+			stat = target
+		}
+		
 		if !stat.IsDir() {
+			if !sm.allowedByExtension(relPath, stat, true) {
+				notAllowed++
+				continue
+			}
 			localPath := filepath.Join(sm.config.LocalFolder, filepath.FromSlash(relPath))
 			
-			filesToPull = append(filesToPull, struct {
-				localPath  string
-				remotePath string
-				relPath    string
-				info       os.FileInfo
-			}{
+			filesToPull = append(filesToPull, syncFile{
 				localPath:  localPath,
 				remotePath: remoteFilePath,
 				relPath:    relPath,
@@ -553,6 +2428,13 @@ func (sm *SyncManager) PullFiles() error {
 			os.MkdirAll(localDirPath, 0755)
 		}
 	}
+	sm.timePhase("scan", scanStart)
+	if symlinksSkipped > 0 {
+		log.Printf("(%d remote symlinks not followed, SYMLINK_STAT: nofollow)", symlinksSkipped)
+	}
+	if notAllowed > 0 {
+		log.Printf("(%d files excluded by ALLOWED_EXTENSIONS)", notAllowed)
+	}
 	
 	if len(filesToPull) == 0 {
 		log.Println("No files to pull")
@@ -565,95 +2447,249 @@ func (sm *SyncManager) PullFiles() error {
 	log.Printf("Found %d files to download (%d ignored)", len(filesToPull), ignored)
 	
 	// Create progress bar
-	progressBar := NewProgressBar(len(filesToPull))
+	progressBar := sm.newProgressBar(len(filesToPull))
+	transferStart := time.Now()
 	
-	// Pull files with progress bar
+	// Pull files with progress bar, PARALLEL_DOWNLOADS at a time
 	downloadedCount := 0
 	skippedCount := 0
-	
-	for i, file := range filesToPull {
-		// Check if file needs to be updated
-		needsUpdate := true
-		localInfo, err := os.Stat(file.localPath)
-		if err == nil {
-			// File exists, check if it needs updating (simple size comparison)
-			if localInfo.Size() == file.info.Size() && localInfo.ModTime().After(file.info.ModTime().Add(-time.Second)) {
-				needsUpdate = false
-				skippedCount++
-				progressBar.Update(i+1, fmt.Sprintf("Skipped (up-to-date): %s", file.relPath))
-			}
-		}
-		
-		if needsUpdate {
-			progressBar.Update(i+1, fmt.Sprintf("Downloading: %s (%d bytes)", file.relPath, file.info.Size()))
-			if err := sm.downloadFile(file.remotePath, file.localPath); err != nil {
-				progressBar.Complete()
-				return fmt.Errorf("failed to download %s: %w", file.remotePath, err)
+	result := &FolderResult{
+		LocalFolder:  sm.config.LocalFolder,
+		RemoteFolder: sm.config.RemoteFolder,
+		Ignored:      ignored,
+		SymlinksSkipped: symlinksSkipped,
+	}
+	defer sm.recordFolder(result)
+	
+	// Each file's error is kept in its slot, so failures are reported in tree order
+	var mu sync.Mutex
+	errs := make([]error, len(filesToPull))
+	jobs := make(chan int)
+	var workers sync.WaitGroup
+	for w := 0; w < sm.config.ParallelDownloads; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range jobs {
+				file := filesToPull[i]
+				
+				// Check if file needs to be updated, using the COMPARE strategy
+				localInfo, err := sm.localStat(file.localPath)
+				if err == nil && isSymlink(localInfo) {
+					// With SYMLINK_STAT: nofollow a local symlink is never replaced
+					mu.Lock()
+					skippedCount++
+					result.SymlinksSkipped++
+					mu.Unlock()
+					progressBar.Increment(fmt.Sprintf("Skipped (local symlink): %s", file.relPath))
+					continue
+				}
+				if err == nil && sm.upToDate(file, file.info, localInfo) {
+					// An unchanged file still gets the executable bits PULL_EXECUTABLE asks for
+					if matchPatternList(file.relPath, file.info, sm.config.PullExecutablePatterns, sm.config.IgnoreCase) && localInfo.Mode().Perm()&0111 != 0111 {
+						os.Chmod(file.localPath, localInfo.Mode().Perm()|0111)
+					}
+					mu.Lock()
+					skippedCount++
+					mu.Unlock()
+					progressBar.Increment(fmt.Sprintf("Skipped (up-to-date): %s", file.relPath))
+					continue
+				}
+				
+				written, resumed, err := sm.downloadFile(file.remotePath, file.localPath, file.relPath)
+				mu.Lock()
+				result.Bytes += written
+				if err != nil {
+					// Keep going so one bad file doesn't stop the rest of the tree
+					errs[i] = err
+				} else {
+					if resumed {
+						result.Resumed++
+					}
+					downloadedCount++
+					sm.recordChange(file.relPath)
+					result.Transferred = downloadedCount
+				}
+				mu.Unlock()
+				progressBar.Increment(fmt.Sprintf("Downloaded: %s (%d bytes)", file.relPath, file.info.Size()))
 			}
-			downloadedCount++
-		} else {
-			progressBar.Update(i+1, fmt.Sprintf("Checking: %s", file.relPath))
+		}()
+	}
+	for i := range filesToPull {
+		jobs <- i
+	}
+	close(jobs)
+	workers.Wait()
+	
+	var failures []string
+	for i, err := range errs {
+		if err != nil {
+			result.Failed = append(result.Failed, filesToPull[i].relPath)
+			failures = append(failures, fmt.Sprintf("%s: %v", filesToPull[i].relPath, err))
 		}
 	}
 	
 	progressBar.Complete()
+	sm.timePhase("transfer", transferStart)
 	log.Printf("File pull completed: %d files checked, %d downloaded, %d already up-to-date", 
 		len(filesToPull), downloadedCount, skippedCount)
+	if result.Resumed > 0 {
+		log.Printf("(%d interrupted downloads resumed)", result.Resumed)
+	}
 	if ignored > 0 {
 		log.Printf("(%d files/directories ignored based on patterns)", ignored)
 	}
 	
+	result.Checked = len(filesToPull)
+	result.Skipped = skippedCount
+	sm.printChanges("downloaded")
+	if len(failures) > 0 {
+		log.Printf("❌ %d files could not be downloaded:", len(failures))
+		for _, failure := range failures {
+			log.Printf("   %s", failure)
+		}
+		return fmt.Errorf("%d of %d downloads failed", len(failures), len(filesToPull)-skippedCount)
+	}
 	return nil
 }
 
-// downloadFile downloads a single file via SFTP
-func (sm *SyncManager) downloadFile(remotePath, localPath string) error {
+// partialDownloadSuffix marks a file a pull is downloading into
+const partialDownloadSuffix = ".part"
+
+// isPartialDownload reports whether a local file is what an interrupted pull
+// left behind: a .part file next to the file it was to replace. Pushes skip
+// them, so a half-downloaded file never reaches the server.
+func isPartialDownload(localPath string, info os.FileInfo) bool {
+	if info.IsDir() || !strings.HasSuffix(localPath, partialDownloadSuffix) {
+		return false
+	}
+	_, err := os.Lstat(strings.TrimSuffix(localPath, partialDownloadSuffix))
+	return err == nil
+}
+
+// downloadFile downloads a single file via SFTP, returning the number of bytes
+// transferred and whether an earlier partial download was resumed
+func (sm *SyncManager) downloadFile(remotePath, localPath, relPath string) (int64, bool, error) {
 	// Create directory for the file if it doesn't exist
 	dir := filepath.Dir(localPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+		return 0, false, fmt.Errorf("failed to create directory: %w", err)
 	}
 	
 	// Open remote file
 	remoteFile, err := sm.sftpClient.Open(remotePath)
 	if err != nil {
-		return fmt.Errorf("failed to open remote file: %w", err)
+		return 0, false, fmt.Errorf("failed to open remote file: %w", err)
 	}
 	defer remoteFile.Close()
 	
 	// Get remote file info
 	info, err := remoteFile.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to stat remote file: %w", err)
+		return 0, false, fmt.Errorf("failed to stat remote file: %w", err)
+	}
+	
+	// Download into a .part file that only replaces the real one once complete.
+	// A .part left by an interrupted pull is resumed from where it stopped,
+	// unless the remote file has changed since it was last written to.
+	partPath := localPath + partialDownloadSuffix
+	var offset int64
+	if partInfo, err := os.Stat(partPath); err == nil && partInfo.Size() < info.Size() && !info.ModTime().After(partInfo.ModTime()) {
+		offset = partInfo.Size()
 	}
 	
-	// Create local file
-	localFile, err := os.Create(localPath)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+		if _, err := remoteFile.Seek(offset, io.SeekStart); err != nil {
+			return 0, false, fmt.Errorf("failed to seek remote file: %w", err)
+		}
+	}
+	localFile, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to create local file: %w", err)
+		return 0, false, fmt.Errorf("failed to create local file: %w", err)
 	}
-	defer localFile.Close()
 	
 	// Copy file contents
-	_, err = io.Copy(localFile, remoteFile)
+	written, err := sm.copyFile(localFile, remoteFile)
+	if closeErr := localFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		return fmt.Errorf("failed to copy file contents: %w", err)
+		return written, offset > 0, fmt.Errorf("failed to copy file contents (partial download kept in %s): %w", partPath, err)
+	}
+	
+	if err := os.Rename(partPath, localPath); err != nil {
+		return written, offset > 0, fmt.Errorf("failed to move download into place: %w", err)
 	}
 	
-	// Try to preserve file permissions
-	if err := os.Chmod(localPath, info.Mode()); err != nil {
+	// Try to preserve file permissions, adding +x for PULL_EXECUTABLE
+	if err := os.Chmod(localPath, sm.localFileMode(relPath, info)); err != nil {
 		// Silently ignore permission errors on Windows
 	}
 	
-	return nil
+	return written, offset > 0, nil
+}
+
+// ensureRemoteDir creates a remote directory and any parents. If creation fails
+// because the directory appeared concurrently (e.g. another process created it),
+// re-stating the path turns that spurious error into success.
+func (sm *SyncManager) ensureRemoteDir(remotePath string) error {
+	err := sm.sftpClient.MkdirAll(remotePath)
+	if err == nil {
+		return nil
+	}
+	if info, statErr := sm.sftpClient.Stat(remotePath); statErr == nil && info.IsDir() {
+		return nil
+	}
+	if errors.Is(err, os.ErrPermission) {
+		return sm.permissionError(remotePath, err)
+	}
+	return err
+}
+
+// remotePermissionError reports a remote directory the SSH user isn't allowed to create
+type remotePermissionError struct {
+	Dir    string // directory that couldn't be created
+	Parent string // closest existing ancestor, which isn't writable
+	User   string // the remote "id" output
+	Login  string // SSH username, for the suggested chown
+	Err    error
+}
+
+func (e *remotePermissionError) Error() string {
+	return fmt.Sprintf("permission denied creating %s: %s is not writable by %s. Create the folder as root and hand it over (sudo mkdir -p %s && sudo chown %s %s), or choose a REMOTE_FOLDER the user owns",
+		e.Dir, e.Parent, e.User, e.Dir, e.Login, e.Dir)
+}
+
+func (e *remotePermissionError) Unwrap() error {
+	return e.Err
+}
+
+// permissionError explains a permission-denied mkdir: the SFTP error doesn't say
+// which directory failed, so find the closest existing ancestor and who we are
+func (sm *SyncManager) permissionError(remotePath string, err error) error {
+	parent := path.Dir(remotePath)
+	for parent != "/" && parent != "." {
+		if _, statErr := sm.sftpClient.Stat(parent); statErr == nil {
+			break
+		}
+		parent = path.Dir(parent)
+	}
+	
+	user := sm.config.SSHUsername
+	if output, idErr := sm.executeRemoteCommandWithOutput("id", false); idErr == nil && strings.TrimSpace(output) != "" {
+		user = strings.TrimSpace(output)
+	}
+	return &remotePermissionError{Dir: remotePath, Parent: parent, User: user, Login: sm.config.SSHUsername, Err: err}
 }
 
 // uploadFile uploads a single file via SFTP
-func (sm *SyncManager) uploadFile(localPath, remotePath string) error {
+func (sm *SyncManager) uploadFile(localPath, remotePath string, mode os.FileMode) error {
 	// Create remote directory for the file if it doesn't exist
 	remoteDir := filepath.Dir(remotePath)
 	remoteDir = filepath.ToSlash(remoteDir)
-	if err := sm.sftpClient.MkdirAll(remoteDir); err != nil {
+	if err := sm.ensureRemoteDir(remoteDir); err != nil {
 		return fmt.Errorf("failed to create remote directory: %w", err)
 	}
 	
@@ -664,12 +2700,6 @@ func (sm *SyncManager) uploadFile(localPath, remotePath string) error {
 	}
 	defer localFile.Close()
 	
-	// Get file info for size
-	info, err := localFile.Stat()
-	if err != nil {
-		return fmt.Errorf("failed to stat local file: %w", err)
-	}
-	
 	// Create remote file
 	remoteFile, err := sm.sftpClient.Create(remotePath)
 	if err != nil {
@@ -677,112 +2707,400 @@ func (sm *SyncManager) uploadFile(localPath, remotePath string) error {
 	}
 	defer remoteFile.Close()
 	
-	// Copy file contents
-	_, err = io.Copy(remoteFile, localFile)
+	// Copy file contents, in parallel chunks for large files if CONCURRENCY_PER_FILE is set
+	if info, statErr := localFile.Stat(); statErr == nil && sm.config.ConcurrencyPerFile > 1 && info.Size() >= concurrentTransferThreshold {
+		_, err = remoteFile.ReadFromWithConcurrency(localFile, sm.config.ConcurrencyPerFile)
+	} else {
+		_, err = sm.copyFile(remoteFile, localFile)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to copy file contents: %w", err)
 	}
 	
 	// Copy file permissions
-	if err := remoteFile.Chmod(info.Mode()); err != nil {
+	if err := remoteFile.Chmod(mode); err != nil {
 		// Silently ignore permission errors
 	}
 	
+	// With TRANSFER_PROTOCOL: scp the data is only sent when the file is closed
+	if err := remoteFile.Close(); err != nil {
+		return fmt.Errorf("failed to finish remote file: %w", err)
+	}
 	return nil
 }
 
-// getRemoteHomeDir gets the remote home directory
+// copyFile copies file contents through a COPY_BUFFER_SIZE buffer when one
+// is set. The wrappers hide ReadFrom and WriteTo, which io.CopyBuffer would
+// otherwise use instead of the buffer.
+func (sm *SyncManager) copyFile(dst io.Writer, src io.Reader) (int64, error) {
+	if sm.config.CopyBufferSize == 0 {
+		return io.Copy(dst, src)
+	}
+	buf := make([]byte, sm.config.CopyBufferSize)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, buf)
+}
+
+// newSession opens an SSH session, waiting for a free slot if MAX_SSH_SESSIONS
+// sessions are already open. Sessions must be closed with closeSession.
+func (sm *SyncManager) newSession() (remoteSession, error) {
+	if sm.config.SyncOnly {
+		return nil, errExecDisabled
+	}
+	sm.acquireSession()
+	var session remoteSession
+	var err error
+	if sm.config.Transport == "local" {
+		session = newLocalSession()
+	} else if sm.controlSocket != "" {
+		session, err = newMuxSession(sm.controlSocket)
+	} else {
+		session, err = sm.sshClient.NewSession()
+	}
+	if err != nil {
+		sm.releaseSession()
+		return nil, err
+	}
+	return &auditedSession{remoteSession: session, sm: sm}, nil
+}
+
+// closeSession closes a session opened with newSession and frees its slot
+func (sm *SyncManager) closeSession(session remoteSession) {
+	session.Close()
+	sm.releaseSession()
+}
+
+// acquireSession waits for a free slot on this connection and, with
+// MAX_CONCURRENT_SESSIONS, for one of the slots shared by all targets
+func (sm *SyncManager) acquireSession() {
+	sm.sessionSlots <- struct{}{}
+	if sm.config.SharedSessions == nil {
+		return
+	}
+	select {
+	case sm.config.SharedSessions <- struct{}{}:
+	default:
+		sm.verbosef("Waiting for a session: all %d MAX_CONCURRENT_SESSIONS are open", cap(sm.config.SharedSessions))
+		start := time.Now()
+		sm.config.SharedSessions <- struct{}{}
+		sm.verbosef("Got a session after waiting %s", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// releaseSession frees the slots taken by acquireSession
+func (sm *SyncManager) releaseSession() {
+	if sm.config.SharedSessions != nil {
+		<-sm.config.SharedSessions
+	}
+	<-sm.sessionSlots
+}
+
+// getRemoteHomeDir gets the remote home directory: REMOTE_ROOT if set, else
+// $HOME from a remote command. SFTP-only and chrooted accounts can't run
+// commands, so if that fails the SFTP login directory is used instead.
 func (sm *SyncManager) getRemoteHomeDir() (string, error) {
-	session, err := sm.sshClient.NewSession()
+	if sm.homeDir != "" {
+		return sm.homeDir, nil
+	}
+	if sm.config.RemoteRoot != "" {
+		sm.homeDir = sm.config.RemoteRoot
+		return sm.homeDir, nil
+	}
+	
+	home, err := sm.execHomeDir()
+	if errors.Is(err, errExecDisabled) {
+		home, err = sm.sftpClient.Getwd()
+		if err != nil {
+			return "", fmt.Errorf("failed to get the SFTP login directory: %w", err)
+		}
+	} else if err != nil {
+		wd, wdErr := sm.sftpClient.Getwd()
+		if wdErr != nil {
+			return "", fmt.Errorf("%w (the SFTP login directory is unavailable too: %v)", err, wdErr)
+		}
+		log.Printf("⚠️  Could not run a remote command to find the home directory (%v); using the SFTP login directory %s", err, wd)
+		home = wd
+	}
+	sm.homeDir = home
+	return home, nil
+}
+
+// execHomeDir prints $HOME through an exec session
+func (sm *SyncManager) execHomeDir() (string, error) {
+	session, err := sm.newSession()
 	if err != nil {
 		return "", err
 	}
-	defer session.Close()
+	defer sm.closeSession(session)
 	
-	output, err := session.Output("echo $HOME")
+	// Login shells may print a MOTD or rc-file chatter around our output, so
+	// tag the line we want and pick it out
+	output, err := session.Output(`printf '` + homeMarker + `%s\n' "$HOME"`)
 	if err != nil {
 		return "", err
 	}
 	
-	return strings.TrimSpace(string(output)), nil
+	home := parseHomeDir(string(output))
+	if home == "" {
+		return "", fmt.Errorf("failed to determine remote home directory from output %q", string(output))
+	}
+	return home, nil
+}
+
+// homeMarker prefixes the $HOME line printed by execHomeDir
+const homeMarker = "__POOSHIT_HOME__="
+
+// parseHomeDir finds the home directory in execHomeDir's output, falling
+// back to the last non-empty line if the marker got lost
+func parseHomeDir(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r", ""), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if idx := strings.Index(lines[i], homeMarker); idx >= 0 {
+			return strings.TrimSpace(lines[i][idx+len(homeMarker):])
+		}
+	}
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" {
+			return line
+		}
+	}
+	return ""
+}
+
+// dockerCmd returns the command used to invoke Docker on the remote, with sudo unless DOCKER_SUDO is off.
+// REMOTE_CMD_PREFIX goes first, so e.g. nice applies to sudo and everything it runs.
+func (sm *SyncManager) dockerCmd() string {
+	return sm.dockerCmdWith("sudo")
+}
+
+// dockerCmdWith builds the Docker invocation around the given sudo command,
+// e.g. "sudo -n" for a check that must fail rather than wait for a password.
+// With DOCKER_SSH_USER, Docker runs as that user instead of root.
+func (sm *SyncManager) dockerCmdWith(sudo string) string {
+	docker := "docker"
+	if sm.config.DockerSSHUser != "" {
+		docker = sudo + " -u " + sm.config.DockerSSHUser + " docker"
+	} else if sm.config.DockerSudo {
+		docker = sudo + " docker"
+	}
+	if sm.config.RemoteCmdPrefix != "" {
+		docker = sm.config.RemoteCmdPrefix + " " + docker
+	}
+	return docker
 }
 
 // ExecuteDockerCommands runs Docker management commands on the remote server
-func (sm *SyncManager) ExecuteDockerCommands() error {
+func (sm *SyncManager) ExecuteDockerCommands() (err error) {
+	defer wrapError(&err, func(err error) error { return &DockerError{Err: err} })
+	
 	log.Println("\nManaging Docker containers and images...")
 	
 	// Expand tilde in remote folder path for Docker context
-	remotePath := sm.config.RemoteFolder
-	if strings.HasPrefix(remotePath, "~/") {
-		homeDir, err := sm.getRemoteHomeDir()
-		if err != nil {
-			return fmt.Errorf("failed to get remote home directory: %w", err)
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	
+	// With STRICT_DOCKERFILE a build that can't succeed stops here, before
+	// the running container is touched
+	if sm.config.StrictDockerfile && sm.contextTarball == "" {
+		if err := sm.checkRemoteDockerfile(remotePath); err != nil {
+			return err
 		}
-		remotePath = filepath.Join(homeDir, remotePath[2:])
 	}
-	remotePath = filepath.ToSlash(remotePath)
 	
-	// Check if Dockerfile exists in remote directory
-	checkCmd := fmt.Sprintf("test -f %s/Dockerfile && echo 'Dockerfile found' || echo 'Dockerfile NOT found'", remotePath)
-	if output, err := sm.executeRemoteCommandWithOutput(checkCmd, false); err == nil {
-		if strings.Contains(output, "NOT found") {
-			log.Printf("⚠️  WARNING: Dockerfile not found in %s", remotePath)
+	// Check if Dockerfile exists in remote directory; a tarball context isn't unpacked there
+	if !sm.config.NoDockerfileCheck && sm.contextTarball == "" {
+		checkCmd := fmt.Sprintf("test -f %s/Dockerfile && echo 'Dockerfile found' || echo 'Dockerfile NOT found'", remotePath)
+		if output, err := sm.executeRemoteCommandWithOutput(checkCmd, false); err == nil {
+			if strings.Contains(output, "NOT found") {
+				log.Printf("⚠️  WARNING: Dockerfile not found in %s", remotePath)
+			}
 		}
 	}
 	
+	if sm.config.DryRun {
+		sm.previewDockerCommands(remotePath)
+		return nil
+	}
+	
+	if sm.config.ZeroDowntime {
+		return sm.zeroDowntimeDeploy(remotePath)
+	}
+	
+	// VERIFY_IMAGE needs to know the image before it is removed
+	imageCheck, err := sm.startImageCheck()
+	if err != nil {
+		return err
+	}
+	
+	if err := sm.runHook("BEFORE_STOP_CMD", sm.config.BeforeStopCmd, remotePath); err != nil {
+		return err
+	}
+	
 	// Step 1: Stop and remove running containers using the image
+	stopStart := time.Now()
 	log.Printf("🐳 Stopping containers using image: %s", sm.config.DockerImageName)
-	cmd := fmt.Sprintf("sudo docker ps -aq --filter ancestor=%s | xargs -r sudo docker stop | xargs -r sudo docker rm",
-		sm.config.DockerImageName)
-	sm.executeRemoteCommandQuiet(cmd)
+	sm.executeRemoteCommandQuiet(sm.stopContainersCommand())
+	
+	// Step 2: Remove the Docker image (optional - the new build replaces the tag anyway)
+	if sm.config.RemoveOldImage {
+		log.Printf("🗑️  Removing old image: %s", sm.config.DockerImageName)
+		sm.executeRemoteCommandQuiet(sm.removeImageCommand())
+	} else {
+		log.Printf("⏭️  Keeping old image: %s (tag will be replaced by the new build)", sm.config.DockerImageName)
+	}
+	sm.timePhase("stop", stopStart)
 	
-	// Step 2: Remove the Docker image
-	log.Printf("🗑️  Removing old image: %s", sm.config.DockerImageName)
-	cmd = fmt.Sprintf("sudo docker rmi -f %s 2>/dev/null || true", sm.config.DockerImageName)
-	sm.executeRemoteCommandQuiet(cmd)
+	if err := sm.runHook("BETWEEN_STOP_AND_BUILD_CMD", sm.config.BetweenStopAndBuildCmd, remotePath); err != nil {
+		return err
+	}
 	
 	// Step 3: Build the new Docker image
 	log.Printf("🔨 Building new image: %s", sm.config.DockerImageName)
+	sm.result.ImageTag = sm.config.DockerImageName
 	
 	// Ensure the directory exists before building (safety check)
-	ensureDirCmd := fmt.Sprintf("mkdir -p %s", remotePath)
-	sm.executeRemoteCommandQuiet(ensureDirCmd)
+	sm.executeRemoteCommandQuiet(fmt.Sprintf("mkdir -p %s", remotePath))
 	
-	buildArgs := sm.config.DockerBuildArgs
-	if buildArgs == "" {
-		buildArgs = "-t"
-	}
-	cmd = fmt.Sprintf("cd %s && sudo docker build %s %s .", remotePath, buildArgs, sm.config.DockerImageName)
-	if err := sm.executeRemoteCommandWithProgress(cmd); err != nil {
+	buildStart := time.Now()
+	err = sm.executeRemoteCommandWithProgress(sm.buildCommand(remotePath))
+	sm.timePhase("build", buildStart)
+	if err != nil {
 		return fmt.Errorf("failed to build Docker image: %w", err)
 	}
+	if err := sm.verifyBuiltImage(imageCheck); err != nil {
+		return err
+	}
+	sm.tagBuild()
+	
+	if err := sm.runHook("BEFORE_RUN_CMD", sm.config.BeforeRunCmd, remotePath); err != nil {
+		return err
+	}
 	
 	// Step 4: Run the new container
 	log.Printf("▶️  Starting container: %s", sm.config.DockerImageName)
-	runArgs := sm.config.DockerRunArgs
-	if runArgs == "" {
-		runArgs = "-d"
-	}
-	cmd = fmt.Sprintf("sudo docker run %s %s", runArgs, sm.config.DockerImageName)
-	if output, err := sm.executeRemoteCommandWithOutput(cmd, true); err != nil {
+	runStart := time.Now()
+	output, err := sm.runContainer()
+	sm.timePhase("run", runStart)
+	if err != nil {
 		return fmt.Errorf("failed to run Docker container: %w", err)
-	} else if output != "" {
-		log.Printf("✅ Container started with ID: %s", strings.TrimSpace(output))
 	}
+	if err := sm.recordContainer(output); err != nil {
+		return err
+	}
+	if err := sm.runHook("AFTER_RUN_CMD", sm.config.AfterRunCmd, remotePath); err != nil {
+		return err
+	}
+	sm.pruneImages()
 	
 	log.Println("\n✨ Docker operations completed successfully!")
 	return nil
 }
 
+// removeImageCommand returns the command that force-removes the old image
+func (sm *SyncManager) removeImageCommand() string {
+	return fmt.Sprintf("%s rmi -f %s 2>/dev/null || true", sm.dockerCmd(), sm.config.DockerImageName)
+}
+
+// previewDockerCommands prints the remote commands a deploy would run, in
+// order, without running them, and adds them to the plan being made
+func (sm *SyncManager) previewDockerCommands(remotePath string) {
+	log.Println("🔎 Dry run - these Docker commands would run on the remote:")
+	commands := sm.dockerCommands(remotePath)
+	for i, cmd := range commands {
+		log.Printf("   %d. %s", i+1, cmd)
+	}
+	if sm.result.plan != nil {
+		sm.result.plan.Docker = commands
+	}
+}
+
+// dockerCommands lists the remote commands a deploy runs, in order
+func (sm *SyncManager) dockerCommands(remotePath string) []string {
+	var commands []string
+	if sm.config.ZeroDowntime {
+		docker := sm.dockerCmd()
+		commands = []string{sm.listContainersCommand(), sm.buildCommand(remotePath)}
+		if sm.config.VerifyImage {
+			commands = append(commands, sm.imageInspectCommand())
+		}
+		commands = appendHook(commands, sm.config.BeforeRunCmd, remotePath)
+		commands = append(commands,
+			sm.runCommand(),
+			fmt.Sprintf("%s inspect ... <new container>   # repeated until healthy, up to %s", docker, sm.config.HealthCheckTimeout),
+		)
+		if sm.config.ContainerIDFormat == "name" {
+			commands = append(commands, sm.containerNameCommand("<new container>"))
+		}
+		commands = appendHook(commands, sm.config.BeforeStopCmd, remotePath)
+		commands = append(commands, fmt.Sprintf("%s stop <old containers> | xargs -r %s rm", docker, docker))
+		commands = appendHook(commands, sm.config.AfterRunCmd, remotePath)
+	} else {
+		commands = appendHook(commands, sm.config.BeforeStopCmd, remotePath)
+		commands = append(commands, sm.stopContainersCommand())
+		if sm.config.RemoveOldImage {
+			commands = append(commands, sm.removeImageCommand())
+		}
+		commands = appendHook(commands, sm.config.BetweenStopAndBuildCmd, remotePath)
+		commands = append(commands, fmt.Sprintf("mkdir -p %s", remotePath), sm.buildCommand(remotePath))
+		if sm.config.VerifyImage {
+			commands = append(commands, sm.imageInspectCommand())
+		}
+		commands = appendHook(commands, sm.config.BeforeRunCmd, remotePath)
+		commands = append(commands, sm.runCommand())
+		if sm.config.ContainerIDFormat == "name" {
+			commands = append(commands, sm.containerNameCommand("<new container>"))
+		}
+		commands = appendHook(commands, sm.config.AfterRunCmd, remotePath)
+	}
+	if sm.config.KeepImages > 0 {
+		commands = append(commands,
+			sm.tagBuildCommand(managedTagPrefix+"<timestamp>"),
+			fmt.Sprintf("%s rmi <%s tags beyond the %d most recent>", sm.dockerCmd(), managedTagPrefix, sm.config.KeepImages))
+	}
+	return commands
+}
+
+// buildCommand returns the docker build command run in the remote folder,
+// or fed the uploaded tarball with BUILD_FROM_TAR
+func (sm *SyncManager) buildCommand(remotePath string) string {
+	buildArgs := sm.config.DockerBuildArgs
+	if buildArgs == "" {
+		buildArgs = "-t"
+	}
+	// FRESH doesn't reuse layers cached from earlier builds either
+	if sm.config.Fresh {
+		buildArgs = "--no-cache " + buildArgs
+	}
+	if sm.contextTarball != "" {
+		return fmt.Sprintf("%s build %s %s - < %s", sm.dockerCmd(), buildArgs, sm.config.DockerImageName, sm.contextTarball)
+	}
+	return fmt.Sprintf("cd %s && %s build %s %s .", remotePath, sm.dockerCmd(), buildArgs, sm.config.DockerImageName)
+}
+
+// runCommand returns the docker run command that starts the new container
+func (sm *SyncManager) runCommand() string {
+	runArgs := sm.config.DockerRunArgs
+	if runArgs == "" {
+		runArgs = "-d"
+	}
+	return fmt.Sprintf("%s run %s %s", sm.dockerCmd(), runArgs, sm.config.DockerImageName)
+}
+
+// shellQuote quotes a string for safe use as a single POSIX shell argument
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 // executeRemoteCommand executes a command on the remote server via SSH
 func (sm *SyncManager) executeRemoteCommand(command string) error {
 	log.Printf("Executing: %s", command)
 	
-	session, err := sm.sshClient.NewSession()
+	session, err := sm.newSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	defer session.Close()
+	defer sm.closeSession(session)
 	
 	// Capture output for logging
 	output, err := session.CombinedOutput(command)
@@ -799,11 +3117,11 @@ func (sm *SyncManager) executeRemoteCommand(command string) error {
 
 // executeRemoteCommandQuiet executes a command without logging output unless there's an error
 func (sm *SyncManager) executeRemoteCommandQuiet(command string) error {
-	session, err := sm.sshClient.NewSession()
+	session, err := sm.newSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	defer session.Close()
+	defer sm.closeSession(session)
 	
 	output, err := session.CombinedOutput(command)
 	if err != nil && len(output) > 0 {
@@ -815,11 +3133,11 @@ func (sm *SyncManager) executeRemoteCommandQuiet(command string) error {
 
 // executeRemoteCommandWithOutput executes a command and returns the output
 func (sm *SyncManager) executeRemoteCommandWithOutput(command string, showErrors bool) (string, error) {
-	session, err := sm.sshClient.NewSession()
+	session, err := sm.newSession()
 	if err != nil {
 		return "", fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	defer session.Close()
+	defer sm.closeSession(session)
 	
 	output, err := session.CombinedOutput(command)
 	if err != nil && showErrors {
@@ -834,11 +3152,11 @@ func (sm *SyncManager) executeRemoteCommandWithOutput(command string, showErrors
 
 // executeRemoteCommandWithProgress executes a command and shows output in real-time
 func (sm *SyncManager) executeRemoteCommandWithProgress(command string) error {
-	session, err := sm.sshClient.NewSession()
+	session, err := sm.newSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
 	}
-	defer session.Close()
+	defer sm.closeSession(session)
 	
 	// Pipe stdout and stderr to display in real-time
 	stdout, err := session.StdoutPipe()
@@ -854,24 +3172,240 @@ func (sm *SyncManager) executeRemoteCommandWithProgress(command string) error {
 		return err
 	}
 	
-	// Read output in real-time
-	go io.Copy(os.Stdout, stdout)
-	go io.Copy(os.Stderr, stderr)
+	// Read output in real-time, noting when the last of it arrived, and
+	// keep a copy in BUILD_LOG if set
+	buildLog, closeBuildLog := sm.openBuildLog(command)
+	activity := newActivityWriter()
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		io.Copy(activity.to(io.MultiWriter(os.Stdout, buildLog)), stdout)
+	}()
+	go func() {
+		defer copies.Done()
+		io.Copy(activity.to(io.MultiWriter(os.Stderr, buildLog)), stderr)
+	}()
+	
+	err = sm.waitInterruptible(session, activity)
+	
+	// Let the last of the output reach the log before it is closed
+	copied := make(chan struct{})
+	go func() {
+		copies.Wait()
+		close(copied)
+	}()
+	select {
+	case <-copied:
+	case <-time.After(buildLogDrainTimeout):
+	}
+	closeBuildLog(err)
+	return err
+}
+
+// runTargets deploys to every target, running up to MAX_PARALLEL_TARGETS at once.
+// A failing target is recorded but doesn't stop the others. Targets that
+// succeeded in a resumed push are skipped, and successes are added to state.
+func runTargets(config *Config, targets []string, state *deployState) []*SyncResult {
+	parallel := config.MaxParallelTargets
+	if parallel < 1 {
+		parallel = 1
+	}
+	
+	results := make([]*SyncResult, len(targets))
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	
+	// A failed Docker phase stops the targets that haven't started yet,
+	// unless CONTINUE_ON_DOCKER_ERROR is set
+	var mu sync.Mutex
+	dockerFailedOn := ""
+	
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			
+			if state.done(target) {
+				log.Printf("\n⏭️  Skipping %s (%d/%d): it succeeded before (--resume)", target, i+1, len(targets))
+				results[i] = &SyncResult{Target: target, Mode: "push", Timestamp: time.Now(), Result: "skipped"}
+				return
+			}
+			mu.Lock()
+			failedOn := dockerFailedOn
+			mu.Unlock()
+			if failedOn != "" {
+				log.Printf("\n⏭️  Skipping %s (%d/%d): Docker operations failed on %s (set CONTINUE_ON_DOCKER_ERROR to deploy to the rest anyway)", target, i+1, len(targets), failedOn)
+				results[i] = &SyncResult{Target: target, Mode: "push", Timestamp: time.Now(), Result: "skipped", Error: fmt.Sprintf("not deployed, as Docker operations failed on %s", failedOn)}
+				return
+			}
+			if len(targets) > 1 {
+				log.Printf("\n🎯 Deploying to %s (%d/%d)", target, i+1, len(targets))
+			}
+			results[i] = deployTarget(config.forTarget(target), parallel > 1 && len(targets) > 1)
+			if results[i].Result == "success" {
+				state.markSucceeded(target)
+			}
+			if results[i].ErrorKind == "docker" && !config.ContinueOnDockerError {
+				mu.Lock()
+				if dockerFailedOn == "" {
+					dockerFailedOn = target
+				}
+				mu.Unlock()
+			}
+		}(i, target)
+		
+		// Run sequential deploys strictly in order
+		if parallel == 1 {
+			wg.Wait()
+		}
+	}
+	
+	wg.Wait()
+	return results
+}
+
+// deployTarget pushes files and manages Docker on a single target
+func deployTarget(config *Config, quiet bool) *SyncResult {
+	syncManager, err := NewSyncManager(config)
+	if err != nil {
+		return &SyncResult{Target: config.RemoteServer, Mode: "push", Result: "failure", Error: err.Error()}
+	}
+	syncManager.quiet = quiet
+	
+	// Connect to remote server
+	if err := syncManager.Connect(); err != nil {
+		log.Printf("❌ Failed to connect to %s: %v", config.RemoteServer, err)
+		syncManager.Finish("push", err)
+		return syncManager.Result()
+	}
+	defer syncManager.Close()
+	
+	// SCAFFOLD only prepares the directory tree for a later transfer
+	if config.Scaffold {
+		err := syncManager.Scaffold()
+		if err != nil {
+			log.Printf("❌ Creating directories failed on %s: %v", config.RemoteServer, err)
+		}
+		syncManager.Finish("push", err)
+		return syncManager.Result()
+	}
+	
+	// An applied plan changes nothing unless its Docker steps still match
+	if config.ApplyPlan != nil && !config.SyncOnly {
+		if err := syncManager.checkPlannedDocker(); err != nil {
+			log.Printf("❌ %v", err)
+			syncManager.Finish("push", err)
+			return syncManager.Result()
+		}
+	}
+	
+	// FRESH starts from an empty REMOTE_FOLDER
+	if config.Fresh {
+		if err := syncManager.freshStart(); err != nil {
+			log.Printf("❌ Emptying the remote folder failed on %s: %v", config.RemoteServer, err)
+			syncManager.Finish("push", err)
+			return syncManager.Result()
+		}
+	}
+	
+	// Synchronize files, unless the code is already on the remote
+	if config.OnlyDocker {
+		log.Println("⏭️  Skipping file sync (ONLY_DOCKER)")
+	} else if err := syncManager.SyncFiles(); err != nil {
+		log.Printf("❌ File synchronization failed on %s: %v", config.RemoteServer, err)
+		syncManager.Finish("push", err)
+		return syncManager.Result()
+	}
+	
+	// Record exactly what is on the remote before anything is built from it
+	if config.ManifestFile != "" && !config.OnlyDocker && !config.DryRun {
+		if err := syncManager.writeManifest(); err != nil {
+			log.Printf("❌ Writing the manifest failed on %s: %v", config.RemoteServer, err)
+			syncManager.Finish("push", err)
+			return syncManager.Result()
+		}
+	}
+	
+	// Execute Docker commands, which SFTP-only accounts can't run
+	if config.SyncOnly {
+		log.Println("⏭️  Skipping Docker operations (SYNC_ONLY)")
+	} else if err := syncManager.ExecuteDockerCommands(); err != nil {
+		log.Printf("❌ Docker operations failed on %s: %v", config.RemoteServer, err)
+		syncManager.Finish("push", err)
+		return syncManager.Result()
+	}
+	
+	// Only a release that deployed successfully becomes the current one
+	if config.UpdateSymlink != "" {
+		if err := syncManager.updateSymlink(); err != nil {
+			log.Printf("❌ Updating %s failed on %s: %v", config.UpdateSymlink, config.RemoteServer, err)
+			syncManager.Finish("push", err)
+			return syncManager.Result()
+		}
+	}
 	
-	return session.Wait()
+	syncManager.Finish("push", nil)
+	return syncManager.Result()
+}
+
+// printTargetSummary prints a per-target results table
+func printTargetSummary(results []*SyncResult) {
+	log.Println("\n📊 Deploy summary:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "   TARGET\tRESULT\tFILES\tBYTES\tDURATION\tERROR")
+	for _, r := range results {
+		status := "✅ ok"
+		if r.Result == "skipped" {
+			status = "⏭️  skipped"
+		} else if r.Result != "success" {
+			status = "❌ failed"
+		}
+		fmt.Fprintf(w, "   %s\t%s\t%d\t%d\t%s\t%s\n", r.Target, status, r.FilesTransferred, r.BytesTransferred, r.Duration, r.Error)
+	}
+	w.Flush()
 }
 
 func showHelp() {
-	fmt.Println(`
+	fmt.Print(`
 Pooshit - Push/Pull files and manage Docker containers on remote servers
 
 Usage:
   pooshit [config_file] [mode]
   pooshit [mode] [config_file]
+  pooshit [push|pull] [local_folder] user@host:path
+  pooshit put remote_path [config_file] < data
   
 Modes:
   (default)    Push local files to remote and manage Docker containers
   pull         Pull remote files to local (no Docker operations)
+  setup        Interactively create a config file and test the connection
+  doctor       Check connectivity, permissions and Docker without deploying
+  clean        Delete everything inside REMOTE_FOLDER (asks for confirmation)
+  config       Print the effective configuration, secrets redacted, without
+               connecting (also --print-config)
+  docker       Skip the file sync and only run the Docker steps (ONLY_DOCKER)
+  pick         Choose which changed files to upload before pushing
+               (PICK_FILES)
+  dev          Push, then keep syncing local changes, rebuilding and
+               restarting the container and streaming its logs; Ctrl-C
+               stops the container
+  put          Write stdin to a single remote file, atomically (PUT_MODE
+               sets its mode)
+  status       Report whether the remote is in sync, read-only and without
+               prompting; exits 0 in sync, 1 out of sync, 2 on errors
+  plan         Save what a push would upload and run to a file (-o file),
+               without changing anything
+  apply        Run a saved plan exactly: only its uploads and Docker commands
+  build-log    Show the output of the last Docker build saved to BUILD_LOG;
+               -f follows a build that is still running
+  perms-export Record the mode of every local file in .pooshit-perms, for
+               PERMS_SIDECAR deploys from Windows
+  validate     Check a config file for unknown keys, bad values and missing
+               required keys, with line numbers, without connecting
+  schema       Print the JSON Schema of the config file, for editors
 
 Arguments:
   config_file  Path to configuration file (default: pooshit_config)
@@ -882,9 +3416,36 @@ Examples:
   pooshit my_config          # Push with custom config
   pooshit my_config pull     # Pull with custom config
   pooshit pull my_config     # Pull with custom config (order doesn't matter)
+  pooshit setup my_config    # Create my_config interactively
+  pooshit doctor             # Verify everything is ready for a deploy
+  pooshit config --since=1h  # Show what a push with these flags would use
+  pooshit validate my_config # Catch config mistakes before a deploy
+  pooshit clean && pooshit   # Wipe the remote folder, then push from scratch
+  pooshit status             # Cron-friendly check that the remote matches
+  pooshit docker             # Rebuild and restart from the files already on the remote
+  pooshit pick               # Push only some of the changed files
+  pooshit dev                # Live-reload development on the remote
+  pooshit plan -o plan.json  # Save a push for review...
+  pooshit apply plan.json    # ...and run it once approved
+  pooshit push ./dist deploy@example.com:/srv/app   # One-off upload, no config file
+  tar czf - dist | pooshit put /srv/app/dist.tgz     # Upload generated data without a temp file
 
 Options:
-  -h, --help   Show this help message
+  -h, --help       Show this help message
+  --dry-run        Show what a push would upload and the Docker commands it
+                   would run, without changing anything
+  --since=<when>   Only push files modified since a duration ago (10m, 2h)
+                   or an RFC3339 timestamp
+  --resume         Continue an unfinished push to several targets, skipping
+                   the ones it already deployed to
+  --restart        Push to all targets again, ignoring an unfinished push
+  --fresh          Empty the remote folder, upload everything and build
+                   without the Docker cache
+  --<key>=<value>  Override any config key, e.g. --docker-image-name=app
+
+REMOTE_SERVER may list several servers separated by commas; push deploys to
+each of them, MAX_PARALLEL_TARGETS at a time. If some of them fail, --resume
+deploys to just those.
 
 Pull mode will ask for confirmation before overwriting local files.
 `)
@@ -893,34 +3454,185 @@ Pull mode will ask for confirmation before overwriting local files.
 func main() {
 	// Parse command line arguments
 	configFile := "pooshit_config"
-	pullMode := false
+	mode := "push"
+	overrides := map[string]string{}
+	var positional []string
+	planOutput := ""
+	followLog := false
 	
-	// Check for help or pull mode
+	// Check for help or a mode
 	for i := 1; i < len(os.Args); i++ {
 		if os.Args[i] == "-h" || os.Args[i] == "--help" {
 			showHelp()
 			return
 		}
-		if os.Args[i] == "pull" {
-			pullMode = true
+		if os.Args[i] == "push" || os.Args[i] == "pull" || os.Args[i] == "setup" || os.Args[i] == "doctor" || os.Args[i] == "clean" || os.Args[i] == "config" || os.Args[i] == "status" || os.Args[i] == "put" || os.Args[i] == "plan" || os.Args[i] == "apply" || os.Args[i] == "perms-export" || os.Args[i] == "build-log" || os.Args[i] == "validate" || os.Args[i] == "schema" || os.Args[i] == "dev" {
+			mode = os.Args[i]
+		} else if os.Args[i] == "-o" && i+1 < len(os.Args) {
+			// Where plan mode saves the plan
+			planOutput = os.Args[i+1]
+			i++
+		} else if os.Args[i] == "-f" {
+			// build-log keeps following the output
+			followLog = true
+		} else if os.Args[i] == "--print-config" {
+			mode = "config"
+		} else if os.Args[i] == "docker" {
+			// A push that only runs the Docker steps
+			overrides["ONLY_DOCKER"] = "true"
+		} else if os.Args[i] == "pick" {
+			// A push that asks which changed files to upload
+			overrides["PICK_FILES"] = "true"
+		} else if strings.HasPrefix(os.Args[i], "--") {
+			// --some-key=value overrides SOME_KEY from the config file; a bare --flag means true
+			name, value, found := strings.Cut(strings.TrimPrefix(os.Args[i], "--"), "=")
+			if !found {
+				value = "true"
+			}
+			overrides[flagToConfigKey(name)] = value
 		} else if !strings.HasPrefix(os.Args[i], "-") {
-			// Assume it's a config file if it doesn't start with -
-			configFile = os.Args[i]
+			positional = append(positional, os.Args[i])
+		}
+	}
+	
+	// Put mode takes the remote file path first; as user@host:path it also
+	// names the server, and relative paths are taken from the home directory
+	putTarget := ""
+	if mode == "put" {
+		if len(positional) == 0 {
+			log.Fatalf("Put mode needs a remote file path, e.g. pooshit put /srv/app/dist.tgz")
+		}
+		if _, _, folder, ok := parseRemoteSpec(positional[0]); ok {
+			putTarget = folder
+			setDefault(overrides, "REMOTE_FOLDER", "~/")
+		} else {
+			putTarget = positional[0]
+			positional = positional[1:]
+		}
+	}
+	
+	// Apply mode takes the plan first, and loads the configuration it was
+	// made with unless another config file is given
+	var plan *Plan
+	if mode == "apply" {
+		if len(positional) == 0 {
+			log.Fatalf("Apply mode needs a plan, e.g. pooshit apply plan.json")
+		}
+		var err error
+		plan, err = loadPlan(positional[0])
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		positional = positional[1:]
+		for key, value := range plan.Overrides {
+			setDefault(overrides, key, value)
+		}
+		setDefault(overrides, "REMOTE_FOLDER", plan.RemoteFolder)
+	}
+	if mode == "plan" && planOutput == "" {
+		log.Fatalf("Plan mode needs a file to save the plan to, e.g. pooshit plan -o plan.json")
+	}
+	
+	// A user@host:path argument replaces the config file; otherwise assume
+	// the argument is a config file
+	adHoc, err := applyRemoteSpec(positional, overrides)
+	if err != nil {
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+	if adHoc {
+		if mode == "setup" {
+			log.Fatalf("Setup mode writes a config file and doesn't take user@host:path")
+		}
+		configFile = ""
+	} else if len(positional) > 0 {
+		configFile = positional[len(positional)-1]
+	} else if plan != nil {
+		configFile = plan.ConfigFile
+	}
+	
+	if mode == "setup" {
+		if err := runSetup(configFile); err != nil {
+			log.Fatalf("Setup failed: %v", err)
+		}
+		return
+	}
+	
+	// Neither connects, nor needs a config that loads
+	if mode == "schema" {
+		if err := printSchema(); err != nil {
+			log.Fatalf("%v", err)
 		}
+		return
 	}
+	if mode == "validate" {
+		os.Exit(validateConfig(configFile, overrides))
+	}
+	
+	// Status mode runs unattended, so it must fail rather than wait for a password
+	if mode == "status" {
+		overrides["BATCH_MODE"] = "true"
+	}
+	
+	// Plan and apply are a push split in two
+	deploying := mode == "push" || mode == "plan" || mode == "apply"
 	
 	// Show a fun header
-	if !pullMode {
+	if deploying {
 		fmt.Println("\n💩 Pooshit v1.0 - Let's push some... code!")
 		fmt.Println("─────────────────────────────────────────")
 	}
 	
 	// Load configuration
-	config, err := LoadConfig(configFile)
+	config, err := LoadConfig(configFile, overrides)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 	
+	if mode == "config" {
+		printConfig(config)
+		return
+	}
+	
+	if mode == "build-log" {
+		if err := showBuildLog(config, followLog); err != nil {
+			log.Fatalf("%v", err)
+		}
+		return
+	}
+	
+	// Recording file modes only reads the local folders
+	if mode == "perms-export" {
+		syncManager, err := NewSyncManager(config)
+		if err != nil {
+			log.Fatalf("Failed to create sync manager: %v", err)
+		}
+		if err := syncManager.ExportPerms(); err != nil {
+			log.Fatalf("Exporting file modes failed: %v", err)
+		}
+		return
+	}
+	
+	if mode == "plan" || mode == "apply" {
+		if err := config.checkPlanSupport(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+	if mode == "plan" {
+		plan = newPlan(config, configFile, overrides)
+		config.DryRun = true
+		config.MakePlan = true
+	}
+	if mode == "apply" {
+		if config.DryRun {
+			log.Fatalf("DRY_RUN can't be combined with apply; a plan is already the preview")
+		}
+		config.ApplyPlan = plan
+		if err := config.checkApplyPlan(); err != nil {
+			log.Fatalf("%v", err)
+		}
+		log.Printf("📜 Applying the plan made %s", plan.GeneratedAt.Local().Format(time.RFC1123))
+	}
+	
 	log.Println("\n📋 Configuration loaded:")
 	log.Printf("   Server: %s", config.RemoteServer)
 	log.Printf("   User: %s", config.SSHUsername)
@@ -930,32 +3642,163 @@ func main() {
 	if len(config.IgnorePatterns) > 0 {
 		log.Printf("   Ignore: %s", strings.Join(config.IgnorePatterns, ", "))
 	}
+	if !config.Since.IsZero() {
+		log.Printf("   Since: %s", config.Since.Format(time.RFC3339))
+	}
+	if config.LocalFolder2 != "" {
+		log.Printf("   Remote 2: %s", config.RemoteFolder2)
+		log.Printf("   Local 2: %s", config.LocalFolder2)
+		log.Printf("   Ignore 2: %s", strings.Join(config.IgnorePatterns2, ", "))
+	}
 	
-	// List local directory contents
-	log.Printf("\n📁 Checking local directory: %s", config.LocalFolder)
-	files, err := os.ReadDir(config.LocalFolder)
-	if err != nil {
-		log.Fatalf("Failed to read local directory: %v", err)
+	if mode == "doctor" {
+		healthy := true
+		for _, target := range config.Targets() {
+			healthy = runDoctor(config.forTarget(target)) && healthy
+		}
+		if !healthy {
+			os.Exit(1)
+		}
+		return
 	}
 	
-	dockerfileFound := false
-	fileCount := 0
-	for _, file := range files {
-		if !strings.HasPrefix(file.Name(), ".") {
-			fileCount++
-			if file.Name() == "Dockerfile" {
-				dockerfileFound = true
+	// A push from FROM_GIT_REF doesn't read the working tree, so it can't ship its changes
+	if deploying && config.RequireCleanGit && config.FromGitRef == "" && !config.OnlyDocker {
+		folders := []string{config.LocalFolder}
+		if config.LocalFolder2 != "" {
+			folders = append(folders, config.LocalFolder2)
+		}
+		for _, folder := range folders {
+			err := checkCleanGit(folder)
+			if err != nil && config.Force {
+				log.Printf("⚠️  %v", err)
+				log.Printf("   Deploying anyway (--force)")
+			} else if err != nil {
+				log.Fatalf("❌ %v\n   Pass --force to deploy anyway", err)
 			}
 		}
 	}
 	
-	log.Printf("   Found %d files/directories (excluding hidden)", fileCount)
+	// A push or status check from FROM_GIT_REF works on a clean export of it
+	cleanupGit := func() {}
+	if config.FromGitRef != "" && (deploying || mode == "status") && !config.OnlyDocker {
+		cleanupGit, err = config.exportGitRef()
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		// apply exports the same commit even if the ref has moved on since
+		if mode == "plan" {
+			plan.Overrides["FROM_GIT_REF"] = config.GitSHA
+		}
+	}
 	
-	if !dockerfileFound {
-		log.Printf("\n⚠️  WARNING: No Dockerfile found in '%s'", config.LocalFolder)
-		log.Printf("   Docker build will fail without a Dockerfile!")
-	} else {
-		log.Printf("   ✅ Dockerfile found")
+	if mode == "status" {
+		exitCode := runStatus(config)
+		cleanupGit()
+		os.Exit(exitCode)
+	}
+	
+	// List local directory contents; with ONLY_DOCKER or put nothing local is used
+	if !config.OnlyDocker && mode != "put" {
+		log.Printf("\n📁 Checking local directory: %s", config.LocalFolder)
+		files, err := os.ReadDir(config.LocalFolder)
+		if err != nil {
+			cleanupGit()
+			log.Fatalf("Failed to read local directory: %v", err)
+		}
+	
+		dockerfileFound := false
+		fileCount := 0
+		for _, file := range files {
+			if !strings.HasPrefix(file.Name(), ".") {
+				fileCount++
+				if file.Name() == "Dockerfile" {
+					dockerfileFound = true
+				}
+			}
+		}
+	
+		log.Printf("   Found %d files/directories (excluding hidden)", fileCount)
+	
+		if !config.NoDockerfileCheck && !config.SyncOnly {
+			if !dockerfileFound {
+				log.Printf("\n⚠️  WARNING: No Dockerfile found in '%s'", config.LocalFolder)
+				log.Printf("   Docker build will fail without a Dockerfile!")
+			} else {
+				log.Printf("   ✅ Dockerfile found")
+			}
+		}
+	
+	}
+	
+	if deploying && !config.OnlyDocker && !config.Scaffold {
+		if err := config.checkExtraFiles(); err != nil {
+			cleanupGit()
+			log.Fatalf("%v", err)
+		}
+	}
+	if deploying && config.StrictDockerfile && !config.OnlyDocker && !config.SyncOnly && !config.Scaffold {
+		if err := config.checkLocalDockerfile(); err != nil {
+			cleanupGit()
+			log.Fatalf("%v", err)
+		}
+	}
+	
+	targets := config.Targets()
+	if deploying {
+		state, err := config.prepareDeployState(targets)
+		if err != nil {
+			cleanupGit()
+			log.Fatalf("❌ %v", err)
+		}
+		results := runTargets(config, targets, state)
+		cleanupGit()
+		writeSummary(config.SummaryFile, results)
+		
+		log.Println("\n⏱️  Timings:")
+		for _, r := range results {
+			log.Printf("   %s: %s", r.Target, formatTimings(r))
+		}
+		
+		failed := 0
+		for _, r := range results {
+			if r.Result == "failure" {
+				failed++
+			}
+		}
+		if len(results) > 1 {
+			printTargetSummary(results)
+		}
+		state.finish(results)
+		if failed > 0 {
+			if len(results) > 1 {
+				log.Fatalf("%d of %d targets failed", failed, len(results))
+			}
+			os.Exit(1)
+		}
+		if mode == "plan" {
+			for _, r := range results {
+				plan.Targets = append(plan.Targets, r.plan)
+			}
+			if err := savePlan(planOutput, plan); err != nil {
+				log.Fatalf("%v", err)
+			}
+			log.Printf("\n📜 Plan saved to %s - review it, then run: pooshit apply %s", planOutput, planOutput)
+			return
+		}
+		if config.DryRun {
+			log.Println("\n🔎 Dry run complete - nothing was uploaded and no Docker commands were run")
+			return
+		}
+		log.Println("\n🎉 All operations completed successfully!")
+		return
+	}
+	
+	if config.DryRun {
+		log.Fatalf("DRY_RUN is only supported when pushing")
+	}
+	if len(targets) > 1 {
+		log.Fatalf("%s mode works with a single target, but REMOTE_SERVER lists %d", mode, len(targets))
 	}
 	
 	// Create sync manager
@@ -966,36 +3809,60 @@ func main() {
 	
 	// Connect to remote server
 	if err := syncManager.Connect(); err != nil {
+		syncManager.Finish(mode, err)
+		writeSummary(config.SummaryFile, []*SyncResult{syncManager.Result()})
 		log.Fatalf("Failed to connect to remote server: %v", err)
 	}
 	defer syncManager.Close()
 	
-	if pullMode {
-		// Pull mode: download from remote to local
-		log.Println("\n📥 Pull mode: Downloading files from remote to local")
-		
-		// Ask for confirmation
-		if !confirmAction("This will overwrite local files with remote files. Continue?") {
-			log.Println("Pull operation cancelled")
-			return
+	if mode == "clean" {
+		log.Println("\n🧹 Clean mode: Removing everything inside the remote folder")
+		err = syncManager.CleanRemote()
+		syncManager.Finish(mode, err)
+		writeSummary(config.SummaryFile, []*SyncResult{syncManager.Result()})
+		if err != nil {
+			log.Fatalf("Clean failed: %v", err)
 		}
-		
-		if err := syncManager.PullFiles(); err != nil {
-			log.Fatalf("File pull failed: %v", err)
+		log.Println("\n✅ Clean completed - the next push uploads everything again")
+		return
+	}
+	
+	if mode == "dev" {
+		log.Println("\n🔁 Dev mode: syncing and restarting on every local change")
+		if err := syncManager.RunDev(); err != nil {
+			log.Fatalf("Dev mode failed: %v", err)
 		}
-		log.Println("\n✅ Pull completed successfully!")
-	} else {
-		// Normal mode: push to remote and manage Docker
-		// Synchronize files
-		if err := syncManager.SyncFiles(); err != nil {
-			log.Fatalf("File synchronization failed: %v", err)
+		return
+	}
+	
+	if mode == "put" {
+		if stdinIsTerminal() {
+			log.Fatalf("Put mode reads the file from stdin; pipe data in, e.g. tar czf - dist | pooshit put %s", putTarget)
 		}
-		
-		// Execute Docker commands
-		if err := syncManager.ExecuteDockerCommands(); err != nil {
-			log.Fatalf("Docker operations failed: %v", err)
+		err = syncManager.PutFile(putTarget, os.Stdin)
+		syncManager.Finish(mode, err)
+		writeSummary(config.SummaryFile, []*SyncResult{syncManager.Result()})
+		if err != nil {
+			log.Fatalf("Put failed: %v", err)
 		}
-		
-		log.Println("\n🎉 All operations completed successfully!")
+		return
+	}
+	
+	// Pull mode: download from remote to local
+	log.Println("\n📥 Pull mode: Downloading files from remote to local")
+	
+	// Ask for confirmation
+	if !confirmAction("This will overwrite local files with remote files. Continue?") {
+		log.Println("Pull operation cancelled")
+		return
+	}
+	
+	err = syncManager.PullFiles()
+	syncManager.Finish(mode, err)
+	writeSummary(config.SummaryFile, []*SyncResult{syncManager.Result()})
+	if err != nil {
+		log.Fatalf("File pull failed: %v", err)
 	}
+	log.Printf("\n⏱️  Timings: %s", formatTimings(syncManager.Result()))
+	log.Println("\n✅ Pull completed successfully!")
 }
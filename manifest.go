@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifestFile is the remote copy of the MANIFEST_FILE record, kept in the remote folder
+const manifestFile = ".pooshit-manifest.json"
+
+// Manifest records every file under the remote folder after a push
+type Manifest struct {
+	Target       string          `json:"target"`
+	RemoteFolder string          `json:"remote_folder"`
+	GeneratedAt  time.Time       `json:"generated_at"`
+	Files        []ManifestEntry `json:"files"`
+}
+
+// ManifestEntry is one remote file, by its path relative to the remote folder
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// manifestPath returns where the manifest for a target is saved locally
+func (c *Config) manifestPath() string {
+	return strings.ReplaceAll(expandLocalHome(c.ManifestFile), "{target}", c.RemoteServer)
+}
+
+// writeManifest lists every regular file under REMOTE_FOLDER with its size
+// and SHA-256, saves the list to MANIFEST_FILE and uploads a copy next to
+// the files it describes. The hashes come from one find | sha256sum on the
+// remote; with SYNC_ONLY the files are read over SFTP instead.
+func (sm *SyncManager) writeManifest() (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+
+	remoteRoot, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	log.Printf("\n🧾 Writing the manifest of %s...", remoteRoot)
+	manifestStart := time.Now()
+
+	var entries []ManifestEntry
+	walker := sm.sftpClient.Walk(remoteRoot)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("failed to list %s: %w", walker.Path(), err)
+		}
+		if !walker.Stat().Mode().IsRegular() {
+			continue
+		}
+		relPath := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), remoteRoot), "/")
+		if relPath == manifestFile {
+			continue
+		}
+		entries = append(entries, ManifestEntry{Path: relPath, Size: walker.Stat().Size()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	sums, err := sm.remoteSHA256s(remoteRoot)
+	if errors.Is(err, errExecDisabled) {
+		sums, err = sm.sftpSHA256s(remoteRoot, entries)
+	}
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		sum, ok := sums[entry.Path]
+		if !ok {
+			return fmt.Errorf("no checksum for %s in the manifest", entry.Path)
+		}
+		entries[i].SHA256 = sum
+	}
+
+	manifest := Manifest{
+		Target:       sm.config.RemoteServer,
+		RemoteFolder: remoteRoot,
+		GeneratedAt:  time.Now().UTC(),
+		Files:        entries,
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode the manifest: %w", err)
+	}
+	data = append(data, '\n')
+
+	localPath := sm.config.manifestPath()
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to save the manifest: %w", err)
+	}
+	remoteFile, err := sm.sftpClient.Create(path.Join(remoteRoot, manifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to upload the manifest: %w", err)
+	}
+	_, err = remoteFile.Write(data)
+	if closeErr := remoteFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload the manifest: %w", err)
+	}
+	sm.timePhase("manifest", manifestStart)
+
+	sm.result.Manifest = localPath
+	log.Printf("✅ Manifest of %d files saved to %s", len(entries), localPath)
+	return nil
+}
+
+// remoteSHA256s hashes every file under remoteRoot with a single remote
+// command, keyed by path relative to remoteRoot
+func (sm *SyncManager) remoteSHA256s(remoteRoot string) (map[string]string, error) {
+	session, err := sm.newSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer sm.closeSession(session)
+
+	cmd := fmt.Sprintf("cd %s && find . -type f ! -path ./%s -print0 | xargs -0 -r sha256sum", shellQuote(remoteRoot), manifestFile)
+	output, err := session.Output(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum remote files (the manifest needs find and sha256sum on the remote): %w", err)
+	}
+
+	// sha256sum escapes backslashes and newlines in names and marks those lines with a leading backslash
+	unescape := strings.NewReplacer(`\\`, `\`, `\n`, "\n")
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		escaped := strings.HasPrefix(line, `\`)
+		sum, relPath, found := strings.Cut(strings.TrimPrefix(line, `\`), "  ")
+		if !found {
+			continue
+		}
+		if escaped {
+			relPath = unescape.Replace(relPath)
+		}
+		sums[strings.TrimPrefix(relPath, "./")] = sum
+	}
+	return sums, nil
+}
+
+// sftpSHA256s hashes the listed files by reading them over SFTP
+func (sm *SyncManager) sftpSHA256s(remoteRoot string, entries []ManifestEntry) (map[string]string, error) {
+	sums := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		f, err := sm.sftpClient.Open(path.Join(remoteRoot, entry.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", entry.Path, err)
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", entry.Path, err)
+		}
+		sums[entry.Path] = hex.EncodeToString(h.Sum(nil))
+	}
+	return sums, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// loadOwnedManifest reads OWNED_MANIFEST from the remote: paths under
+// REMOTE_FOLDER owned by another deploy tool, which pooshit must never
+// overwrite or delete. A missing manifest means nothing is owned yet.
+func (sm *SyncManager) loadOwnedManifest() error {
+	sm.ownedPaths = nil
+	if sm.config.OwnedManifest == "" {
+		return nil
+	}
+
+	remoteRoot, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	manifestPath := sm.config.OwnedManifest
+	if !path.IsAbs(manifestPath) && !strings.HasPrefix(manifestPath, "~/") {
+		manifestPath = path.Join(remoteRoot, manifestPath)
+	}
+	manifestPath, err = sm.resolveRemotePath(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	// The manifest itself belongs to the other tool too
+	if rel := strings.TrimPrefix(manifestPath, remoteRoot+"/"); rel != manifestPath {
+		sm.ownedPaths = append(sm.ownedPaths, rel)
+	}
+
+	f, err := sm.sftpClient.Open(manifestPath)
+	if os.IsNotExist(err) {
+		log.Printf("⚠️  Owned-paths manifest %s not found on the remote; nothing is treated as owned", manifestPath)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open owned-paths manifest %s: %w", manifestPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sm.ownedPaths = append(sm.ownedPaths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read owned-paths manifest %s: %w", manifestPath, err)
+	}
+
+	log.Printf("🔒 %d path(s) in %s are owned by another tool and left alone", len(sm.ownedPaths), manifestPath)
+	return nil
+}
+
+// ownedByOther reports whether a path relative to REMOTE_FOLDER is listed in the manifest
+func (sm *SyncManager) ownedByOther(relPath string) bool {
+	relPath = strings.TrimPrefix(path.Clean("/"+relPath), "/")
+	for _, entry := range sm.ownedPaths {
+		if matchOwnedEntry(relPath, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOwnedEntry matches a path against one manifest line, gitignore style:
+// a line without a slash matches that name at any depth, a line with one is
+// anchored at the folder root, and a matching directory covers everything in it
+func matchOwnedEntry(relPath, entry string) bool {
+	entry = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(entry, "./"), "/"), "/")
+	if entry == "" {
+		return false
+	}
+
+	parts := strings.Split(relPath, "/")
+	if !strings.Contains(entry, "/") {
+		for _, part := range parts {
+			if ok, _ := path.Match(entry, part); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Try the entry against the path and each of its parent directories
+	for i := len(parts); i > 0; i-- {
+		if ok, _ := path.Match(entry, strings.Join(parts[:i], "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedBelow reports whether a directory may contain owned paths, so that
+// it has to be cleaned entry by entry instead of removed as a whole
+func (sm *SyncManager) ownedBelow(relDir string) bool {
+	for _, entry := range sm.ownedPaths {
+		entry = strings.TrimPrefix(strings.TrimPrefix(entry, "./"), "/")
+		if !strings.Contains(strings.TrimSuffix(entry, "/"), "/") || strings.ContainsAny(entry, "*?[") {
+			return true
+		}
+		if strings.HasPrefix(entry, relDir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// removeUnowned deletes a remote entry unless it is owned, descending into
+// directories that may hold owned paths. It returns how many entries were kept.
+func (sm *SyncManager) removeUnowned(remoteRoot, relPath string) (int, error) {
+	if sm.ownedByOther(relPath) {
+		sm.verbosef("Keeping %s: owned by another tool", relPath)
+		return 1, nil
+	}
+
+	fullPath := path.Join(remoteRoot, relPath)
+	info, err := sm.sftpClient.Lstat(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat %s: %w", relPath, err)
+	}
+	if !info.IsDir() || !sm.ownedBelow(relPath) {
+		return 0, sm.sftpClient.RemoveAll(fullPath)
+	}
+
+	entries, err := sm.sftpClient.ReadDir(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %s: %w", relPath, err)
+	}
+	kept := 0
+	for _, entry := range entries {
+		n, err := sm.removeUnowned(remoteRoot, path.Join(relPath, entry.Name()))
+		if err != nil {
+			return kept, err
+		}
+		kept += n
+	}
+	if kept == 0 {
+		return 0, sm.sftpClient.RemoveDirectory(fullPath)
+	}
+	return kept, nil
+}
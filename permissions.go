@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// permissionRule gives files matching Pattern the remote mode Mode
+type permissionRule struct {
+	Pattern string
+	Mode    os.FileMode
+}
+
+// loadPermissionRules reads a PERMISSIONS_FILE: one "pattern mode" rule per
+// line, e.g. "*.sh 0755", with # comments. Patterns use the IGNORE syntax.
+func loadPermissionRules(filename string) ([]permissionRule, error) {
+	f, err := os.Open(expandLocalHome(filename))
+	if err != nil {
+		return nil, fmt.Errorf("PERMISSIONS_FILE: %w", err)
+	}
+	defer f.Close()
+
+	var rules []permissionRule
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("PERMISSIONS_FILE %s line %d: expected a pattern and a mode, got %q", filename, lineNo, line)
+		}
+		mode, err := parseFileMode(fields[1])
+		if err == nil && mode > 0777 {
+			err = fmt.Errorf("setuid, setgid and sticky bits aren't supported, got %q", fields[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("PERMISSIONS_FILE %s line %d: %w", filename, lineNo, err)
+		}
+		rules = append(rules, permissionRule{Pattern: fields[0], Mode: mode})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("PERMISSIONS_FILE: %w", err)
+	}
+	return rules, nil
+}
+
+// ruleFileMode returns the mode the PERMISSIONS_FILE rules give a file. As
+// with IGNORE, the last matching rule wins; false means no rule matched and
+// the file keeps its local mode.
+func (c *Config) ruleFileMode(relPath string, info os.FileInfo) (os.FileMode, bool) {
+	var mode os.FileMode
+	matched := false
+	for _, rule := range c.PermissionRules {
+		if matchIgnorePattern(relPath, info, rule.Pattern, c.IgnoreCase) {
+			mode, matched = rule.Mode, true
+		}
+	}
+	return mode, matched
+}
+
+// modePinned reports whether a file's remote mode is set by pooshit rather
+// than copied from the local file: by DEFAULT_FILE_MODE, a PERMISSIONS_FILE
+// rule or a PERMS_SIDECAR entry. Unchanged files with a pinned mode are
+// chmodded when the remote mode has drifted.
+func (sm *SyncManager) modePinned(relPath string, info os.FileInfo) bool {
+	_, ruled := sm.config.ruleFileMode(relPath, info)
+	return ruled || sidecarMode(info) || sm.config.DefaultFileMode != 0
+}
+
+// applyDirMode gives a remote directory DEFAULT_DIR_MODE, if set and not
+// already its mode. A failure is only logged, like other permission fix-ups.
+func (sm *SyncManager) applyDirMode(remoteDir string) {
+	if sm.config.DefaultDirMode == 0 {
+		return
+	}
+	info, err := sm.sftpClient.Stat(remoteDir)
+	if err != nil || info.Mode().Perm() == sm.config.DefaultDirMode {
+		return
+	}
+	if err := sm.sftpClient.Chmod(remoteDir, sm.config.DefaultDirMode); err != nil {
+		log.Printf("⚠️  Could not set the mode of %s to DEFAULT_DIR_MODE: %v", remoteDir, err)
+	}
+}
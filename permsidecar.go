@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// permsSidecarFile records the intended mode of every file in a local
+// folder, for filesystems such as FAT or NTFS that don't keep Unix modes
+const permsSidecarFile = ".pooshit-perms"
+
+// sidecarInfo is a scanned file with its mode taken from the sidecar
+type sidecarInfo struct {
+	os.FileInfo
+	mode os.FileMode
+}
+
+func (fi *sidecarInfo) Mode() os.FileMode {
+	return fi.FileInfo.Mode()&^os.ModePerm | fi.mode
+}
+
+// loadPermsSidecar reads the .pooshit-perms file of a local folder: one
+// "mode path" line per file, e.g. "0755 bin/start.sh", with # comments.
+// A folder without one returns nil.
+func loadPermsSidecar(localFolder string) (map[string]os.FileMode, error) {
+	filename := filepath.Join(localFolder, permsSidecarFile)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	modes := make(map[string]os.FileMode)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// The path is the rest of the line, so it may contain spaces
+		modeText, relPath, found := strings.Cut(line, " ")
+		relPath = strings.TrimSpace(relPath)
+		if !found || relPath == "" {
+			return nil, fmt.Errorf("%s line %d: expected a mode and a path, got %q", filename, lineNo, line)
+		}
+		mode, err := parseFileMode(modeText)
+		if err == nil && mode > 0777 {
+			err = fmt.Errorf("setuid, setgid and sticky bits aren't supported, got %q", modeText)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", filename, lineNo, err)
+		}
+		modes[relPath] = mode
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return modes, nil
+}
+
+// permsSidecar returns the modes in a folder's .pooshit-perms with
+// PERMS_SIDECAR, warning if there is none, and nil without it
+func (sm *SyncManager) permsSidecar(localFolder string) (map[string]os.FileMode, error) {
+	if !sm.config.PermsSidecar {
+		return nil, nil
+	}
+	modes, err := loadPermsSidecar(localFolder)
+	if err == nil && modes == nil {
+		log.Printf("⚠️  PERMS_SIDECAR is set but %s has no %s; using the modes the filesystem reports. Create it with pooshit perms-export on a Unix machine", localFolder, permsSidecarFile)
+	}
+	return modes, err
+}
+
+// applyPermsSidecar gives the scanned files of a folder the modes its
+// .pooshit-perms lists, in place of the modes the local filesystem reports.
+// PERMISSIONS_FILE rules and EXECUTABLE still apply on top.
+func (sm *SyncManager) applyPermsSidecar(localFolder string, files []syncFile) error {
+	modes, err := sm.permsSidecar(localFolder)
+	if err != nil || modes == nil {
+		return err
+	}
+
+	unlisted := 0
+	for i, file := range files {
+		mode, ok := modes[filepath.ToSlash(file.relPath)]
+		if !ok {
+			unlisted++
+			continue
+		}
+		files[i].info = &sidecarInfo{FileInfo: file.info, mode: mode}
+	}
+	if unlisted > 0 {
+		log.Printf("(%d files not in %s keep their local mode; run pooshit perms-export again to add them)", unlisted, permsSidecarFile)
+	}
+	return nil
+}
+
+// sidecarMode reports whether a file's mode came from .pooshit-perms
+func sidecarMode(info os.FileInfo) bool {
+	_, ok := info.(*sidecarInfo)
+	return ok
+}
+
+// ExportPerms writes the .pooshit-perms of each local folder, listing the
+// current mode of every file a push would upload. Run it on a Unix machine
+// and commit the result.
+func (sm *SyncManager) ExportPerms() error {
+	if runtime.GOOS == "windows" {
+		log.Printf("⚠️  Windows doesn't keep Unix modes, so the exported modes are only guesses; run perms-export on a Unix machine")
+	}
+	// Every file is listed, however long ago it changed
+	sm.config.Since = time.Time{}
+
+	if err := sm.exportFolderPerms(sm.config.LocalFolder, sm.config.IgnorePatterns, true); err != nil {
+		return err
+	}
+	if sm.config.LocalFolder2 != "" {
+		return sm.exportFolderPerms(sm.config.LocalFolder2, sm.config.IgnorePatterns2, false)
+	}
+	return nil
+}
+
+// exportFolderPerms writes the .pooshit-perms of one local folder
+func (sm *SyncManager) exportFolderPerms(localFolder string, ignorePatterns []string, primary bool) error {
+	localRoot, err := filepath.EvalSymlinks(localFolder)
+	if err == nil {
+		localRoot, err = filepath.Abs(localRoot)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve local folder '%s': %w", localFolder, err)
+	}
+	files, err := sm.scanLocalFolder(localFolder, localRoot, "", primary, ignorePatterns, &FolderResult{}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to scan local directory: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString("# File modes for PERMS_SIDECAR, written by pooshit perms-export\n")
+	for _, file := range files {
+		fmt.Fprintf(&b, "%04o %s\n", file.info.Mode().Perm(), filepath.ToSlash(file.relPath))
+	}
+	filename := filepath.Join(localFolder, permsSidecarFile)
+	if err := os.WriteFile(filename, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	log.Printf("✅ Wrote the modes of %d files to %s", len(files), filename)
+	return nil
+}
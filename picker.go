@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pickFiles lists the files in a folder that a push would upload, the way
+// status does, and lets whoever is at the terminal choose which of them to
+// upload. The files left out are removed from the list and recorded in
+// result; unchanged files stay, so they are still counted and cached.
+func (sm *SyncManager) pickFiles(files []syncFile, result *FolderResult) ([]syncFile, error) {
+	if sm.quiet || !stdinIsTerminal() {
+		return nil, fmt.Errorf("PICK_FILES needs a terminal, and can't be used with parallel targets")
+	}
+	changed, err := sm.outOfSyncFiles(files)
+	if err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return files, nil
+	}
+
+	picked := make([]bool, len(changed))
+	for i := range picked {
+		picked[i] = true
+	}
+	for {
+		fmt.Printf("\nChanged files in %s -> %s:\n", result.LocalFolder, result.RemoteFolder)
+		for i, d := range changed {
+			box, marker := " ", "M"
+			if picked[i] {
+				box = "x"
+			}
+			if d.missing {
+				marker = "+"
+			}
+			fmt.Printf("%4d [%s] %s %s\n", i+1, box, marker, filepath.ToSlash(d.relPath))
+		}
+		response := promptInput("Toggle files by number or range (e.g. 1 3-5), a for all, n for none, q to cancel, Enter to upload the checked files", "")
+		switch strings.ToLower(response) {
+		case "":
+			return removeUnpicked(files, changed, picked, result), nil
+		case "q":
+			return nil, fmt.Errorf("push cancelled while picking files")
+		case "a", "n":
+			for i := range picked {
+				picked[i] = response == "a"
+			}
+			continue
+		}
+		toggle, err := parsePickRanges(response, len(changed))
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		for _, i := range toggle {
+			picked[i] = !picked[i]
+		}
+	}
+}
+
+// removeUnpicked drops the changed files that weren't picked
+func removeUnpicked(files []syncFile, changed []outOfSync, picked []bool, result *FolderResult) []syncFile {
+	unpicked := make(map[string]bool)
+	for i, d := range changed {
+		if !picked[i] {
+			unpicked[d.relPath] = true
+			result.Unpicked = append(result.Unpicked, filepath.ToSlash(d.relPath))
+		}
+	}
+	if len(unpicked) == 0 {
+		return files
+	}
+	kept := make([]syncFile, 0, len(files)-len(unpicked))
+	for _, file := range files {
+		if !unpicked[file.relPath] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// parsePickRanges turns "1 3-5,8" into zero-based indexes below count
+func parsePickRanges(input string, count int) ([]int, error) {
+	var indexes []int
+	for _, field := range strings.FieldsFunc(input, func(r rune) bool { return r == ' ' || r == ',' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		first, err := strconv.Atoi(from)
+		last := first
+		if err == nil && isRange {
+			last, err = strconv.Atoi(to)
+		}
+		if err != nil || first < 1 || last > count || first > last {
+			return nil, fmt.Errorf("expected numbers or ranges from 1 to %d, got %q", count, field)
+		}
+		for i := first; i <= last; i++ {
+			indexes = append(indexes, i-1)
+		}
+	}
+	return indexes, nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// planVersion is bumped when a saved plan can no longer be applied by a newer pooshit
+const planVersion = 1
+
+// Plan is what a push would do, saved by plan mode for review and run
+// unchanged by apply mode
+type Plan struct {
+	Version      int               `json:"version"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+	ConfigFile   string            `json:"config_file"`
+	Overrides    map[string]string `json:"overrides,omitempty"`
+	RemoteFolder string            `json:"remote_folder"`
+	Targets      []*TargetPlan     `json:"targets"`
+}
+
+// TargetPlan is the part of a plan for one server
+type TargetPlan struct {
+	Target  string        `json:"target"`
+	Folders []*FolderPlan `json:"folders,omitempty"`
+	Docker  []string      `json:"docker,omitempty"`
+}
+
+// FolderPlan lists the uploads planned for one folder pair. Tree fingerprints
+// every local file that was scanned, so a changed tree is noticed on apply.
+type FolderPlan struct {
+	LocalFolder  string          `json:"local_folder"`
+	RemoteFolder string          `json:"remote_folder"`
+	Tree         string          `json:"tree"`
+	Uploads      []PlannedUpload `json:"uploads"`
+}
+
+// PlannedUpload is one file the plan uploads, as it was when planned
+type PlannedUpload struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// checkPlanSupport rejects options whose transfers a plan can't pin down
+func (c *Config) checkPlanSupport() error {
+	for _, opt := range []struct {
+		key string
+		set bool
+	}{
+		{"BUILD_FROM_TAR", c.BuildFromTar},
+		{"STAGING_DIR", c.StagingDir != ""},
+		{"EXTRA_FILES", len(c.ExtraFiles) > 0},
+		{"SHOW_DIFF", c.ShowDiff},
+		{"PICK_FILES", c.PickFiles},
+		{"SCAFFOLD", c.Scaffold},
+		{"FRESH", c.Fresh},
+	} {
+		if opt.set {
+			return fmt.Errorf("plan and apply don't support %s", opt.key)
+		}
+	}
+	return nil
+}
+
+// newPlan starts a plan for a push with this configuration. The overrides
+// are kept so apply loads the same configuration, except for the password;
+// SINCE and REMOTE_FOLDER are pinned to the values they took now.
+func newPlan(config *Config, configFile string, overrides map[string]string) *Plan {
+	plan := &Plan{
+		Version:      planVersion,
+		GeneratedAt:  time.Now().UTC(),
+		ConfigFile:   configFile,
+		Overrides:    make(map[string]string),
+		RemoteFolder: config.RemoteFolder,
+	}
+	for key, value := range overrides {
+		if key != "SSH_PASSWORD" && key != "DRY_RUN" {
+			plan.Overrides[key] = value
+		}
+	}
+	if !config.Since.IsZero() {
+		plan.Overrides["SINCE"] = config.Since.Format(time.RFC3339Nano)
+	}
+	return plan
+}
+
+// savePlan writes a plan as indented JSON
+func savePlan(filename string, plan *Plan) error {
+	// Commands are kept readable, without escaping & and >
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(plan); err != nil {
+		return fmt.Errorf("failed to encode the plan: %w", err)
+	}
+	if err := os.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to save the plan: %w", err)
+	}
+	return nil
+}
+
+// loadPlan reads a plan saved by plan mode
+func loadPlan(filename string) (*Plan, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the plan: %w", err)
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse the plan %s: %w", filename, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("the plan %s is version %d, but this pooshit applies version %d; make a new plan", filename, plan.Version, planVersion)
+	}
+	return &plan, nil
+}
+
+// checkApplyPlan makes sure the configuration still deploys to the servers
+// the plan was made for, and still runs Docker where the plan does
+func (c *Config) checkApplyPlan() error {
+	var planned []string
+	for _, target := range c.ApplyPlan.Targets {
+		planned = append(planned, target.Target)
+	}
+	if !slices.Equal(planned, c.Targets()) {
+		return fmt.Errorf("the plan deploys to %s, but REMOTE_SERVER is now %s; make a new plan",
+			strings.Join(planned, ", "), strings.Join(c.Targets(), ", "))
+	}
+	for _, target := range c.ApplyPlan.Targets {
+		if (len(target.Docker) > 0) == c.SyncOnly {
+			return fmt.Errorf("SYNC_ONLY changed since the plan was made; make a new plan")
+		}
+	}
+	return nil
+}
+
+// targetPlan returns the applied plan's part for this server
+func (sm *SyncManager) targetPlan() *TargetPlan {
+	for _, target := range sm.config.ApplyPlan.Targets {
+		if target.Target == sm.config.RemoteServer {
+			return target
+		}
+	}
+	return &TargetPlan{Target: sm.config.RemoteServer}
+}
+
+// treeFingerprint hashes the path, size and modification time of every scanned file
+func treeFingerprint(files []syncFile) string {
+	h := sha256.New()
+	for _, file := range files {
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", filepath.ToSlash(file.relPath), file.info.Size(), file.info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// planFolder connects a folder sync to a plan. When making a plan it
+// returns the FolderPlan the uploads are recorded in. When applying one it
+// returns only the files the plan uploads, after warning about any local
+// change since the plan was made and asking whether to go ahead.
+func (sm *SyncManager) planFolder(localFolder, remoteFolder string, files []syncFile) ([]syncFile, *FolderPlan, error) {
+	if sm.result.plan != nil {
+		folderPlan := &FolderPlan{LocalFolder: localFolder, RemoteFolder: remoteFolder, Tree: treeFingerprint(files)}
+		sm.result.plan.Folders = append(sm.result.plan.Folders, folderPlan)
+		return files, folderPlan, nil
+	}
+	if sm.config.ApplyPlan == nil {
+		return files, nil, nil
+	}
+
+	// A folder without files to check isn't in the plan. Folders are matched
+	// by their remote side, since FROM_GIT_REF exports to a new local one.
+	folderPlan := &FolderPlan{Tree: treeFingerprint(nil)}
+	for _, f := range sm.targetPlan().Folders {
+		if f.RemoteFolder == remoteFolder {
+			folderPlan = f
+		}
+	}
+
+	current := make(map[string]syncFile, len(files))
+	for _, file := range files {
+		current[filepath.ToSlash(file.relPath)] = file
+	}
+	var planned []syncFile
+	var changed []string
+	for _, upload := range folderPlan.Uploads {
+		file, ok := current[upload.Path]
+		switch {
+		case !ok:
+			changed = append(changed, upload.Path+" (gone, not uploaded)")
+		case file.info.Size() != upload.Size || !file.info.ModTime().Equal(upload.ModTime):
+			changed = append(changed, upload.Path)
+			planned = append(planned, file)
+		default:
+			planned = append(planned, file)
+		}
+	}
+
+	if treeFingerprint(files) != folderPlan.Tree {
+		log.Printf("⚠️  %s changed since the plan was made; only the planned uploads are applied", localFolder)
+		if len(changed) > 0 {
+			log.Printf("   Planned files that changed: %s", strings.Join(changed, ", "))
+		}
+		if sm.config.BatchMode || !stdinIsTerminal() {
+			return nil, nil, fmt.Errorf("%s changed since the plan was made; make a new plan", localFolder)
+		}
+		if !confirmDestructive("Apply the plan anyway?") {
+			return nil, nil, fmt.Errorf("apply cancelled; make a new plan")
+		}
+	}
+	log.Printf("Applying the plan: %d of %d files to upload", len(planned), len(files))
+	return planned, nil, nil
+}
+
+// planUpload records an upload in the plan being made
+func planUpload(folderPlan *FolderPlan, file syncFile) {
+	if folderPlan == nil {
+		return
+	}
+	folderPlan.Uploads = append(folderPlan.Uploads, PlannedUpload{
+		Path:    filepath.ToSlash(file.relPath),
+		Size:    file.info.Size(),
+		ModTime: file.info.ModTime(),
+	})
+}
+
+// checkPlannedDocker refuses to apply a plan whose Docker commands differ
+// from the ones the configuration would run now
+func (sm *SyncManager) checkPlannedDocker() error {
+	remotePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	if !slices.Equal(sm.dockerCommands(remotePath), sm.targetPlan().Docker) {
+		return &DockerError{Err: fmt.Errorf("the Docker commands differ from the plan, so the configuration changed since it was made; make a new plan")}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+func init() {
+	// x/net/proxy only knows SOCKS5; ALL_PROXY=http://... needs this too
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+}
+
+// parseProxy validates a PROXY value: a socks5://, socks5h:// or http://
+// URL, optionally with user:password@, or none for a direct connection
+func parseProxy(value string) (string, error) {
+	if value == "" || strings.EqualFold(value, "none") {
+		return strings.ToLower(value), nil
+	}
+	u, err := url.Parse(value)
+	if err == nil && u.Host != "" {
+		switch u.Scheme {
+		case "socks5", "socks5h", "http":
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("expected a socks5://, socks5h:// or http:// URL, or none, got %q", value)
+}
+
+// redactedProxy shows PROXY without its password
+func redactedProxy(value string) string {
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
+
+// dialServer opens the TCP connection to the SSH server: through PROXY if
+// set, else through ALL_PROXY from the environment unless NO_PROXY exempts
+// the server, else directly
+func (sm *SyncManager) dialServer(addr string, timeout time.Duration) (net.Conn, error) {
+	direct := &net.Dialer{Timeout: timeout}
+	var dialer proxy.Dialer
+	switch sm.config.Proxy {
+	case "none":
+		dialer = direct
+	case "":
+		dialer = proxy.FromEnvironmentUsing(direct)
+	default:
+		u, err := url.Parse(sm.config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		dialer, err = proxy.FromURL(u, direct)
+		if err != nil {
+			return nil, err
+		}
+		sm.verbosef("Connecting through proxy %s", redactedProxy(sm.config.Proxy))
+	}
+
+	// The SOCKS handshake can hang as well as the TCP connect
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return contextDialer.DialContext(ctx, "tcp", addr)
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// httpConnectDialer reaches a server through an HTTP proxy's CONNECT method
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  proxy.Dialer
+}
+
+func newHTTPConnectDialer(proxyURL *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &httpConnectDialer{proxyURL: proxyURL, forward: forward}, nil
+}
+
+// Dial asks the proxy for a tunnel to addr, with basic auth if the proxy URL has a user
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), "80")
+	}
+	conn, err := d.forward.Dial(network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyAddr, addr, resp.Status)
+	}
+	// The server speaks first, so its banner may already be in the reader
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through a reader that may hold bytes already read from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
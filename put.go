@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseFileMode parses an octal file mode such as 0755 or 644
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 07777 {
+		return 0, fmt.Errorf("expected an octal file mode such as 0644, got %q", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// putTargetPath resolves the remote path given to put mode: relative paths
+// are taken from REMOTE_FOLDER
+func (sm *SyncManager) putTargetPath(target string) (string, error) {
+	if !path.IsAbs(target) && !strings.HasPrefix(target, "~/") {
+		target = path.Join(sm.config.RemoteFolder, target)
+	}
+	remotePath, err := sm.resolveRemotePath(target)
+	if err != nil {
+		return "", err
+	}
+	if err := sm.checkAllowedRoot(remotePath); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(remotePath, "/") || path.Base(remotePath) == "." {
+		return "", fmt.Errorf("put needs a file path, got %q", target)
+	}
+	return remotePath, nil
+}
+
+// PutFile streams r, usually stdin, into a single remote file. The data is
+// written to a temporary file next to the target and renamed over it only
+// once everything has arrived, so readers never see a partial file. With
+// PUT_MODE the file gets that mode before the rename.
+func (sm *SyncManager) PutFile(target string, r io.Reader) (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+
+	remotePath, err := sm.putTargetPath(target)
+	if err != nil {
+		return err
+	}
+	remoteDir := path.Dir(remotePath)
+	if err := sm.ensureRemoteDir(remoteDir); err != nil {
+		return fmt.Errorf("failed to create remote directory: %w", err)
+	}
+
+	transferStart := time.Now()
+	tempPath := remoteTempPath(remotePath)
+	written, err := sm.writeRemoteTemp(tempPath, r)
+	if err != nil {
+		sm.sftpClient.Remove(tempPath)
+		return err
+	}
+
+	// posix-rename replaces an existing file in one step; servers without
+	// the extension can still rename onto a path that doesn't exist yet
+	if err := sm.sftpClient.PosixRename(tempPath, remotePath); err != nil {
+		if renameErr := sm.sftpClient.Rename(tempPath, remotePath); renameErr != nil {
+			sm.sftpClient.Remove(tempPath)
+			return fmt.Errorf("failed to move %s into place: %w", remotePath, err)
+		}
+	}
+	sm.timePhase("transfer", transferStart)
+
+	sm.result.FilesTransferred = 1
+	sm.result.BytesTransferred = written
+	sm.recordChange(remotePath)
+	log.Printf("✅ Wrote %d bytes to %s", written, remotePath)
+	return nil
+}
+
+// remoteTempPath names a hidden temporary file next to a remote file, for
+// writing its new contents before renaming them over it
+func remoteTempPath(remotePath string) string {
+	return path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.pooshit-%d.tmp", path.Base(remotePath), time.Now().UnixNano()))
+}
+
+// writeRemoteTemp copies r into a new remote file and applies PUT_MODE
+func (sm *SyncManager) writeRemoteTemp(tempPath string, r io.Reader) (int64, error) {
+	f, err := sm.sftpClient.Create(tempPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create remote file: %w", err)
+	}
+	written, err := sm.copyFile(f, r)
+	if err != nil {
+		f.Close()
+		return 0, fmt.Errorf("failed to write remote file after %d bytes: %w", written, err)
+	}
+	if sm.config.PutMode != 0 {
+		if err := f.Chmod(sm.config.PutMode); err != nil {
+			f.Close()
+			return 0, fmt.Errorf("failed to set mode %04o: %w", sm.config.PutMode, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return 0, fmt.Errorf("failed to finish remote file: %w", err)
+	}
+	return written, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// folderVars are the variables REMOTE_FOLDER templates can use, e.g.
+// /srv/releases/{{.Date}}-{{.GitSHA}}
+type folderVars struct {
+	now         time.Time
+	localFolder string
+	gitRef      string
+}
+
+// Date is the UTC date of the run, e.g. 2024-05-31
+func (v folderVars) Date() string { return v.now.Format("2006-01-02") }
+
+// Time is the UTC time of the run, e.g. 142501
+func (v folderVars) Time() string { return v.now.Format("150405") }
+
+// GitSHA is the short hash of the commit checked out in LOCAL_FOLDER, or of
+// FROM_GIT_REF if set. It is only looked up when a template uses it, and
+// fails the run if there is none.
+func (v folderVars) GitSHA() (string, error) {
+	ref := "HEAD"
+	if v.gitRef != "" {
+		ref = v.gitRef + "^{commit}"
+	}
+	out, err := exec.Command("git", "-C", v.localFolder, "rev-parse", "--short", ref).Output()
+	if err != nil {
+		return "", fmt.Errorf("{{.GitSHA}} needs LOCAL_FOLDER to be a git checkout: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// expandFolderTemplate resolves the {{...}} variables in a remote folder.
+// Folders without a template are returned as they are.
+func expandFolderTemplate(folder, localFolder, gitRef string, now time.Time) (string, error) {
+	if !strings.Contains(folder, "{{") {
+		return folder, nil
+	}
+	tmpl, err := template.New("REMOTE_FOLDER").Parse(folder)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, folderVars{now: now.UTC(), localFolder: localFolder, gitRef: gitRef}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// updateSymlink points UPDATE_SYMLINK at the release in REMOTE_FOLDER once
+// it has been deployed. The new link is created beside the old one and
+// renamed over it, so the path always resolves to one release or the other.
+func (sm *SyncManager) updateSymlink() error {
+	releasePath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return err
+	}
+	linkPath, err := sm.resolveRemotePath(sm.config.UpdateSymlink)
+	if err != nil {
+		return err
+	}
+	linkPath = path.Clean(linkPath)
+	if err := sm.checkAllowedRoot(linkPath); err != nil {
+		return err
+	}
+	if sm.config.DryRun {
+		log.Printf("🔎 Would point %s at %s", linkPath, releasePath)
+		return nil
+	}
+
+	tempLink := path.Join(path.Dir(linkPath), fmt.Sprintf(".%s.pooshit-%d", path.Base(linkPath), time.Now().UnixNano()))
+	if err := sm.sftpClient.Symlink(releasePath, tempLink); err != nil {
+		return fmt.Errorf("failed to create symlink %s: %w", tempLink, err)
+	}
+	if err := sm.sftpClient.PosixRename(tempLink, linkPath); err != nil {
+		sm.sftpClient.Remove(tempLink)
+		return fmt.Errorf("failed to replace %s (it must be a symlink or not exist yet): %w", linkPath, err)
+	}
+	log.Printf("🔗 %s now points at %s", linkPath, releasePath)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandFolderTemplate(t *testing.T) {
+	now := time.Date(2024, 5, 31, 23, 25, 1, 0, time.FixedZone("CEST", 2*3600))
+	notGit := t.TempDir()
+	tests := []struct {
+		folder  string
+		want    string
+		wantErr string
+	}{
+		{folder: "/srv/app", want: "/srv/app"},
+		{folder: "/srv/releases/{{.Date}}", want: "/srv/releases/2024-05-31"},
+		{folder: "/srv/releases/{{.Date}}-{{.Time}}", want: "/srv/releases/2024-05-31-212501"},
+		{folder: "/srv/releases/{{.Date", wantErr: "unclosed action"},
+		{folder: "/srv/releases/{{.Branch}}", wantErr: "Branch"},
+		{folder: "/srv/releases/{{.GitSHA}}", wantErr: "git checkout"},
+	}
+	for _, tt := range tests {
+		got, err := expandFolderTemplate(tt.folder, notGit, "", now)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expandFolderTemplate(%q) error = %v, want one containing %q", tt.folder, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expandFolderTemplate(%q): %v", tt.folder, err)
+		} else if got != tt.want {
+			t.Errorf("expandFolderTemplate(%q) = %q, want %q", tt.folder, got, tt.want)
+		}
+	}
+}
+
+func TestExpandFolderTemplateGitSHA(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	first := git("rev-parse", "--short", "HEAD")
+	git("tag", "v1")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	second := git("rev-parse", "--short", "HEAD")
+
+	for _, tt := range []struct{ gitRef, want string }{{"", second}, {"v1", first}} {
+		got, err := expandFolderTemplate("/srv/releases/{{.GitSHA}}", dir, tt.gitRef, time.Now())
+		if err != nil {
+			t.Errorf("gitRef %q: %v", tt.gitRef, err)
+		} else if got != "/srv/releases/"+tt.want {
+			t.Errorf("gitRef %q: got %q, want %q", tt.gitRef, got, "/srv/releases/"+tt.want)
+		}
+	}
+}
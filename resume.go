@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// defaultDeployStateFile is where a deploy to several targets records which succeeded
+const defaultDeployStateFile = ".pooshit-deploy-state.json"
+
+// deployState records which targets of a multi-target push have succeeded,
+// so a push that failed part way can be resumed with --resume
+type deployState struct {
+	path string
+	mu   sync.Mutex
+
+	Targets   []string             `json:"targets"`
+	StartedAt time.Time            `json:"started_at"`
+	Succeeded map[string]time.Time `json:"succeeded"`
+}
+
+// prepareDeployState decides, before a push to several targets, which
+// targets to skip. Without --resume or --restart, a state left by an
+// unfinished push to the same targets stops the push, so succeeded targets
+// aren't deployed to again by accident. The state isn't kept for a single
+// target or a dry run, which return nil.
+func (c *Config) prepareDeployState(targets []string) (*deployState, error) {
+	if len(targets) < 2 || c.DryRun {
+		return nil, nil
+	}
+	path := expandLocalHome(c.DeployStateFile)
+	fresh := &deployState{path: path, Targets: targets, StartedAt: time.Now().UTC(), Succeeded: make(map[string]time.Time)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DEPLOY_STATE_FILE: %w", err)
+	}
+	previous := &deployState{}
+	if err := json.Unmarshal(data, previous); err != nil {
+		return nil, fmt.Errorf("%s is not a deploy state file: %w", path, err)
+	}
+	if !slices.Equal(previous.Targets, targets) {
+		// Another deploy's state, e.g. after REMOTE_SERVER changed
+		if c.Resume {
+			return nil, fmt.Errorf("%s is from a push to other targets (%v), so it can't be resumed; pass --restart to deploy to all targets", path, previous.Targets)
+		}
+		log.Printf("⚠️  Replacing %s, which is from a push to other targets", path)
+		return fresh, fresh.discard()
+	}
+
+	switch {
+	case len(previous.Succeeded) == 0:
+		// Nothing to skip, so there is nothing to decide
+		return fresh, nil
+	case c.Restart:
+		log.Printf("🔄 Starting over on all %d targets (--restart)", len(targets))
+		return fresh, fresh.discard()
+	case c.Resume:
+		previous.path = path
+		if previous.Succeeded == nil {
+			previous.Succeeded = make(map[string]time.Time)
+		}
+		log.Printf("⏯️  Resuming the push started %s: %d of %d targets already succeeded", previous.StartedAt.Local().Format(time.RFC1123), len(previous.Succeeded), len(targets))
+		return previous, nil
+	default:
+		return nil, fmt.Errorf("The push started %s didn't finish: %d of %d targets succeeded (see %s).\n   Pass --resume to deploy only to the others, or --restart to deploy to all targets again",
+			previous.StartedAt.Local().Format(time.RFC1123), len(previous.Succeeded), len(targets), path)
+	}
+}
+
+// discard removes a previous push's state, so its successes aren't taken
+// for this push's if every target fails
+func (s *deployState) discard() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove DEPLOY_STATE_FILE: %w", err)
+	}
+	return nil
+}
+
+// done reports whether a target succeeded in the run being resumed
+func (s *deployState) done(target string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Succeeded[target]
+	return ok
+}
+
+// markSucceeded records a target's success right away, so it survives even
+// if pooshit is killed before the other targets finish
+func (s *deployState) markSucceeded(target string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Succeeded[target] = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err == nil {
+		err = os.WriteFile(s.path, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		log.Printf("⚠️  Failed to record %s in DEPLOY_STATE_FILE: %v", target, err)
+	}
+}
+
+// finish removes the state once every target has succeeded, or says how to
+// resume otherwise. The state file only exists once a target has succeeded.
+func (s *deployState) finish(results []*SyncResult) {
+	if s == nil {
+		return
+	}
+	failed := 0
+	for _, r := range results {
+		if r.Result == "failure" {
+			failed++
+		}
+	}
+	if failed == 0 {
+		if err := s.discard(); err != nil {
+			log.Printf("⚠️  %v", err)
+		}
+		return
+	}
+	if len(s.Succeeded) == 0 {
+		return
+	}
+	log.Println("⏯️  Run again with --resume to deploy only to the targets that failed")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultRunRetries covers the second or two a just-stopped container can
+// take to release its port
+const defaultRunRetries = 2
+
+// firstRunRetryDelay doubles after each retry
+const firstRunRetryDelay = 2 * time.Second
+
+// transientRunErrors are docker run failures caused by the container that was
+// just stopped still holding a resource; anything else, such as a missing
+// image or a bad argument, fails the same way on every attempt
+var transientRunErrors = []string{
+	"port is already allocated",
+	"address already in use",
+	"is already in use by container",
+}
+
+// transientRunError returns the transient failure in docker run output, if any
+func transientRunError(output string) string {
+	for _, msg := range transientRunErrors {
+		if strings.Contains(output, msg) {
+			return msg
+		}
+	}
+	return ""
+}
+
+// removeCreatedCommand removes containers of the image that docker run
+// created but couldn't start, so a retry doesn't trip over their name
+func (sm *SyncManager) removeCreatedCommand() string {
+	docker := sm.dockerCmd()
+	return fmt.Sprintf("%s ps -aq --filter ancestor=%s --filter status=created | xargs -r %s rm",
+		docker, sm.config.DockerImageName, docker)
+}
+
+// runContainer runs docker run, retrying up to RUN_RETRIES times with a
+// doubling delay when it fails for a transient reason
+func (sm *SyncManager) runContainer() (string, error) {
+	delay := firstRunRetryDelay
+	for attempt := 1; ; attempt++ {
+		output, err := sm.executeRemoteCommandWithOutput(sm.runCommand(), true)
+		if err == nil || attempt > sm.config.RunRetries {
+			return output, err
+		}
+		reason := transientRunError(output)
+		if reason == "" {
+			return output, err
+		}
+		log.Printf("🔁 docker run failed (%s); retrying in %s (retry %d of %d)", reason, delay, attempt, sm.config.RunRetries)
+		sm.result.RunRetries++
+		time.Sleep(delay)
+		delay *= 2
+		sm.executeRemoteCommandQuiet(sm.removeCreatedCommand())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestTransientRunError(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{output: "", want: ""},
+		{output: "3f2a9c1e8b7d", want: ""},
+		{
+			output: "docker: Error response from daemon: driver failed programming external connectivity on endpoint app: Bind for 0.0.0.0:8080 failed: port is already allocated.",
+			want:   "port is already allocated",
+		},
+		{
+			output: "Error starting userland proxy: listen tcp4 0.0.0.0:80: bind: address already in use.",
+			want:   "address already in use",
+		},
+		{
+			output: `docker: Error response from daemon: Conflict. The container name "/app" is already in use by container "3f2a9c1e". You have to remove (or rename) that container to be able to reuse that name.`,
+			want:   "is already in use by container",
+		},
+		{output: "Unable to find image 'app:latest' locally\ndocker: Error response from daemon: pull access denied for app.", want: ""},
+		{output: "docker: invalid reference format.", want: ""},
+	}
+	for _, tt := range tests {
+		if got := transientRunError(tt.output); got != tt.want {
+			t.Errorf("transientRunError(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveCreatedCommand(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{
+			config: Config{DockerImageName: "app"},
+			want:   "docker ps -aq --filter ancestor=app --filter status=created | xargs -r docker rm",
+		},
+		{
+			config: Config{DockerImageName: "app", DockerSudo: true},
+			want:   "sudo docker ps -aq --filter ancestor=app --filter status=created | xargs -r sudo docker rm",
+		},
+	}
+	for _, tt := range tests {
+		sm := &SyncManager{config: &tt.config}
+		if got := sm.removeCreatedCommand(); got != tt.want {
+			t.Errorf("removeCreatedCommand() = %q, want %q", got, tt.want)
+		}
+	}
+}
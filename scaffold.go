@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Scaffold creates the remote directory tree of each folder pair, mirroring
+// the local one with the same ignore rules as a push, without uploading any
+// file. Directories that already exist are left alone.
+func (sm *SyncManager) Scaffold() (err error) {
+	defer wrapError(&err, func(err error) error { return &SyncError{Err: err} })
+
+	if err := sm.loadOwnedManifest(); err != nil {
+		return err
+	}
+
+	pairs := [][2]string{{sm.config.LocalFolder, sm.config.RemoteFolder}}
+	ignores := [][]string{sm.config.IgnorePatterns}
+	if sm.config.LocalFolder2 != "" {
+		pairs = append(pairs, [2]string{sm.config.LocalFolder2, sm.config.RemoteFolder2})
+		ignores = append(ignores, sm.config.IgnorePatterns2)
+	}
+
+	for i, pair := range pairs {
+		created, err := sm.scaffoldFolder(pair[0], pair[1], ignores[i])
+		if err != nil {
+			return err
+		}
+		sm.result.DirsCreated += created
+	}
+
+	if sm.config.DryRun {
+		log.Printf("🔎 Would create %d remote directories", sm.result.DirsCreated)
+	} else {
+		log.Printf("📂 Created %d remote directories", sm.result.DirsCreated)
+	}
+	return nil
+}
+
+// scaffoldFolder creates the directories of one folder pair and returns how
+// many were missing
+func (sm *SyncManager) scaffoldFolder(localFolder, remoteFolder string, ignorePatterns []string) (int, error) {
+	log.Printf("Creating the directory tree of '%s' in '%s'...", localFolder, remoteFolder)
+
+	localRoot, err := filepath.EvalSymlinks(localFolder)
+	if err == nil {
+		localRoot, err = filepath.Abs(localRoot)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve local folder '%s': %w", localFolder, err)
+	}
+	remotePath, err := sm.resolveRemotePath(remoteFolder)
+	if err != nil {
+		return 0, err
+	}
+	if err := sm.checkAllowedRoot(remotePath); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	createDir := func(remoteDir string) error {
+		if _, err := sm.sftpClient.Stat(remoteDir); err == nil {
+			return nil
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check %s: %w", remoteDir, err)
+		}
+		created++
+		if sm.config.DryRun {
+			log.Printf("🔎 Would create remote directory: %s", remoteDir)
+			return nil
+		}
+		sm.verbosef("Creating %s", remoteDir)
+		if err := sm.ensureRemoteDir(remoteDir); err != nil {
+			return err
+		}
+		sm.applyDirMode(remoteDir)
+		return nil
+	}
+
+	if err := createDir(remotePath); err != nil {
+		return created, err
+	}
+	result := &FolderResult{LocalFolder: localFolder, RemoteFolder: remoteFolder}
+	if _, err := sm.scanLocalFolder(localFolder, localRoot, remotePath, remoteFolder == sm.config.RemoteFolder, ignorePatterns, result, createDir); err != nil {
+		return created, fmt.Errorf("failed to create the directory tree: %w", err)
+	}
+	return created, nil
+}
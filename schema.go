@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// configKey describes one config file key for the JSON Schema and validate mode
+type configKey struct {
+	name string
+	// kind is string, boolean, integer, duration, size, mode, list or enum
+	kind string
+	// values are the choices of an enum
+	values []string
+	// min is the smallest integer accepted
+	min int
+	// repeatable keys add to their value each time they appear
+	repeatable  bool
+	description string
+}
+
+// configKeys lists every key setValue accepts, aliases included
+var configKeys = []configKey{
+	{name: "TRANSPORT", kind: "enum", values: []string{"ssh", "local"}, description: "ssh, or local to deploy to this machine"},
+	{name: "TRANSFER_PROTOCOL", kind: "enum", values: []string{"sftp", "scp"}, description: "sftp, or scp for servers without an SFTP subsystem"},
+	{name: "REMOTE_SERVER", kind: "string", description: "Server host or host:port; several separated by commas"},
+	{name: "MAX_PARALLEL_TARGETS", kind: "integer", min: 1, description: "How many servers are deployed to at once"},
+	{name: "CONTINUE_ON_DOCKER_ERROR", kind: "boolean", description: "Keep deploying to the other servers after Docker operations fail on one"},
+	{name: "DEPLOY_STATE_FILE", kind: "string", description: "Where a push to several targets records the ones that succeeded"},
+	{name: "RESUME", kind: "boolean", description: "Continue an unfinished push to several targets, skipping the ones that succeeded"},
+	{name: "RESTART", kind: "boolean", description: "Push to all targets, ignoring an unfinished push"},
+	{name: "PARALLEL_DOWNLOADS", kind: "integer", min: 1, description: "How many files pull downloads at once"},
+	{name: "SSH_USERNAME", kind: "string", description: "SSH user name"},
+	{name: "SSH_PASSWORD", kind: "string", description: "SSH password; asked for on the terminal if unset"},
+	{name: "SSH_KEY_FILE", kind: "string", repeatable: true, description: "Private key file, or several separated by commas; every line adds to the list"},
+	{name: "SSH_KEY_FILES", kind: "string", repeatable: true, description: "Same as SSH_KEY_FILE, adding to the same list"},
+	{name: "HOST_KEY_FINGERPRINT", kind: "list", repeatable: true, description: "SHA256 fingerprints the host key must match"},
+	{name: "HOST_CA_KEY", kind: "string", description: "Certificate authority that signs the host key, as a file or inline"},
+	{name: "AUTH_ORDER", kind: "list", description: "Auth methods in order: agent, key, password, keyboard-interactive"},
+	{name: "VERBOSE", kind: "boolean", description: "Log extra detail"},
+	{name: "SSH_CONTROL_PATH", kind: "string", description: "OpenSSH ControlMaster socket to reuse"},
+	{name: "PROXY", kind: "string", description: "socks5://, socks5h:// or http:// proxy to connect through, or none"},
+	{name: "SSH_COMPRESSION", kind: "boolean", description: "Compress the SSH connection; not supported by the Go SSH library yet, so only false is accepted"},
+	{name: "SSH_CIPHERS", kind: "list", description: "Allowed SSH ciphers"},
+	{name: "SSH_KEX", kind: "list", description: "Allowed SSH key exchanges"},
+	{name: "SSH_MACS", kind: "list", description: "Allowed SSH MACs"},
+	{name: "SHOW_BANNER", kind: "boolean", description: "Print the server's SSH banner"},
+	{name: "BATCH_MODE", kind: "boolean", description: "Never prompt; fail instead"},
+	{name: "REMOTE_FOLDER", kind: "string", description: "Destination folder on the server; supports ~ and {{.Date}}-style templates"},
+	{name: "UPDATE_SYMLINK", kind: "string", description: "Remote symlink pointed at REMOTE_FOLDER after a successful push"},
+	{name: "LOCAL_FOLDER", kind: "string", description: "Local folder to sync (defaults to the current directory)"},
+	{name: "FROM_GIT_REF", kind: "string", description: "Push this commit instead of the working tree"},
+	{name: "REQUIRE_CLEAN_GIT", kind: "boolean", description: "Refuse to push uncommitted changes"},
+	{name: "FORCE", kind: "boolean", description: "Push despite REQUIRE_CLEAN_GIT and UPLOAD_NEWER_ONLY, and FRESH without a terminal"},
+	{name: "LOCAL_FOLDER_2", kind: "string", description: "Secondary local folder"},
+	{name: "REMOTE_FOLDER_2", kind: "string", description: "Secondary remote folder"},
+	{name: "IGNORE", kind: "list", repeatable: true, description: "Patterns excluded from the sync"},
+	{name: "IGNORE_2", kind: "list", repeatable: true, description: "Patterns excluded from the secondary folder pair"},
+	{name: "NO_DEFAULT_IGNORES", kind: "boolean", description: "Turn off the built-in ignore patterns"},
+	{name: "IGNORE_HIDDEN", kind: "boolean", description: "Ignore every hidden file and directory"},
+	{name: "ALLOWED_EXTENSIONS", kind: "list", repeatable: true, description: "Only sync files with these extensions (.py) or matching these patterns (Makefile)"},
+	{name: "IGNORE_CASE", kind: "boolean", description: "Match patterns regardless of upper and lower case"},
+	{name: "SINCE", kind: "string", description: "Only push files modified since a duration ago or an RFC3339 time"},
+	{name: "MAX_DEPTH", kind: "integer", min: 0, description: "Deepest directory level walked; 0 is unlimited"},
+	{name: "MAX_FILES", kind: "integer", min: 0, description: "Most files a folder may have; 0 turns the limit off"},
+	{name: "CONFIRM_UPLOAD_SIZE", kind: "size", description: "Ask before a push uploads more than this from a folder; 0 never asks"},
+	{name: "COMPARE", kind: "enum", values: []string{"size+mtime", "size", "mtime", "checksum"}, description: "How files are judged up to date"},
+	{name: "MTIME_TOLERANCE", kind: "duration", description: "How much older a copy may be and still be up to date"},
+	{name: "UPLOAD_NEWER_ONLY", kind: "boolean", description: "Leave remote files that are newer than the local ones"},
+	{name: "FRESH", kind: "boolean", description: "Empty REMOTE_FOLDER, upload every file and build without the Docker cache"},
+	{name: "SKIP_LOCKED", kind: "boolean", description: "On Windows, skip files another program has locked instead of failing the push"},
+	{name: "HASH_CACHE", kind: "boolean", description: "Skip files whose hash matches the last push"},
+	{name: "EXTRA_FILES", kind: "list", repeatable: true, description: "local_path=remote_path pairs uploaded after the sync"},
+	{name: "ALWAYS_UPLOAD", kind: "list", repeatable: true, description: "Patterns of files uploaded on every push"},
+	{name: "BACKUP_ON_OVERWRITE", kind: "list", repeatable: true, description: "Patterns of remote files backed up before being overwritten"},
+	{name: "BACKUP_KEEP", kind: "integer", min: 1, description: "Backups kept per file"},
+	{name: "SHOW_DIFF", kind: "boolean", description: "Show a diff of each changed text file before uploading it"},
+	{name: "PICK_FILES", kind: "boolean", description: "Choose which changed files to upload before a push"},
+	{name: "SHOW_DIFF_MAX_SIZE", kind: "size", description: "Largest file SHOW_DIFF diffs"},
+	{name: "VERIFY_AFTER", kind: "enum", values: []string{"false", "true", "size", "checksum"}, description: "Re-check the remote files after syncing"},
+	{name: "EXECUTABLE", kind: "list", repeatable: true, description: "Patterns of files made executable on the remote"},
+	{name: "PULL_EXECUTABLE", kind: "list", repeatable: true, description: "Patterns of files made executable when pulled"},
+	{name: "PERMISSIONS_FILE", kind: "string", description: "Rules file setting remote modes by pattern"},
+	{name: "DEFAULT_FILE_MODE", kind: "mode", description: "Mode of every uploaded file, e.g. 0644"},
+	{name: "DEFAULT_DIR_MODE", kind: "mode", description: "Mode of every synced directory, e.g. 0755"},
+	{name: "PERMS_SIDECAR", kind: "boolean", description: "Take file modes from .pooshit-perms"},
+	{name: "LINE_ENDINGS", kind: "enum", values: []string{"keep", "lf"}, description: "Convert CRLF to LF in uploaded text files"},
+	{name: "LINE_ENDINGS_FILES", kind: "list", repeatable: true, description: "Patterns of the files LINE_ENDINGS applies to"},
+	{name: "SYMLINK_ESCAPE", kind: "enum", values: []string{"skip", "fail", "allow"}, description: "What to do with symlinks that leave the local folder"},
+	{name: "SYMLINK_STAT", kind: "enum", values: []string{"follow", "nofollow"}, description: "Whether change detection follows symlinks"},
+	{name: "STAGING_DIR", kind: "string", description: "Remote directory synced into and then swapped into place"},
+	{name: "AUDIT_COMMANDS", kind: "boolean", description: "Log every remote command"},
+	{name: "COMMAND_ALLOWLIST", kind: "list", repeatable: true, description: "Prefixes every simple command of a remote command must start with"},
+	{name: "STALL_WARNING", kind: "string", description: "Warn when the build is silent this long, e.g. 5m, or off"},
+	{name: "DEV_POLL_INTERVAL", kind: "duration", description: "How often dev mode checks the local folders for changes"},
+	{name: "DEV_DEBOUNCE", kind: "duration", description: "How long files must stay unchanged before dev mode deploys them"},
+	{name: "IDLE_TIMEOUT", kind: "string", description: "Close the connection after dev mode has seen no changes this long, e.g. 10m, or off"},
+	{name: "RUN_RETRIES", kind: "integer", min: 0, description: "Retries of docker run while the old container lets go"},
+	{name: "REMOTE_CMD_PREFIX", kind: "string", description: "Prepended to every Docker command, e.g. nice -n 19"},
+	{name: "VERIFY_IMAGE", kind: "boolean", description: "Check the build produced the image"},
+	{name: "KEEP_IMAGES", kind: "integer", min: 1, description: "Timestamped image tags kept for rollbacks"},
+	{name: "CONTAINER_ID_FORMAT", kind: "enum", values: []string{"full", "short", "name"}, description: "How the started container is named"},
+	{name: "CONTAINER_ID_FILE", kind: "string", description: "Local file the started container's ID is written to"},
+	{name: "SYNC_ONLY", kind: "boolean", description: "Only transfer files; never run remote commands"},
+	{name: "NO_SSH_EXEC", kind: "boolean", description: "Same as SYNC_ONLY"},
+	{name: "REMOTE_ROOT", kind: "string", description: "Remote directory ~/ resolves against over SFTP"},
+	{name: "PUT_MODE", kind: "mode", description: "Mode of the file put mode writes, e.g. 0644"},
+	{name: "ALLOWED_REMOTE_ROOT", kind: "string", description: "Directory every remote write must be inside"},
+	{name: "ONLY_DOCKER", kind: "boolean", description: "Skip the file sync and only run the Docker steps"},
+	{name: "SCAFFOLD", kind: "boolean", description: "Only create the remote directory tree"},
+	{name: "BUILD_FROM_TAR", kind: "boolean", description: "Build from one uploaded tarball"},
+	{name: "DELTA", kind: "boolean", description: "Send only the changed data of large files, rsync style"},
+	{name: "DELTA_MIN_SIZE", kind: "size", description: "Smallest file sent as a delta"},
+	{name: "CONCURRENCY_PER_FILE", kind: "integer", min: 1, description: "Parallel chunks per large upload"},
+	{name: "COPY_BUFFER_SIZE", kind: "size", description: "Size of each transfer chunk"},
+	{name: "MAX_SSH_SESSIONS", kind: "integer", min: 2, description: "Most SSH sessions open at once"},
+	{name: "MAX_CONCURRENT_SESSIONS", kind: "integer", min: 0, description: "Most SSH sessions open at once across all targets; 0 is unlimited"},
+	{name: "OWNED_MANIFEST", kind: "string", description: "Remote manifest of paths another tool owns"},
+	{name: "DRY_RUN", kind: "boolean", description: "Show what a push would do without changing anything"},
+	{name: "SUMMARY_FILE", kind: "string", description: "Local JSON file summarizing each run"},
+	{name: "MANIFEST_FILE", kind: "string", description: "Local JSON manifest of what each push left on the server"},
+	{name: "BUILD_LOG", kind: "string", description: "Local file the output of each build is saved to"},
+	{name: "DOCKER_IMAGE_NAME", kind: "string", description: "Image to build and run"},
+	{name: "DOCKER_BUILD_ARGS", kind: "string", description: "Arguments for docker build"},
+	{name: "DOCKER_RUN_ARGS", kind: "string", description: "Arguments for docker run"},
+	{name: "DOCKER_SUDO", kind: "boolean", description: "Run docker with sudo"},
+	{name: "DOCKER_SSH_USER", kind: "string", description: "User the Docker commands run as"},
+	{name: "REMOVE_OLD_IMAGE", kind: "boolean", description: "Remove the old image before building"},
+	{name: "CLEANUP_CMD", kind: "string", description: "Remote command listing the IDs of the containers a deploy stops and removes; supports {{.Image}} and {{.Docker}}"},
+	{name: "NO_DOCKERFILE_CHECK", kind: "boolean", description: "Skip the Dockerfile checks"},
+	{name: "STRICT_DOCKERFILE", kind: "boolean", description: "Fail instead of warning about a missing Dockerfile"},
+	{name: "STRICT_ENV", kind: "boolean", description: "Fail on unset environment variables in Docker args"},
+	{name: "ZERO_DOWNTIME", kind: "boolean", description: "Start the new container before stopping the old one"},
+	{name: "HEALTH_CHECK_TIMEOUT", kind: "duration", description: "How long ZERO_DOWNTIME waits for the new container"},
+	{name: "BEFORE_STOP_CMD", kind: "string", description: "Remote command run before the old container stops"},
+	{name: "BETWEEN_STOP_AND_BUILD_CMD", kind: "string", description: "Remote command run between stopping and building"},
+	{name: "BEFORE_RUN_CMD", kind: "string", description: "Remote command run before the new container starts"},
+	{name: "AFTER_RUN_CMD", kind: "string", description: "Remote command run after the new container starts"},
+}
+
+// lookupConfigKey returns the description of a key, if it is one
+func lookupConfigKey(name string) (configKey, bool) {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key, true
+		}
+	}
+	return configKey{}, false
+}
+
+// truthyValues are the values parseBool reads as true, in YAML's forms and pooshit's
+var truthyValues = []any{true, "true", "yes", "on", "1"}
+
+// jsonSchema describes the key's values. YAML tools read true, 3 or 0644
+// as booleans and numbers, while pooshit reads every value as text, so both
+// forms are accepted.
+func (k configKey) jsonSchema() map[string]any {
+	s := map[string]any{"description": k.description}
+	switch k.kind {
+	case "boolean":
+		s["enum"] = append(append([]any{}, truthyValues...), false, "false", "no", "off", "0")
+	case "integer":
+		s["type"] = []string{"integer", "string"}
+		s["minimum"] = k.min
+	case "size", "mode":
+		s["type"] = []string{"string", "integer"}
+	case "enum":
+		var values []any
+		for _, v := range k.values {
+			values = append(values, v)
+			// VERIFY_AFTER also takes a plain true or false
+			if v == "true" || v == "false" {
+				values = append(values, v == "true")
+			}
+		}
+		s["enum"] = values
+	default:
+		s["type"] = "string"
+	}
+	return s
+}
+
+// configSchema returns the JSON Schema of a config file. The required keys
+// depend on TRANSPORT and SYNC_ONLY, as they do when the config is loaded.
+func configSchema() map[string]any {
+	properties := make(map[string]any, len(configKeys))
+	for _, key := range configKeys {
+		properties[key.name] = key.jsonSchema()
+	}
+	// set matches a config that gives key one of values
+	set := func(key string, values []any) map[string]any {
+		return map[string]any{"properties": map[string]any{key: map[string]any{"enum": values}}, "required": []string{key}}
+	}
+	return map[string]any{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "pooshit config",
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+		"required":             []string{"REMOTE_FOLDER"},
+		"allOf": []any{
+			map[string]any{
+				"if":   set("TRANSPORT", []any{"local"}),
+				"else": map[string]any{"required": []string{"REMOTE_SERVER", "SSH_USERNAME"}},
+			},
+			map[string]any{
+				"if":   map[string]any{"anyOf": []any{set("SYNC_ONLY", truthyValues), set("NO_SSH_EXEC", truthyValues)}},
+				"else": map[string]any{"required": []string{"DOCKER_IMAGE_NAME"}},
+			},
+		},
+	}
+}
+
+// printSchema writes the JSON Schema of a config file to stdout
+func printSchema() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(configSchema()); err != nil {
+		return fmt.Errorf("failed to encode the schema: %w", err)
+	}
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
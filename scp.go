@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// statMarker tags the lines of stat output, so shell chatter around them is skipped
+const statMarker = "pooshit-stat:"
+
+// statFormat prints the raw mode in hex, size, mtime and path of a file
+const statFormat = statMarker + "%f %s %Y %n"
+
+// scpToolsProbe runs the commands of the scp backend that not every system
+// has, in a scratch directory: stat -c, truncate and mv -T are GNU coreutils,
+// and BusyBox builds or BSDs may lack them or take other options
+const scpToolsProbe = `d="${TMPDIR:-/tmp}/.pooshit-probe-$$" && mkdir -- "$d" && trap 'rm -rf -- "$d"' EXIT && ` +
+	`: > "$d/a" && stat -c '` + statFormat + `' -- "$d/a" >/dev/null && truncate -s 1 -- "$d/a" && mv -f -T -- "$d/a" "$d/b"`
+
+// parseTransferProtocol validates a TRANSFER_PROTOCOL value
+func parseTransferProtocol(value string) (string, error) {
+	switch protocol := strings.ToLower(value); protocol {
+	case "", "sftp":
+		return "sftp", nil
+	case "scp":
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("expected sftp or scp, got %q", value)
+	}
+}
+
+// sftpUnavailable reports whether creating the SFTP client failed because
+// the server has no SFTP subsystem
+func sftpUnavailable(err error) bool {
+	return strings.Contains(err.Error(), "subsystem request failed")
+}
+
+// connectSCP stands in for the SFTP connection on servers without an SFTP
+// subsystem. An SFTP server runs in-process and carries out each request on
+// the remote: file contents go through scp, everything else through shell
+// commands. The rest of pooshit keeps using the same SFTP client.
+func (sm *SyncManager) connectSCP() error {
+	home, err := sm.execHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to run commands on the remote, which TRANSFER_PROTOCOL: scp needs: %w", err)
+	}
+	// Without them every request would fail later with a confusing error
+	if output, err := sm.executeRemoteCommandWithOutput(scpToolsProbe, false); err != nil {
+		return fmt.Errorf("TRANSFER_PROTOCOL: scp needs GNU coreutils on the remote (stat -c, truncate, mv -T), and they failed there, as on BusyBox or BSD systems; install coreutils or enable SFTP: %s", strings.TrimSpace(output))
+	}
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	handler := &scpHandler{sm: sm, writes: make(map[string]*scpWriter)}
+	server := sftp.NewRequestServer(pipeConn{serverReader, serverWriter, serverReader},
+		sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler},
+		sftp.WithStartDirectory(home))
+	go func() {
+		if err := server.Serve(); err != nil && err != io.EOF {
+			sm.verbosef("SCP request server stopped: %v", err)
+		}
+		server.Close()
+	}()
+
+	sm.acquireSession()
+	sftpClient, err := sftp.NewClientPipe(clientReader, clientWriter, sm.sftpOptions()...)
+	if err != nil {
+		sm.releaseSession()
+		clientWriter.Close()
+		return fmt.Errorf("failed to start the SCP transfer backend: %w", err)
+	}
+	sm.sftpClient = sftpClient
+	log.Printf("ℹ️  Transferring files with scp (TRANSFER_PROTOCOL: scp)")
+	return nil
+}
+
+// scpHandler serves the in-process SFTP server for TRANSFER_PROTOCOL: scp
+type scpHandler struct {
+	sm *SyncManager
+
+	// writes holds the files open for writing, so a chmod or truncate on an
+	// open handle applies to the upload instead of the remote file
+	mu     sync.Mutex
+	writes map[string]*scpWriter
+}
+
+// run runs a shell command on the remote and turns a failure into the
+// matching SFTP status
+func (h *scpHandler) run(cmd string) ([]byte, error) {
+	session, err := h.sm.newSession()
+	if err != nil {
+		return nil, err
+	}
+	defer h.sm.closeSession(session)
+
+	output, err := session.CombinedOutput(cmd)
+	if err != nil {
+		return nil, scpStatusError(strings.TrimSpace(string(output)), err)
+	}
+	return output, nil
+}
+
+// scpStatusError maps a remote error message to an SFTP status, so callers
+// can still tell a missing file from other failures
+func scpStatusError(message string, err error) error {
+	if message == "" {
+		message = err.Error()
+	}
+	switch {
+	case strings.Contains(message, "No such file or directory"):
+		return fmt.Errorf("%s: %w", message, sftp.ErrSSHFxNoSuchFile)
+	case strings.Contains(message, "Permission denied"):
+		return fmt.Errorf("%s: %w", message, sftp.ErrSSHFxPermissionDenied)
+	default:
+		return fmt.Errorf("%s: %w", message, sftp.ErrSSHFxFailure)
+	}
+}
+
+// Fileread downloads the file with scp into a temporary file to read from
+func (h *scpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	f, err := os.CreateTemp("", "pooshit-scp-")
+	if err != nil {
+		return nil, err
+	}
+	temp := &scpTempFile{f}
+	if err := h.sm.scpDownload(r.Filepath, temp); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	return temp, nil
+}
+
+// Filewrite collects the written data in a temporary file, which is uploaded
+// with scp when the handle is closed. A file opened without truncation
+// starts out with its current remote contents, so writes at an offset work.
+func (h *scpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	f, err := os.CreateTemp("", "pooshit-scp-")
+	if err != nil {
+		return nil, err
+	}
+	w := &scpWriter{handler: h, remotePath: r.Filepath, temp: &scpTempFile{f}, mode: 0644}
+	if !r.Pflags().Trunc {
+		if err := h.sm.scpDownload(r.Filepath, w.temp); err != nil && !errors.Is(err, sftp.ErrSSHFxNoSuchFile) {
+			w.temp.Close()
+			return nil, err
+		}
+	}
+
+	h.mu.Lock()
+	h.writes[r.Filepath] = w
+	h.mu.Unlock()
+	return w, nil
+}
+
+// Filecmd carries out the SFTP commands as shell commands
+func (h *scpHandler) Filecmd(r *sftp.Request) error {
+	p := shellQuote(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r)
+	case "Rename":
+		// SFTP rename doesn't replace an existing file
+		target := shellQuote(r.Target)
+		_, err := h.run(fmt.Sprintf("if [ -e %s ] || [ -L %s ]; then echo 'File exists' >&2; exit 1; fi; mv -- %s %s", target, target, p, target))
+		return err
+	case "Rmdir":
+		_, err := h.run("rmdir -- " + p)
+		return err
+	case "Mkdir":
+		_, err := h.run("mkdir -- " + p)
+		return err
+	case "Remove":
+		_, err := h.run("rm -- " + p)
+		return err
+	case "Symlink":
+		// r.Filepath is the link's target, r.Target the link itself
+		_, err := h.run(fmt.Sprintf("ln -s -- %s %s", p, shellQuote(r.Target)))
+		return err
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename replaces the target in one step, like the OpenSSH extension
+func (h *scpHandler) PosixRename(r *sftp.Request) error {
+	_, err := h.run(fmt.Sprintf("mv -f -T -- %s %s", shellQuote(r.Filepath), shellQuote(r.Target)))
+	return err
+}
+
+// setstat applies a mode or size change, to the pending upload if the file is open for writing
+func (h *scpHandler) setstat(r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.UidGid || flags.Acmodtime {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+
+	h.mu.Lock()
+	w := h.writes[r.Filepath]
+	h.mu.Unlock()
+	if w != nil {
+		if flags.Permissions {
+			w.setMode(attrs.FileMode().Perm())
+		}
+		if flags.Size {
+			return w.temp.Truncate(int64(attrs.Size))
+		}
+		return nil
+	}
+
+	p := shellQuote(r.Filepath)
+	if flags.Permissions {
+		if _, err := h.run(fmt.Sprintf("chmod %o %s", attrs.FileMode().Perm(), p)); err != nil {
+			return err
+		}
+	}
+	if flags.Size {
+		if _, err := h.run(fmt.Sprintf("truncate -s %d -- %s", attrs.Size, p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist lists a directory, stats a file or reads a link with shell commands
+func (h *scpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		output, err := h.run(fmt.Sprintf("cd %s && find . -mindepth 1 -maxdepth 1 -exec stat -c '%s' -- {} +", shellQuote(r.Filepath), statFormat))
+		if err != nil {
+			return nil, err
+		}
+		return scpLister(parseStatOutput(output)), nil
+	case "Stat":
+		return h.stat(r.Filepath, "-L")
+	case "Readlink":
+		output, err := h.run("readlink -- " + shellQuote(r.Filepath))
+		if err != nil {
+			return nil, err
+		}
+		target := strings.TrimSpace(string(output))
+		return scpLister{&scpFileInfo{name: target, mode: os.ModeSymlink}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+// Lstat stats a path without following a final symlink
+func (h *scpHandler) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	return h.stat(r.Filepath, "")
+}
+
+// stat runs stat on one path; flag is "-L" to follow symlinks
+func (h *scpHandler) stat(remotePath, flag string) (sftp.ListerAt, error) {
+	output, err := h.run(fmt.Sprintf("stat %s -c '%s' -- %s", flag, statFormat, shellQuote(remotePath)))
+	if err != nil {
+		return nil, err
+	}
+	infos := parseStatOutput(output)
+	if len(infos) != 1 {
+		return nil, fmt.Errorf("unexpected stat output for %s: %q", remotePath, output)
+	}
+	return scpLister(infos), nil
+}
+
+// parseStatOutput reads the statFormat lines of a stat command
+func parseStatOutput(output []byte) []os.FileInfo {
+	var infos []os.FileInfo
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		_, line, found := strings.Cut(scanner.Text(), statMarker)
+		if !found {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		rawMode, err1 := strconv.ParseUint(fields[0], 16, 32)
+		size, err2 := strconv.ParseInt(fields[1], 10, 64)
+		mtime, err3 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		infos = append(infos, &scpFileInfo{
+			name:    path.Base(fields[3]),
+			size:    size,
+			mode:    unixFileMode(uint32(rawMode)),
+			modTime: time.Unix(mtime, 0),
+		})
+	}
+	return infos
+}
+
+// unixFileMode converts a raw st_mode to an os.FileMode
+func unixFileMode(raw uint32) os.FileMode {
+	mode := os.FileMode(raw & 0777)
+	switch raw & 0170000 {
+	case 0040000:
+		mode |= os.ModeDir
+	case 0120000:
+		mode |= os.ModeSymlink
+	case 0010000:
+		mode |= os.ModeNamedPipe
+	case 0140000:
+		mode |= os.ModeSocket
+	case 0020000:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case 0060000:
+		mode |= os.ModeDevice
+	}
+	if raw&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if raw&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if raw&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// scpFileInfo is a remote file as reported by stat
+type scpFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *scpFileInfo) Name() string       { return fi.name }
+func (fi *scpFileInfo) Size() int64        { return fi.size }
+func (fi *scpFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *scpFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *scpFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *scpFileInfo) Sys() interface{}   { return nil }
+
+// scpLister hands a fixed list of entries to the SFTP server
+type scpLister []os.FileInfo
+
+func (l scpLister) ListAt(entries []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(entries, l[offset:])
+	if n < len(entries) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// scpTempFile is a local temporary file that is deleted when closed
+type scpTempFile struct {
+	*os.File
+}
+
+func (f *scpTempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// scpWriter is a remote file open for writing
+type scpWriter struct {
+	handler    *scpHandler
+	remotePath string
+	temp       *scpTempFile
+
+	mu      sync.Mutex
+	mode    os.FileMode
+	modeSet bool
+}
+
+func (w *scpWriter) WriteAt(p []byte, off int64) (int, error) {
+	return w.temp.WriteAt(p, off)
+}
+
+func (w *scpWriter) setMode(mode os.FileMode) {
+	w.mu.Lock()
+	w.mode, w.modeSet = mode, true
+	w.mu.Unlock()
+}
+
+// Close uploads the collected data. scp only applies the mode to new files,
+// so a mode set on the handle is applied to an existing one afterwards.
+func (w *scpWriter) Close() error {
+	w.handler.mu.Lock()
+	delete(w.handler.writes, w.remotePath)
+	w.handler.mu.Unlock()
+	defer w.temp.Close()
+
+	w.mu.Lock()
+	mode, modeSet := w.mode, w.modeSet
+	w.mu.Unlock()
+	if err := w.handler.sm.scpUpload(w.temp.File, w.remotePath, mode); err != nil {
+		return err
+	}
+	if modeSet {
+		if _, err := w.handler.run(fmt.Sprintf("chmod %o %s", mode, shellQuote(w.remotePath))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scpUpload sends the contents of f to remotePath with the scp protocol
+func (sm *SyncManager) scpUpload(f *os.File, remotePath string, mode os.FileMode) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return sm.scpExchange("scp -t "+shellQuote(remotePath), func(stdin io.Writer, stdout *bufio.Reader) error {
+		if err := scpAck(stdout); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdin, "C%04o %d %s\n", mode.Perm(), info.Size(), path.Base(remotePath))
+		if err := scpAck(stdout); err != nil {
+			return err
+		}
+		if _, err := io.Copy(stdin, io.NewSectionReader(f, 0, info.Size())); err != nil {
+			return err
+		}
+		stdin.Write([]byte{0})
+		return scpAck(stdout)
+	})
+}
+
+// scpDownload receives remotePath with the scp protocol and writes it to w
+func (sm *SyncManager) scpDownload(remotePath string, w io.Writer) error {
+	return sm.scpExchange("scp -f "+shellQuote(remotePath), func(stdin io.Writer, stdout *bufio.Reader) error {
+		stdin.Write([]byte{0})
+		header, err := scpMessage(stdout)
+		if err != nil {
+			return err
+		}
+		// "C<mode> <size> <name>"
+		fields := strings.SplitN(header, " ", 3)
+		if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+			return fmt.Errorf("unexpected scp header %q", header)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected scp header %q", header)
+		}
+		stdin.Write([]byte{0})
+		if _, err := io.CopyN(w, stdout, size); err != nil {
+			return err
+		}
+		if err := scpAck(stdout); err != nil {
+			return err
+		}
+		stdin.Write([]byte{0})
+		return nil
+	})
+}
+
+// scpExchange runs a remote scp in sink (-t) or source (-f) mode and
+// speaks the protocol with it through talk
+func (sm *SyncManager) scpExchange(cmd string, talk func(stdin io.Writer, stdout *bufio.Reader) error) error {
+	session, err := sm.newSession()
+	if err != nil {
+		return err
+	}
+	defer sm.closeSession(session)
+
+	piper, ok := session.(stdinPiper)
+	if !ok {
+		return fmt.Errorf("this session can't send input to scp")
+	}
+	stdin, err := piper.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.Start(cmd); err != nil {
+		return err
+	}
+
+	talkErr := talk(stdin, bufio.NewReader(stdout))
+	stdin.Close()
+	waitErr := session.Wait()
+	if talkErr != nil {
+		return talkErr
+	}
+	if waitErr != nil {
+		return fmt.Errorf("%s: %w", cmd, waitErr)
+	}
+	return nil
+}
+
+// scpAck reads the one-byte reply to an scp protocol step
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("scp closed the connection: %w", err)
+	}
+	if b == 0 {
+		return nil
+	}
+	message, _ := r.ReadString('\n')
+	return scpStatusError(strings.TrimSpace(message), fmt.Errorf("scp failed"))
+}
+
+// scpMessage reads a protocol line, which is either a message or an error
+func scpMessage(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("scp closed the connection: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line != "" && (line[0] == 1 || line[0] == 2) {
+		return "", scpStatusError(strings.TrimSpace(line[1:]), fmt.Errorf("scp failed"))
+	}
+	return line, nil
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestSCPToolsProbe(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the probe expects GNU coreutils")
+	}
+	if output, err := exec.Command("sh", "-c", scpToolsProbe).CombinedOutput(); err != nil {
+		t.Errorf("probe failed with GNU coreutils: %v: %s", err, output)
+	}
+	// Without GNU tools on the PATH the probe must fail rather than pass quietly
+	cmd := exec.Command("sh", "-c", scpToolsProbe)
+	cmd.Env = []string{"PATH=" + t.TempDir()}
+	if err := cmd.Run(); err == nil {
+		t.Error("probe passed without stat, truncate and mv")
+	}
+}
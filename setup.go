@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// promptRequired keeps prompting until a non-empty value is entered
+func promptRequired(prompt, defaultValue string) string {
+	for {
+		if value := promptInput(prompt, defaultValue); value != "" {
+			return value
+		}
+		fmt.Println("   A value is required")
+	}
+}
+
+// defaultKeyFile returns the first common SSH private key found in ~/.ssh
+func defaultKeyFile() string {
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		path := "~/.ssh/" + name
+		if _, err := os.Stat(expandLocalHome(path)); err == nil {
+			return path
+		}
+	}
+	return "~/.ssh/id_ed25519"
+}
+
+// runSetup interactively builds a config file, testing the SSH connection before saving it
+func runSetup(path string) error {
+	fmt.Println("\n💩 Pooshit setup - let's get you connected")
+	fmt.Println("─────────────────────────────────────────")
+
+	if _, err := os.Stat(path); err == nil {
+		if !confirmAction(fmt.Sprintf("%s already exists. Overwrite it?", path)) {
+			return fmt.Errorf("cancelled, %s left unchanged", path)
+		}
+	}
+
+	projectName := "your_project"
+	if cwd, err := os.Getwd(); err == nil {
+		projectName = filepath.Base(cwd)
+	}
+
+	config := defaultConfig()
+	config.RemoteServer = promptRequired("Remote server (host or host:port)", "")
+	config.SSHUsername = promptRequired("SSH username", os.Getenv("USER"))
+
+	for config.SSHPassword == "" && config.SSHKeyFile == "" {
+		switch strings.ToLower(promptInput("Auth method (key/password)", "key")) {
+		case "key":
+			config.SSHKeyFile = promptRequired("SSH private key file", defaultKeyFile())
+		case "password":
+			config.SSHPassword = promptRequired("SSH password (input is visible)", "")
+		default:
+			fmt.Println("   Please enter 'key' or 'password'")
+		}
+	}
+
+	config.RemoteFolder = promptRequired("Remote folder", "~/projects/"+projectName)
+	config.LocalFolder = promptInput("Local folder", "./")
+	config.DockerImageName = promptRequired("Docker image name", strings.ToLower(projectName))
+	config.DockerRunArgs = promptInput("Docker run arguments", "-d")
+
+	// Live connection test using the same logic as a real run
+	log.Println("\n🔌 Testing connection...")
+	syncManager, err := NewSyncManager(config)
+	if err != nil {
+		return err
+	}
+	if err := syncManager.Connect(); err != nil {
+		log.Printf("❌ Connection test failed: %v", err)
+		if !confirmAction("Save the config anyway?") {
+			return fmt.Errorf("connection test failed: %w", err)
+		}
+	} else {
+		syncManager.Close()
+	}
+
+	if err := writeConfigFile(path, config); err != nil {
+		return err
+	}
+	log.Printf("\n✅ Config written to %s", path)
+	log.Printf("   Run ./pooshit %s to deploy", path)
+	return nil
+}
+
+// writeConfigFile writes the settings collected by the setup wizard in pooshit's config format
+func writeConfigFile(path string, config *Config) error {
+	var b strings.Builder
+	b.WriteString("# Generated by pooshit setup\n\n")
+	b.WriteString("# Remote server connection details\n")
+	fmt.Fprintf(&b, "REMOTE_SERVER: %s\n", config.RemoteServer)
+	fmt.Fprintf(&b, "SSH_USERNAME: %s\n", config.SSHUsername)
+	if config.SSHKeyFile != "" {
+		fmt.Fprintf(&b, "SSH_KEY_FILE: %s\n", config.SSHKeyFile)
+	}
+	if config.SSHPassword != "" {
+		fmt.Fprintf(&b, "SSH_PASSWORD: %s\n", config.SSHPassword)
+	}
+	b.WriteString("\n# Folders\n")
+	fmt.Fprintf(&b, "REMOTE_FOLDER: %s\n", config.RemoteFolder)
+	fmt.Fprintf(&b, "LOCAL_FOLDER: %s\n", config.LocalFolder)
+	b.WriteString("\n# Docker configuration\n")
+	fmt.Fprintf(&b, "DOCKER_IMAGE_NAME: %s\n", config.DockerImageName)
+	b.WriteString("DOCKER_BUILD_ARGS: -t\n")
+	fmt.Fprintf(&b, "DOCKER_RUN_ARGS: %s\n", config.DockerRunArgs)
+	b.WriteString("\n# Ignore patterns (comma-separated), see pooshit_config.example\n")
+	fmt.Fprintf(&b, "# IGNORE: %s\n", strings.Join(defaultIgnorePatterns, ", "))
+
+	// The file may hold a password, so keep it private
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// prepareStaging makes STAGING_DIR a copy of the current remote folder, so
+// the sync into it only has to transfer what changed. It returns the
+// resolved staging and destination paths.
+func (sm *SyncManager) prepareStaging() (string, string, error) {
+	stagingPath, err := sm.resolveRemotePath(sm.config.StagingDir)
+	if err != nil {
+		return "", "", err
+	}
+	destPath, err := sm.resolveRemotePath(sm.config.RemoteFolder)
+	if err != nil {
+		return "", "", err
+	}
+	if err := sm.checkAllowedRoot(destPath); err != nil {
+		return "", "", err
+	}
+	if err := sm.checkStagingPath(stagingPath, destPath); err != nil {
+		return "", "", err
+	}
+
+	// Leftovers from an interrupted run are stale, so always start over
+	command := fmt.Sprintf("rm -rf %s", shellQuote(stagingPath))
+	if _, err := sm.sftpClient.Stat(destPath); err == nil {
+		command += fmt.Sprintf(" && cp -a %s %s", shellQuote(destPath), shellQuote(stagingPath))
+	}
+	log.Printf("📦 Staging in %s before swapping it into %s", stagingPath, destPath)
+	if output, err := sm.executeRemoteCommandWithOutput(command, false); err != nil {
+		return "", "", fmt.Errorf("failed to prepare staging directory %s: %w: %s", stagingPath, err, output)
+	}
+	return stagingPath, destPath, nil
+}
+
+// checkStagingPath refuses a STAGING_DIR that the rm -rf of prepareStaging
+// must not touch, with the guards of clean mode: relative paths, the root or
+// a top-level directory, the home directory, and REMOTE_FOLDER or a folder
+// that holds it
+func (sm *SyncManager) checkStagingPath(stagingPath, destPath string) error {
+	cleaned := path.Clean(stagingPath)
+	if !path.IsAbs(cleaned) {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it must resolve to an absolute path", stagingPath)
+	}
+	if strings.Count(cleaned, "/") < 2 {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it is the root or a top-level directory", cleaned)
+	}
+	if err := sm.checkAllowedRoot(cleaned); err != nil {
+		return err
+	}
+	if root, _ := sm.allowedRoot(); cleaned == root {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it is ALLOWED_REMOTE_ROOT itself", cleaned)
+	}
+
+	home, err := sm.getRemoteHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to determine remote home directory: %w", err)
+	}
+	if cleaned == path.Clean(home) {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it is the remote user's home directory", cleaned)
+	}
+
+	dest := path.Clean(destPath)
+	if cleaned == dest {
+		return fmt.Errorf("STAGING_DIR must differ from REMOTE_FOLDER")
+	}
+	if strings.HasPrefix(dest, cleaned+"/") {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it holds REMOTE_FOLDER %s", cleaned, dest)
+	}
+	if strings.HasPrefix(cleaned, dest+"/") {
+		return fmt.Errorf("refusing to use STAGING_DIR %q: it is inside REMOTE_FOLDER %s", cleaned, dest)
+	}
+	return nil
+}
+
+// swapStaging moves the synced staging directory into place. An existing
+// destination is renamed aside first and removed once the swap succeeds, so
+// it is only missing for the moment between the two renames. Both must be
+// on the same filesystem for the renames to work.
+func (sm *SyncManager) swapStaging(stagingPath, destPath string) error {
+	asidePath := fmt.Sprintf("%s.pooshit-old-%d", destPath, time.Now().Unix())
+
+	_, err := sm.sftpClient.Stat(destPath)
+	hadDest := err == nil
+	if hadDest {
+		if err := sm.sftpClient.Rename(destPath, asidePath); err != nil {
+			return fmt.Errorf("failed to move %s aside: %w", destPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat %s: %w", destPath, err)
+	}
+
+	if err := sm.sftpClient.Rename(stagingPath, destPath); err != nil {
+		if hadDest {
+			if restoreErr := sm.sftpClient.Rename(asidePath, destPath); restoreErr != nil {
+				log.Printf("❌ Could not restore %s from %s: %v", destPath, asidePath, restoreErr)
+			}
+		}
+		return fmt.Errorf("failed to move %s into place (STAGING_DIR must be on the same filesystem as REMOTE_FOLDER): %w", stagingPath, err)
+	}
+	log.Printf("✅ Swapped %s into %s", stagingPath, destPath)
+
+	if hadDest {
+		if err := sm.sftpClient.RemoveAll(asidePath); err != nil {
+			log.Printf("⚠️  Could not remove the previous tree %s: %v", asidePath, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestCheckStagingPath(t *testing.T) {
+	tests := []struct {
+		staging string
+		dest    string
+		root    string
+		ok      bool
+	}{
+		{staging: "/srv/app.staging", dest: "/srv/app", ok: true},
+		{staging: "/home/deploy/app.staging", dest: "/home/deploy/app", ok: true},
+		{staging: "/opt/apps/app.staging", dest: "/opt/apps/app", root: "/opt/apps", ok: true},
+		// Relative
+		{staging: "app.staging", dest: "/srv/app"},
+		// The root and top-level directories
+		{staging: "/", dest: "/srv/app"},
+		{staging: "/srv", dest: "/opt/app"},
+		{staging: "/srv/", dest: "/opt/app"},
+		// The home directory
+		{staging: "/home/deploy", dest: "/srv/app"},
+		{staging: "/home/deploy/", dest: "/srv/app"},
+		// REMOTE_FOLDER, a folder that holds it, or one inside it
+		{staging: "/srv/app", dest: "/srv/app"},
+		{staging: "/srv/app/../app", dest: "/srv/app"},
+		{staging: "/srv/apps", dest: "/srv/apps/web"},
+		{staging: "/srv/apps/web/..", dest: "/srv/apps/web"},
+		{staging: "/srv/app/staging", dest: "/srv/app"},
+		// ALLOWED_REMOTE_ROOT itself, or outside it
+		{staging: "/opt/apps", dest: "/opt/apps/app", root: "/opt/apps"},
+		{staging: "/srv/app.staging", dest: "/opt/apps/app", root: "/opt/apps"},
+	}
+	for _, tt := range tests {
+		sm := &SyncManager{config: &Config{AllowedRemoteRoot: tt.root}, homeDir: "/home/deploy"}
+		err := sm.checkStagingPath(tt.staging, tt.dest)
+		if tt.ok && err != nil {
+			t.Errorf("checkStagingPath(%q, %q) = %v, want nil", tt.staging, tt.dest, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("checkStagingPath(%q, %q) = nil, want an error", tt.staging, tt.dest)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// outOfSync is a local file whose remote copy is missing or differs
+type outOfSync struct {
+	relPath string
+	missing bool
+}
+
+// Status compares the local folders with the remote, using the same ignore
+// rules and COMPARE strategy as a push, and prints every file a push would
+// upload. It only reads: nothing is uploaded, no remote directory is created
+// and no Docker command is run. It returns the number of out-of-sync files.
+func (sm *SyncManager) Status() (int, error) {
+	if err := sm.loadOwnedManifest(); err != nil {
+		return 0, err
+	}
+
+	pairs := [][2]string{{sm.config.LocalFolder, sm.config.RemoteFolder}}
+	ignores := [][]string{sm.config.IgnorePatterns}
+	if sm.config.LocalFolder2 != "" {
+		pairs = append(pairs, [2]string{sm.config.LocalFolder2, sm.config.RemoteFolder2})
+		ignores = append(ignores, sm.config.IgnorePatterns2)
+	}
+
+	total := 0
+	for i, pair := range pairs {
+		diffs, checked, err := sm.folderStatus(pair[0], pair[1], ignores[i])
+		if err != nil {
+			return 0, err
+		}
+		for _, d := range diffs {
+			marker := "M"
+			if d.missing {
+				marker = "+"
+			}
+			fmt.Printf("%s %s\n", marker, filepath.ToSlash(filepath.Join(pair[1], d.relPath)))
+		}
+		log.Printf("   %s -> %s: %d checked, %d out of sync", pair[0], pair[1], checked, len(diffs))
+		total += len(diffs)
+	}
+	return total, nil
+}
+
+// folderStatus lists the files in one folder pair whose remote copy is
+// missing or not up to date, and how many files were checked
+func (sm *SyncManager) folderStatus(localFolder, remoteFolder string, ignorePatterns []string) ([]outOfSync, int, error) {
+	localInfo, err := os.Stat(localFolder)
+	if err != nil {
+		return nil, 0, fmt.Errorf("local folder '%s' does not exist or cannot be accessed: %w", localFolder, err)
+	}
+	if !localInfo.IsDir() {
+		return nil, 0, fmt.Errorf("local path '%s' is not a directory", localFolder)
+	}
+	localRoot, err := filepath.EvalSymlinks(localFolder)
+	if err == nil {
+		localRoot, err = filepath.Abs(localRoot)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to resolve local folder '%s': %w", localFolder, err)
+	}
+	remotePath, err := sm.resolveRemotePath(remoteFolder)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := &FolderResult{LocalFolder: localFolder, RemoteFolder: remoteFolder}
+	files, err := sm.scanLocalFolder(localFolder, localRoot, remotePath, remoteFolder == sm.config.RemoteFolder, ignorePatterns, result, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan local directory: %w", err)
+	}
+	cleanupConverted, err := sm.convertLineEndings(files, result)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cleanupConverted()
+
+	diffs, err := sm.outOfSyncFiles(files)
+	if err != nil {
+		return nil, 0, err
+	}
+	return diffs, len(files), nil
+}
+
+// outOfSyncFiles compares scanned files with their remote copies, using the
+// COMPARE strategy, and returns the ones that are missing or differ
+func (sm *SyncManager) outOfSyncFiles(files []syncFile) ([]outOfSync, error) {
+	var diffs []outOfSync
+	for _, file := range files {
+		remoteInfo, err := sm.remoteStat(file.remotePath)
+		if err == nil && isSymlink(remoteInfo) {
+			// A push leaves it alone, so it can't be out of sync
+			continue
+		}
+		if os.IsNotExist(err) {
+			diffs = append(diffs, outOfSync{relPath: file.relPath, missing: true})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", file.remotePath, err)
+		}
+		if !sm.upToDate(file, file.info, remoteInfo) {
+			diffs = append(diffs, outOfSync{relPath: file.relPath})
+		}
+	}
+	return diffs, nil
+}
+
+// runStatus checks every target and returns the exit status for status
+// mode: 0 when everything is in sync, 1 when any file is out of sync and 2
+// when a target couldn't be checked
+func runStatus(config *Config) int {
+	exitCode := 0
+	for _, target := range config.Targets() {
+		targetConfig := config.forTarget(target)
+		syncManager, err := NewSyncManager(targetConfig)
+		if err == nil {
+			err = syncManager.Connect()
+		}
+		if err != nil {
+			log.Printf("❌ Failed to connect to %s: %v", target, err)
+			exitCode = 2
+			continue
+		}
+		count, err := syncManager.Status()
+		syncManager.Close()
+		switch {
+		case err != nil:
+			log.Printf("❌ Status check failed on %s: %v", target, err)
+			exitCode = 2
+		case count > 0:
+			log.Printf("⚠️  %s: %d files out of sync", target, count)
+			if exitCode == 0 {
+				exitCode = 1
+			}
+		default:
+			log.Printf("✅ %s is in sync", target)
+		}
+	}
+	return exitCode
+}
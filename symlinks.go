@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxSymlinkHops is where resolving a chain of remote symlinks gives up, as Linux does
+const maxSymlinkHops = 40
+
+// parseSymlinkStat validates a SYMLINK_STAT value
+func parseSymlinkStat(value string) (string, error) {
+	switch mode := strings.ToLower(value); mode {
+	case "", "follow":
+		return "follow", nil
+	case "nofollow":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("expected follow or nofollow, got %q", value)
+	}
+}
+
+// isSymlink reports whether a file info describes the link itself
+func isSymlink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// remoteStat stats a remote path the same way on every server. SFTP's STAT
+// should follow symlinks, but some servers answer it like LSTAT, so this
+// always asks for LSTAT. With SYMLINK_STAT: follow, links are then resolved
+// here, one hop at a time, and the file they point to is returned. With
+// nofollow, a link's own info is returned.
+func (sm *SyncManager) remoteStat(remotePath string) (os.FileInfo, error) {
+	info, err := sm.sftpClient.Lstat(remotePath)
+	for hops := 0; err == nil && isSymlink(info) && sm.config.SymlinkStat == "follow"; hops++ {
+		if hops == maxSymlinkHops {
+			return nil, fmt.Errorf("too many levels of symlinks at %s", remotePath)
+		}
+		var target string
+		target, err = sm.sftpClient.ReadLink(remotePath)
+		if err != nil {
+			break
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(remotePath), target)
+		}
+		remotePath = target
+		info, err = sm.sftpClient.Lstat(remotePath)
+	}
+	return info, err
+}
+
+// localStat is remoteStat for a local path
+func (sm *SyncManager) localStat(localPath string) (os.FileInfo, error) {
+	if sm.config.SymlinkStat == "nofollow" {
+		return os.Lstat(localPath)
+	}
+	return os.Stat(localPath)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errExecDisabled is returned instead of opening an exec session in SYNC_ONLY mode
+var errExecDisabled = errors.New("remote commands are disabled by SYNC_ONLY")
+
+// checkSyncOnly rejects options that need remote commands when SYNC_ONLY
+// promises never to open an exec session
+func (c *Config) checkSyncOnly() error {
+	if !c.SyncOnly {
+		return nil
+	}
+	conflicts := []struct {
+		key string
+		set bool
+	}{
+		{"ONLY_DOCKER", c.OnlyDocker},
+		{"BUILD_FROM_TAR", c.BuildFromTar},
+		{"STAGING_DIR", c.StagingDir != ""},
+		{"VERIFY_AFTER: checksum", c.VerifyAfter == "checksum"},
+		{"TRANSFER_PROTOCOL: scp", c.TransferProtocol == "scp"},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return fmt.Errorf("%s needs remote commands, which SYNC_ONLY turns off", conflict.key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultShowDiffMaxSize keeps SHOW_DIFF to files small enough to read in a terminal
+const defaultShowDiffMaxSize = 64 << 10
+
+// diffContext is how many unchanged lines surround each change, as in diff -u
+const diffContext = 3
+
+// maxDiffCells bounds the line comparison table, so two large rewrites of a
+// file don't take a lot of memory to diff
+const maxDiffCells = 4 << 20
+
+// diffLine is one line of an edit script: ' ' kept, '-' removed or '+' added
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// splitLines splits text into lines without their line endings
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// isText reports whether data looks like text: valid UTF-8 without NUL bytes
+func isText(data []byte) bool {
+	return !bytes.Contains(data, []byte{0}) && utf8.Valid(data)
+}
+
+// diffLines returns the edit script turning a into b, based on their longest
+// common subsequence of lines. It returns false if the files are too
+// different to compare within maxDiffCells.
+func diffLines(a, b []string) ([]diffLine, bool) {
+	// Common leading and trailing lines don't need the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			switch {
+			case midA[i] == midB[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var script []diffLine
+	for _, line := range a[:prefix] {
+		script = append(script, diffLine{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			script = append(script, diffLine{' ', midA[i]})
+			i++
+			j++
+		case j == len(midB) || (i < len(midA) && lcs[i+1][j] >= lcs[i][j+1]):
+			script = append(script, diffLine{'-', midA[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', midB[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		script = append(script, diffLine{' ', line})
+	}
+	return script, true
+}
+
+// unifiedDiff formats the changes from a to b like diff -u
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	script, ok := diffLines(a, b)
+	if !ok {
+		return fmt.Sprintf("%s and %s differ (too many changes to show)\n", fromName, toName)
+	}
+
+	// Line numbers in a and b before each script position, for the hunk headers
+	aBefore := make([]int, len(script)+1)
+	bBefore := make([]int, len(script)+1)
+	for k, line := range script {
+		aBefore[k+1], bBefore[k+1] = aBefore[k], bBefore[k]
+		if line.op != '+' {
+			aBefore[k+1]++
+		}
+		if line.op != '-' {
+			bBefore[k+1]++
+		}
+	}
+	hunkStart := func(before, count int) int {
+		if count == 0 {
+			return before
+		}
+		return before + 1
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for k := 0; k < len(script); {
+		for k < len(script) && script[k].op == ' ' {
+			k++
+		}
+		if k == len(script) {
+			break
+		}
+
+		// Changes separated by at most twice the context share a hunk
+		start := max(k-diffContext, 0)
+		end := k
+		for {
+			for end < len(script) && script[end].op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(script) && script[next].op == ' ' {
+				next++
+			}
+			if next < len(script) && next-end <= 2*diffContext {
+				end = next
+				continue
+			}
+			break
+		}
+		stop := min(end+diffContext, len(script))
+
+		aCount, bCount := aBefore[stop]-aBefore[start], bBefore[stop]-bBefore[start]
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkStart(aBefore[start], aCount), aCount, hunkStart(bBefore[start], bCount), bCount)
+		for _, line := range script[start:stop] {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+		k = stop
+	}
+	return out.String()
+}
+
+// showDiff prints how a changed text file differs from its remote copy and,
+// when someone is at the terminal, asks whether to upload it. It returns
+// false if the upload should be skipped. Binary files, files over
+// SHOW_DIFF_MAX_SIZE and files that can't be read are uploaded without a diff.
+func (sm *SyncManager) showDiff(file syncFile, remoteInfo os.FileInfo) bool {
+	maxSize := sm.config.ShowDiffMaxSize
+	if file.info.Size() > maxSize || remoteInfo.Size() > maxSize {
+		sm.verbosef("No diff for %s: larger than SHOW_DIFF_MAX_SIZE", file.relPath)
+		return true
+	}
+
+	local, err := os.ReadFile(file.localPath)
+	if err != nil {
+		sm.verbosef("No diff for %s: %v", file.relPath, err)
+		return true
+	}
+	remoteFile, err := sm.sftpClient.Open(file.remotePath)
+	if err != nil {
+		sm.verbosef("No diff for %s: %v", file.relPath, err)
+		return true
+	}
+	remote, err := io.ReadAll(remoteFile)
+	remoteFile.Close()
+	if err != nil {
+		sm.verbosef("No diff for %s: %v", file.relPath, err)
+		return true
+	}
+	if !isText(local) || !isText(remote) {
+		sm.verbosef("No diff for %s: binary file", file.relPath)
+		return true
+	}
+	if bytes.Equal(local, remote) {
+		return true
+	}
+
+	rel := strings.ReplaceAll(file.relPath, "\\", "/")
+	fmt.Printf("\n%s", unifiedDiff("remote/"+rel, "local/"+rel, splitLines(string(remote)), splitLines(string(local))))
+
+	// Parallel targets and dry runs never stop to ask
+	if sm.config.DryRun || sm.quiet || sm.config.BatchMode || !stdinIsTerminal() {
+		return true
+	}
+	return confirmAction(fmt.Sprintf("Upload %s?", rel))
+}
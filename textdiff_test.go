@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{text: "", want: nil},
+		{text: "one", want: []string{"one"}},
+		{text: "one\n", want: []string{"one"}},
+		{text: "one\ntwo\n", want: []string{"one", "two"}},
+		{text: "one\n\ntwo", want: []string{"one", "", "two"}},
+		{text: "\n", want: []string{""}},
+	}
+	for _, tt := range tests {
+		if got := splitLines(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitLines(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIsText(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want bool
+	}{
+		{data: nil, want: true},
+		{data: []byte("plain text\n"), want: true},
+		{data: []byte("grüße\n"), want: true},
+		{data: []byte("nul\x00byte"), want: false},
+		{data: []byte{0xff, 0xfe, 'a'}, want: false},
+	}
+	for _, tt := range tests {
+		if got := isText(tt.data); got != tt.want {
+			t.Errorf("isText(%q) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	numbers := func(from, to int) []string {
+		var lines []string
+		for i := from; i <= to; i++ {
+			lines = append(lines, fmt.Sprint(i))
+		}
+		return lines
+	}
+	replace := func(lines []string, at int, text string) []string {
+		lines = append([]string(nil), lines...)
+		lines[at] = text
+		return lines
+	}
+	tests := []struct {
+		name string
+		a, b []string
+		want string
+	}{
+		{name: "unchanged", a: numbers(1, 3), b: numbers(1, 3), want: ""},
+		{name: "added to empty", a: nil, b: []string{"x"}, want: "@@ -0,0 +1,1 @@\n+x\n"},
+		{name: "emptied", a: []string{"x"}, b: nil, want: "@@ -1,1 +0,0 @@\n-x\n"},
+		{
+			name: "changed and appended",
+			a:    numbers(1, 10),
+			b:    append(replace(numbers(1, 10), 1, "two"), "11"),
+			want: "@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n@@ -8,3 +8,4 @@\n 8\n 9\n 10\n+11\n",
+		},
+		{
+			name: "nearby changes share a hunk",
+			a:    numbers(1, 10),
+			b:    replace(replace(numbers(1, 10), 1, "two"), 7, "eight"),
+			want: "@@ -1,10 +1,10 @@\n 1\n-2\n+two\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n 9\n 10\n",
+		},
+		{
+			name: "removed line",
+			a:    numbers(1, 5),
+			b:    []string{"1", "2", "4", "5"},
+			want: "@@ -1,5 +1,4 @@\n 1\n 2\n-3\n 4\n 5\n",
+		},
+	}
+	for _, tt := range tests {
+		got := unifiedDiff("remote/f", "local/f", tt.a, tt.b)
+		want := "--- remote/f\n+++ local/f\n" + tt.want
+		if got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+
+	// Two unrelated large files are reported without a table of lines
+	a, b := numbers(1, 3000), numbers(5001, 8000)
+	if got := unifiedDiff("remote/f", "local/f", a, b); !strings.Contains(got, "too many changes") {
+		t.Errorf("large rewrite: got %d bytes of diff, want the too many changes note", len(got))
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// phaseNames lists the timed phases of a run in the order they happen
+var phaseNames = []string{"scan", "transfer", "verify", "stop", "build", "run", "health"}
+
+// timePhase adds the time since start to a phase; with several folder pairs
+// the scan and transfer times add up
+func (sm *SyncManager) timePhase(phase string, start time.Time) {
+	if sm.phases == nil {
+		sm.phases = make(map[string]time.Duration)
+	}
+	sm.phases[phase] += time.Since(start)
+}
+
+// phaseTimings returns the phases that ran, plus the total, for the summary file
+func (sm *SyncManager) phaseTimings() map[string]string {
+	timings := make(map[string]string, len(sm.phases)+1)
+	for phase, d := range sm.phases {
+		timings[phase] = d.Round(time.Millisecond).String()
+	}
+	timings["total"] = sm.result.Duration
+	return timings
+}
+
+// formatTimings renders a result's phase timings as one line, in run order
+func formatTimings(r *SyncResult) string {
+	var parts []string
+	for _, phase := range append(phaseNames, "total") {
+		if d, ok := r.Timings[phase]; ok {
+			parts = append(parts, fmt.Sprintf("%s %s", phase, d))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
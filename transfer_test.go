@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// benchmarkFile writes a file of random bytes to a temporary directory
+func benchmarkFile(b *testing.B, size int) string {
+	b.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		b.Fatal(err)
+	}
+	localPath := filepath.Join(b.TempDir(), "upload.bin")
+	if err := os.WriteFile(localPath, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+	return localPath
+}
+
+// benchmarkUpload times uploadFile through the in-process SFTP server of
+// TRANSPORT: local, so the numbers show the SFTP overhead without a network
+func benchmarkUpload(b *testing.B, config *Config, localPath string) {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	config.Transport = "local"
+	sm, err := NewSyncManager(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := sm.connectLocal(); err != nil {
+		b.Fatal(err)
+	}
+	defer sm.Close()
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+	remotePath := filepath.ToSlash(filepath.Join(b.TempDir(), "upload.bin"))
+	b.SetBytes(info.Size())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sm.uploadFile(localPath, remotePath, 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUploadConcurrencyPerFile uploads a file over the
+// concurrentTransferThreshold with several CONCURRENCY_PER_FILE values
+func BenchmarkUploadConcurrencyPerFile(b *testing.B) {
+	localPath := benchmarkFile(b, 4*concurrentTransferThreshold)
+	for _, n := range []int{0, 1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", n), func(b *testing.B) {
+			benchmarkUpload(b, &Config{ConcurrencyPerFile: n}, localPath)
+		})
+	}
+}
+
+// copyBufferSizes are the COPY_BUFFER_SIZE values benchmarked, 0 for io.Copy
+var copyBufferSizes = []int64{0, 32 << 10, 256 << 10, 1 << 20}
+
+// BenchmarkUploadCopyBufferSize uploads a file under the
+// concurrentTransferThreshold, which goes through copyFile, with several
+// COPY_BUFFER_SIZE values
+func BenchmarkUploadCopyBufferSize(b *testing.B) {
+	localPath := benchmarkFile(b, concurrentTransferThreshold-1)
+	for _, size := range copyBufferSizes {
+		b.Run(fmt.Sprintf("buffer=%dKB", size>>10), func(b *testing.B) {
+			benchmarkUpload(b, &Config{CopyBufferSize: size}, localPath)
+		})
+	}
+}
+
+// BenchmarkCopyFile times copyFile alone between two local files
+func BenchmarkCopyFile(b *testing.B) {
+	localPath := benchmarkFile(b, concurrentTransferThreshold)
+	dstPath := filepath.Join(b.TempDir(), "copy.bin")
+	for _, size := range copyBufferSizes {
+		b.Run(fmt.Sprintf("buffer=%dKB", size>>10), func(b *testing.B) {
+			sm := &SyncManager{config: &Config{CopyBufferSize: size}}
+			b.SetBytes(concurrentTransferThreshold)
+			for i := 0; i < b.N; i++ {
+				src, err := os.Open(localPath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				dst, err := os.Create(dstPath)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = sm.copyFile(dst, src)
+				src.Close()
+				if closeErr := dst.Close(); err == nil {
+					err = closeErr
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// validateConfig checks a config file against the known keys and their
+// values, reporting each problem with its file and line, then loads it to
+// run the checks that involve several keys. Flags are checked too. It
+// returns the exit code: 0 if the config is valid, 1 if not.
+func validateConfig(filename string, overrides map[string]string) int {
+	problems := 0
+	report := func(where, format string, args ...any) {
+		problems++
+		fmt.Printf("❌ %s: %s\n", where, fmt.Sprintf(format, args...))
+	}
+
+	scratch := defaultConfig()
+	if filename != "" {
+		f, err := os.Open(filename)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			return 1
+		}
+		defer f.Close()
+
+		seen := make(map[string]int)
+		scanner := bufio.NewScanner(f)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			where := fmt.Sprintf("%s:%d", filename, lineNo)
+			line := strings.TrimSpace(scanner.Text())
+			key, value, ok := splitConfigLine(line)
+			if !ok {
+				if line != "" && !strings.HasPrefix(line, "#") {
+					report(where, "expected KEY: value, got %q", line)
+				}
+				continue
+			}
+			k, known := lookupConfigKey(key)
+			if !known {
+				if suggestion := closestConfigKey(key); suggestion != "" {
+					report(where, "unknown key %s (did you mean %s?)", key, suggestion)
+				} else {
+					report(where, "unknown key %s", key)
+				}
+				continue
+			}
+			if err := scratch.setValue(key, value); err != nil {
+				report(where, "invalid value for %s: %v", key, err)
+				continue
+			}
+			if first, dup := seen[key]; dup && !k.repeatable {
+				fmt.Printf("⚠️  %s: %s is also set on line %d; this value replaces it\n", where, key, first)
+			}
+			seen[key] = lineNo
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("❌ Error reading %s: %v", filename, err)
+			return 1
+		}
+	}
+
+	// Flags in a stable order, so the report doesn't change between runs
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		where := "--" + configKeyToFlag(key)
+		if _, known := lookupConfigKey(key); !known {
+			if suggestion := closestConfigKey(key); suggestion != "" {
+				report(where, "unknown flag (did you mean --%s?)", configKeyToFlag(suggestion))
+			} else {
+				report(where, "unknown flag")
+			}
+			continue
+		}
+		if err := scratch.setValue(key, overrides[key]); err != nil {
+			report(where, "invalid value: %v", err)
+		}
+	}
+
+	source := filename
+	if source == "" {
+		source = "command line"
+	}
+	var missing []string
+	if scratch.RemoteFolder == "" {
+		missing = append(missing, "REMOTE_FOLDER")
+	}
+	if scratch.Transport != "local" {
+		if scratch.RemoteServer == "" {
+			missing = append(missing, "REMOTE_SERVER")
+		}
+		if scratch.SSHUsername == "" {
+			missing = append(missing, "SSH_USERNAME")
+		}
+	}
+	if scratch.DockerImageName == "" && !scratch.SyncOnly {
+		missing = append(missing, "DOCKER_IMAGE_NAME")
+	}
+	for _, key := range missing {
+		report(source, "missing required key %s", key)
+	}
+
+	// The checks across keys are the ones a run makes, so only load a config
+	// that passed the ones above. A password pooshit would ask for is taken
+	// as given, so nothing is prompted for.
+	if problems == 0 {
+		loadOverrides := make(map[string]string, len(overrides)+1)
+		for key, value := range overrides {
+			loadOverrides[key] = value
+		}
+		if scratch.SSHPassword == "" && scratch.usesAuth("password") {
+			loadOverrides["SSH_PASSWORD"] = "unchecked"
+		}
+		if _, err := LoadConfig(filename, loadOverrides); err != nil {
+			report(source, "%v", err)
+		}
+	}
+
+	if problems > 0 {
+		noun := "problems"
+		if problems == 1 {
+			noun = "problem"
+		}
+		log.Printf("%d %s found in %s", problems, noun, source)
+		return 1
+	}
+	log.Printf("✅ %s is valid", source)
+	return 0
+}
+
+// closestConfigKey returns the known key closest to a misspelled one, or "" if none is close
+func closestConfigKey(name string) string {
+	name = strings.ToUpper(name)
+	best, bestDistance := "", 4
+	for _, key := range configKeys {
+		if d := editDistance(name, key.name); d < bestDistance {
+			best, bestDistance = key.name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// verifyBatchSize is how many files are checksummed per remote md5sum call
+const verifyBatchSize = 100
+
+// verifyFolder re-checks every synced file on the remote after the transfer:
+// the size always, and the MD5 checksum too in VERIFY_AFTER: checksum mode.
+// Mismatches are recorded in the result and fail the sync.
+func (sm *SyncManager) verifyFolder(files []syncFile, result *FolderResult) error {
+	log.Printf("🔍 Verifying %d files on the remote (%s)...", len(files), sm.config.VerifyAfter)
+
+	mismatch := func(file syncFile, reason string) {
+		result.Mismatches = append(result.Mismatches, file.relPath)
+		log.Printf("❌ %s: %s", file.relPath, reason)
+	}
+
+	var sizeOK []syncFile
+	for _, file := range files {
+		remoteInfo, err := sm.remoteStat(file.remotePath)
+		if err == nil && isSymlink(remoteInfo) {
+			// Not written by the push, with SYMLINK_STAT: nofollow
+			continue
+		}
+		if err != nil {
+			mismatch(file, fmt.Sprintf("missing on remote (%v)", err))
+			continue
+		}
+		if remoteInfo.Size() != file.info.Size() {
+			mismatch(file, fmt.Sprintf("size %d on remote, %d locally", remoteInfo.Size(), file.info.Size()))
+			continue
+		}
+		sizeOK = append(sizeOK, file)
+	}
+
+	if sm.config.VerifyAfter == "checksum" {
+		for start := 0; start < len(sizeOK); start += verifyBatchSize {
+			end := start + verifyBatchSize
+			if end > len(sizeOK) {
+				end = len(sizeOK)
+			}
+			batch := sizeOK[start:end]
+
+			remoteSums, err := sm.remoteChecksums(batch)
+			if err != nil {
+				return err
+			}
+			for _, file := range batch {
+				localSum, err := localChecksum(file.localPath)
+				if err != nil {
+					return err
+				}
+				if remoteSums[file.remotePath] != localSum {
+					mismatch(file, "checksum differs from the local file")
+				}
+			}
+		}
+	}
+
+	result.Verified = len(files) - len(result.Mismatches)
+	if len(result.Mismatches) > 0 {
+		return fmt.Errorf("verification failed: %d of %d files differ on the remote", len(result.Mismatches), len(files))
+	}
+	log.Printf("✅ Verified %d files", result.Verified)
+	return nil
+}
+
+// remoteChecksums returns the remote MD5 of each file, keyed by remote path
+func (sm *SyncManager) remoteChecksums(files []syncFile) (map[string]string, error) {
+	args := make([]string, len(files))
+	for i, file := range files {
+		args[i] = shellQuote(file.remotePath)
+	}
+
+	session, err := sm.newSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer sm.closeSession(session)
+
+	output, err := session.Output("md5sum -- " + strings.Join(args, " "))
+	if err != nil {
+		return nil, fmt.Errorf("failed to checksum remote files (checksum verification needs md5sum on the remote): %w", err)
+	}
+
+	sums := make(map[string]string, len(files))
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// "<hash>  <path>"; paths with newlines or backslashes are escaped
+		// by md5sum and simply won't match, which reports them as differing
+		sum, path, found := strings.Cut(scanner.Text(), "  ")
+		if found {
+			sums[path] = sum
+		}
+	}
+	return sums, nil
+}
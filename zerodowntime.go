@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// defaultHealthCheckTimeout is how long a new container gets to become healthy
+const defaultHealthCheckTimeout = 30 * time.Second
+
+// healthSettleTime is how long a container without a HEALTHCHECK must keep
+// running before it is considered up
+const healthSettleTime = 3 * time.Second
+
+// zeroDowntimeDeploy builds and starts the new container while the old one is
+// still serving, and only stops the old containers once the new one is healthy.
+// If the build, start or health check fails, the old containers are left running.
+func (sm *SyncManager) zeroDowntimeDeploy(remotePath string) error {
+	docker := sm.dockerCmd()
+	image := sm.config.DockerImageName
+
+	// Remember what is running now: once the tag moves to the new build,
+	// the ancestor filter and the image name no longer find the old ones
+	output, err := sm.executeRemoteCommandWithOutput(sm.listContainersCommand(), false)
+	if err != nil {
+		return fmt.Errorf("failed to list running containers: %w", err)
+	}
+	oldContainers := strings.Fields(output)
+	oldImage, _ := sm.executeRemoteCommandWithOutput(fmt.Sprintf("%s image inspect -f '{{.Id}}' %s 2>/dev/null", docker, image), false)
+	oldImage = strings.TrimSpace(oldImage)
+	imageCheck, err := sm.startImageCheck()
+	if err != nil {
+		return fmt.Errorf("%w; old containers left running", err)
+	}
+
+	log.Printf("🔨 Building new image: %s (%d old container(s) keep running)", image, len(oldContainers))
+	sm.result.ImageTag = image
+	buildStart := time.Now()
+	err = sm.executeRemoteCommandWithProgress(sm.buildCommand(remotePath))
+	sm.timePhase("build", buildStart)
+	if err != nil {
+		return fmt.Errorf("failed to build Docker image, old containers left running: %w", err)
+	}
+	if err := sm.verifyBuiltImage(imageCheck); err != nil {
+		return fmt.Errorf("%w; old containers left running", err)
+	}
+	sm.tagBuild()
+
+	if err := sm.runHook("BEFORE_RUN_CMD", sm.config.BeforeRunCmd, remotePath); err != nil {
+		return fmt.Errorf("%w; old containers left running", err)
+	}
+
+	log.Printf("▶️  Starting new container: %s", image)
+	runStart := time.Now()
+	output, err = sm.executeRemoteCommandWithOutput(sm.runCommand(), true)
+	sm.timePhase("run", runStart)
+	if err != nil {
+		return fmt.Errorf("failed to run Docker container, old containers left running: %w", err)
+	}
+	newContainer, _ := parseContainerID(output)
+	if newContainer == "" {
+		return fmt.Errorf("docker run printed no container ID; ZERO_DOWNTIME needs DOCKER_RUN_ARGS to include -d")
+	}
+
+	log.Printf("🩺 Waiting for container %s to become healthy (up to %s)", shortID(newContainer), sm.config.HealthCheckTimeout)
+	healthStart := time.Now()
+	err = sm.waitHealthy(newContainer)
+	sm.timePhase("health", healthStart)
+	if err != nil {
+		logs, _ := sm.executeRemoteCommandWithOutput(fmt.Sprintf("%s logs --tail 20 %s 2>&1", docker, newContainer), false)
+		if strings.TrimSpace(logs) != "" {
+			log.Printf("Container logs:\n%s", logs)
+		}
+		sm.executeRemoteCommandQuiet(fmt.Sprintf("%s rm -f %s", docker, newContainer))
+		return fmt.Errorf("new container failed its health check, old containers left running: %w", err)
+	}
+	if err := sm.recordContainer(output); err != nil {
+		return fmt.Errorf("%w; old containers left running", err)
+	}
+
+	if err := sm.runHook("BEFORE_STOP_CMD", sm.config.BeforeStopCmd, remotePath); err != nil {
+		return fmt.Errorf("%w; old and new containers left running", err)
+	}
+
+	if len(oldContainers) > 0 {
+		log.Printf("🐳 Stopping %d old container(s)", len(oldContainers))
+		stopStart := time.Now()
+		ids := strings.Join(oldContainers, " ")
+		if err := sm.executeRemoteCommandQuiet(fmt.Sprintf("%s stop %s | xargs -r %s rm", docker, ids, docker)); err != nil {
+			log.Printf("⚠️  Failed to remove some old containers: %v", err)
+		}
+		sm.timePhase("stop", stopStart)
+	}
+
+	// The old image is now untagged; it can go once nothing uses it
+	if sm.config.RemoveOldImage && oldImage != "" {
+		log.Printf("🗑️  Removing old image: %s", shortID(oldImage))
+		sm.executeRemoteCommandQuiet(fmt.Sprintf("%s image inspect -f '{{.Id}}' %s | grep -qx %s || %s rmi %s 2>/dev/null || true",
+			docker, image, oldImage, docker, oldImage))
+	}
+	if err := sm.runHook("AFTER_RUN_CMD", sm.config.AfterRunCmd, remotePath); err != nil {
+		return err
+	}
+	sm.pruneImages()
+
+	log.Println("\n✨ Docker operations completed successfully!")
+	return nil
+}
+
+// waitHealthy waits until the container reports healthy, or, for images
+// without a HEALTHCHECK, until it has stayed running for healthSettleTime
+func (sm *SyncManager) waitHealthy(container string) error {
+	cmd := fmt.Sprintf("%s inspect -f '{{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}' %s", sm.dockerCmd(), container)
+	deadline := time.Now().Add(sm.config.HealthCheckTimeout)
+	var runningSince time.Time
+
+	for {
+		output, err := sm.executeRemoteCommandWithOutput(cmd, false)
+		if err != nil {
+			return fmt.Errorf("failed to inspect container: %s", strings.TrimSpace(output))
+		}
+		fields := strings.Fields(output)
+		status, health := "", ""
+		if len(fields) > 0 {
+			status = fields[0]
+		}
+		if len(fields) > 1 {
+			health = fields[1]
+		}
+
+		switch {
+		case status != "running" && status != "created":
+			return fmt.Errorf("container is %s", status)
+		case health == "healthy":
+			return nil
+		case health == "unhealthy":
+			return fmt.Errorf("container reported unhealthy")
+		case health == "" && status == "running":
+			if runningSince.IsZero() {
+				runningSince = time.Now()
+			} else if time.Since(runningSince) >= healthSettleTime {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not healthy after %s (status %s %s)", sm.config.HealthCheckTimeout, status, health)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// shortID shortens a Docker container or image ID for log messages
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}